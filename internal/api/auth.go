@@ -0,0 +1,71 @@
+package api
+
+import (
+	"github/xujialingit/shopping-app/internal/config"
+	"github/xujialingit/shopping-app/pkg/core"
+	"github/xujialingit/shopping-app/pkg/pkg/response"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"net/http"
+)
+
+//Auth 校验 Authorization 中的jwt，通过后 ctx.UserID() ctx.UserName() ctx.Claims() 可用
+//会话已经注销（退出登录、修改密码、管理员强制下线）的token在过期前也不能再使用
+func (s *Server) Auth() core.HandlerFunc {
+	return core.WarpClaimsAuthHandler(func(ctx core.Context) (*token.Claims, response.Error) {
+		claims, err := Token().JwtParseFromAuthorizationHeader(ctx.GetHeader("Authorization"))
+		if err != nil {
+			return nil, response.NewErrorAutoMsg(http.StatusUnauthorized, response.AuthorizationError)
+		}
+		active, err := s.Login.SessionActive(ctx.SvcContext().Context(), claims.UserID, claims.SessionID)
+		if err != nil {
+			return nil, response.NewErrorAutoMsg(http.StatusInternalServerError, response.ServerError).WithErr(err)
+		}
+		if !active {
+			return nil, response.NewErrorAutoMsg(http.StatusUnauthorized, response.AuthorizationError)
+		}
+		return claims, nil
+	})
+}
+
+//SystemAuth /system 下管理接口的鉴权，只允许 [server].adminUserIds 中的用户和 user create-admin 创建的管理员
+//[mfa].requireForAdmins 为true时还需要是二次验证登录的token
+func (s *Server) SystemAuth() core.HandlerFunc {
+	auth := s.Auth()
+	return func(ctx core.Context) {
+		auth(ctx)
+		if ctx.RequestContext().IsAborted() {
+			return
+		}
+		admin, err := s.isAdmin(ctx)
+		if err != nil {
+			ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusInternalServerError, response.ServerError).WithErr(err))
+			return
+		}
+		if !admin {
+			ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusForbidden, response.PermissionDenied))
+			return
+		}
+		if config.Get().Mfa.RequireForAdmins {
+			RequireMFA()(ctx)
+		}
+	}
+}
+
+func (s *Server) isAdmin(ctx core.Context) (bool, error) {
+	for _, id := range config.Get().Server.AdminUserIDs {
+		if id == ctx.UserID() {
+			return true, nil
+		}
+	}
+	return s.User.IsAdmin(ctx.SvcContext().Context(), ctx.UserID())
+}
+
+//RequireMFA 只允许二次验证登录的token访问，用于商家管理商品、退款等接口，需要放在 Auth 之后
+func RequireMFA() core.HandlerFunc {
+	return func(ctx core.Context) {
+		if claims := ctx.Claims(); claims != nil && claims.HasAMR(token.AMROTP) {
+			return
+		}
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusForbidden, response.MFARequired))
+	}
+}
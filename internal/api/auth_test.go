@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github/xujialingit/shopping-app/internal/config"
+	"github/xujialingit/shopping-app/internal/user"
+	"github/xujialingit/shopping-app/pkg/cache/cachetest"
+	"github/xujialingit/shopping-app/pkg/core"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//fakeAdmins 只实现 IsAdmin
+type fakeAdmins struct {
+	user.Service
+	admins map[int64]bool
+}
+
+func (f fakeAdmins) IsAdmin(ctx context.Context, userId int64) (bool, error) {
+	return f.admins[userId], nil
+}
+
+func TestAuth(t *testing.T) {
+	repo := cachetest.New(t)
+
+	SetToken(token.New("test_secret"), nil)
+	var cfg config.Config
+	cfg.Jwt.ExpireDuration = time.Minute
+	cfg.Jwt.RefreshDuration = time.Hour
+	s := &Server{Login: NewLogin(cfg, repo), User: fakeAdmins{admins: map[int64]bool{3: true}}}
+
+	engine, err := core.New("api", zap.NewNop(), core.WithDisablePProf(), core.WithDisableSwagger(), core.WithDisablePrometheus())
+	require.NoError(t, err)
+	sessions := engine.Group("/sessions")
+	sessions.Use(s.Auth())
+	s.sessionRoutes(sessions)
+	engine.Group("/system").GET("", s.SystemAuth(), func(ctx core.Context) {
+		ctx.Payload("ok")
+	})
+
+	ctx := context.Background()
+	login := func(userId int) string {
+		resp, err := s.Login.GenerateToken(ctx, userId, "tom")
+		require.NoError(t, err)
+		return resp.Token.(*model.LoginResponseByRefreshToekn).AccessToken
+	}
+	get := func(path, accessToken string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+	list := func(accessToken string) int {
+		return get("/api/sessions", accessToken)
+	}
+	sessionID := func(accessToken string) string {
+		claims, err := Token().JwtParse(accessToken)
+		require.NoError(t, err)
+		return claims.SessionID
+	}
+
+	t.Run("会话注销后token失效", func(t *testing.T) {
+		accessToken := login(1)
+		assert.Equal(t, http.StatusOK, list(accessToken))
+
+		//未过期的access token也不能再使用
+		require.NoError(t, s.Login.RevokeSession(ctx, 1, sessionID(accessToken)))
+		assert.Equal(t, http.StatusUnauthorized, list(accessToken))
+	})
+
+	t.Run("修改密码后其他设备的token失效", func(t *testing.T) {
+		current, other := login(2), login(2)
+		assert.Equal(t, http.StatusOK, list(other))
+
+		//修改密码时注销当前会话之外的全部会话
+		require.NoError(t, s.Login.RevokeOtherSessions(ctx, 2, sessionID(current)))
+		assert.Equal(t, http.StatusOK, list(current))
+		assert.Equal(t, http.StatusUnauthorized, list(other))
+	})
+
+	t.Run("create-admin创建的管理员可以访问管理接口", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, get("/api/system", login(3)))
+		assert.Equal(t, http.StatusForbidden, get("/api/system", login(4)))
+	})
+}
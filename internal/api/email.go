@@ -0,0 +1,43 @@
+package api
+
+import (
+	"errors"
+	"github/xujialingit/shopping-app/internal/user"
+	"github/xujialingit/shopping-app/pkg/core"
+	"github/xujialingit/shopping-app/pkg/pkg/response"
+	"github/xujialingit/shopping-app/pkg/pkg/verifycode"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type sendEmailCodeRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Type  string `json:"type" binding:"required"` //register reset_password change_email unlock
+}
+
+//sendEmailCode 发送邮件验证码
+//  POST /email/code
+func (s *Server) sendEmailCode(ctx core.Context) {
+	var req sendEmailCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.ParamBindError).WithErr(err))
+		return
+	}
+	purpose, err := verifycode.ParsePurpose(req.Type)
+	if err != nil || smsPurposes[purpose] {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.EmailCodeTypeError))
+		return
+	}
+
+	err = s.EmailCode.Send(ctx.SvcContext().Context(), purpose, user.NormalizeEmail(req.Email))
+	if errors.Is(err, verifycode.ErrCooldown) {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusTooManyRequests, response.TooManyRequests))
+		return
+	}
+	if err != nil {
+		ctx.Logger().Error("发送邮件验证码失败", zap.Error(err))
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusInternalServerError, response.SendEamilCodeError).WithErr(err))
+		return
+	}
+	ctx.Payload(nil)
+}
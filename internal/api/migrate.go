@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github/xujialingit/shopping-app/internal/user"
+	"github/xujialingit/shopping-app/pkg/db"
+	"github/xujialingit/shopping-app/pkg/outbox"
+	"io"
+
+	"gorm.io/gorm"
+)
+
+//Models 需要迁移的表，新增模块时在这里注册
+func Models() []interface{} {
+	return []interface{}{
+		&outbox.Event{},
+		&user.User{},
+		&user.MFA{},
+		&user.RecoveryCode{},
+		&user.OAuthAccount{},
+	}
+}
+
+//Migrate 自动迁移全部表
+func Migrate(ctx context.Context, repo db.Repo) error {
+	return repo.GetDb(ctx).AutoMigrate(Models()...)
+}
+
+//Fixture 初始化数据，按顺序插入，同一个文件在一个事务中
+//  [{"table": "product", "rows": [{"name": "apple", "price": 10}]}]
+type Fixture struct {
+	Table string                   `json:"table"`
+	Rows  []map[string]interface{} `json:"rows"`
+}
+
+//Seed 读取json格式的Fixture并插入，返回插入的行数
+func Seed(ctx context.Context, repo db.Repo, r io.Reader) (int, error) {
+	var fixtures []Fixture
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&fixtures); err != nil {
+		return 0, fmt.Errorf("解析fixture失败:%w", err)
+	}
+
+	count := 0
+	err := repo.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, fixture := range fixtures {
+			if fixture.Table == "" {
+				return fmt.Errorf("fixture缺少table")
+			}
+			for _, row := range fixture.Rows {
+				if err := tx.Table(fixture.Table).Create(row).Error; err != nil {
+					return fmt.Errorf("插入%s失败:%w", fixture.Table, err)
+				}
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
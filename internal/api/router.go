@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github/xujialingit/shopping-app/internal/user"
+	"github/xujialingit/shopping-app/pkg/core"
+)
+
+//Route 注册业务接口
+func (s *Server) Route(engine core.Engine) {
+	sessions := engine.Group("/sessions")
+	sessions.Use(s.Auth())
+	s.sessionRoutes(sessions)
+
+	email := engine.Group("/email")
+	if s.Captcha != nil {
+		//发送邮件前需要人机校验，注册、重置密码、解锁都依赖邮件验证码
+		email.Use(core.RequireCaptcha(s.Captcha))
+	}
+	email.POST("/code", s.sendEmailCode)
+
+	sms := engine.Group("/sms")
+	if s.Captcha != nil {
+		sms.Use(core.RequireCaptcha(s.Captcha))
+	}
+	sms.POST("/code", s.sendSMSCode)
+
+	options := make([]user.HandlerOption, 0)
+	if s.Captcha != nil {
+		options = append(options, user.WithLoginCaptcha(s.Captcha))
+	}
+	user.NewHandler(s.User, s.Login, options...).Route(engine.Group("/user"), s.Auth())
+
+	system := engine.Group("/system")
+	system.POST("/users/:id/logout", s.SystemAuth(), s.forceLogout)
+	system.POST("/users/:id/unlock", s.SystemAuth(), s.unlockUser)
+}
@@ -3,10 +3,20 @@ package api
 import (
 	"errors"
 	"github/xujialingit/shopping-app/internal/config"
+	"github/xujialingit/shopping-app/internal/user"
 	"github/xujialingit/shopping-app/pkg/cache"
 	"github/xujialingit/shopping-app/pkg/db"
+	"github/xujialingit/shopping-app/pkg/pkg/captcha"
+	"github/xujialingit/shopping-app/pkg/pkg/lockout"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"github/xujialingit/shopping-app/pkg/pkg/login"
+	"github/xujialingit/shopping-app/pkg/pkg/oauth"
+	"github/xujialingit/shopping-app/pkg/pkg/pii"
+	"github/xujialingit/shopping-app/pkg/pkg/totp"
+	"github/xujialingit/shopping-app/pkg/pkg/verifycode"
 	"go.uber.org/zap"
 	"net/http"
+	"reflect"
 )
 
 //Server类包含了Server集成的插件
@@ -15,6 +25,13 @@ type Server struct {
 	DB         db.Repo
 	HttpServer *http.Server
 	Cache      cache.Repo
+	LogLevels  *logger.Levels //运行时修改日志级别
+	Login      *login.RefreshTokenSystem
+	EmailCode  verifycode.Service //邮件验证码
+	SMSCode    verifycode.Service //短信验证码
+	User       user.Service
+	Lockout    lockout.Guard   //登录失败次数限制
+	Captcha    captcha.Service //图形验证码，captcha.enabled为false时为nil
 }
 
 func NewApiServer(logger *zap.Logger) (*Server, error) {
@@ -26,7 +43,77 @@ func NewApiServer(logger *zap.Logger) (*Server, error) {
 
 	cfg := config.Get()
 
-	dbRepo, err := db.New(&db.DBConfig{
+	dbRepo, err := NewDB(cfg)
+	if err != nil {
+		logger.Fatal("连接数据库失败", zap.Error(err))
+	}
+	s.DB = dbRepo
+
+	//redis缓存
+	cacheRepo, err := NewCache(cfg)
+	if err != nil {
+		logger.Fatal("redis服务开启失败！", zap.Error(err))
+	}
+	s.Cache = cacheRepo
+
+	//jwt签名，轮换key后不需要重启
+	tok, keys, err := NewToken(cfg)
+	if err != nil {
+		logger.Fatal("加载jwt签名key失败！", zap.Error(err))
+	}
+	SetToken(tok, keys)
+	s.Login = NewLogin(cfg, cacheRepo)
+	s.EmailCode = NewEmailCode(cfg, cacheRepo)
+	s.SMSCode = NewSMSCode(cfg, cacheRepo, logger)
+	s.Lockout = NewLockout(cfg, cacheRepo)
+	if cfg.Captcha.Enabled {
+		s.Captcha = NewCaptcha(cfg, cacheRepo)
+	}
+	s.User = user.New(dbRepo, s.Login, s.EmailCode,
+		user.WithLockout(s.Lockout),
+		user.WithSMS(s.SMSCode),
+		user.WithOAuth(NewOAuth(cfg, cacheRepo)),
+		user.WithMFA(NewMFA(cfg, s.Login, cacheRepo), totp.New(totp.WithSkew(cfg.Mfa.Skew)), cfg.Mfa.Issuer),
+	)
+	config.Subscribe(func(old, new config.Config) {
+		if reflect.DeepEqual(old.Jwt, new.Jwt) {
+			return
+		}
+		tok, keys, err := NewToken(new)
+		if err != nil {
+			logger.Error("更新jwt签名key失败！", zap.Error(err))
+			return
+		}
+		SetToken(tok, keys)
+	})
+
+	//敏感字段加密密钥
+	keyring, err := NewKeyring(cfg)
+	if err != nil {
+		logger.Fatal("加载敏感字段加密密钥失败！", zap.Error(err))
+	}
+	pii.SetDefault(keyring)
+
+	//密钥轮换后不需要重启
+	config.Subscribe(func(old, new config.Config) {
+		if reflect.DeepEqual(old.Pii, new.Pii) {
+			return
+		}
+		keyring, err := NewKeyring(new)
+		if err != nil {
+			logger.Error("更新敏感字段加密密钥失败！", zap.Error(err))
+			return
+		}
+		pii.SetDefault(keyring)
+	})
+
+	return s, nil
+}
+
+//NewDB 按配置连接数据库，migrate seed 等命令只需要数据库
+func NewDB(cfg config.Config) (db.Repo, error) {
+	return db.New(&db.DBConfig{
+		Driver:          cfg.Mysql.Base.Driver,
 		User:            cfg.Mysql.Base.User,
 		Pass:            cfg.Mysql.Base.Pass,
 		Addr:            cfg.Mysql.Base.Addr,
@@ -36,14 +123,11 @@ func NewApiServer(logger *zap.Logger) (*Server, error) {
 		ConnMaxLifeTime: cfg.Mysql.Base.ConnMaxLifeTime,
 		ServerName:      cfg.Server.ServerName,
 	})
+}
 
-	if err != nil {
-		logger.Fatal("连接数据库失败", zap.Error(err))
-	}
-	s.DB = dbRepo
-
-	//redis缓存
-	cacheRepo, err := cache.New(cfg.Server.ServerName, &cache.RedisConf{
+//NewCache 按配置连接redis
+func NewCache(cfg config.Config) (cache.Repo, error) {
+	return cache.New(cfg.Server.ServerName, &cache.RedisConf{
 		Addr:         cfg.Redis.Addr,
 		Pass:         cfg.Redis.Pass,
 		Db:           cfg.Redis.Db,
@@ -51,11 +135,133 @@ func NewApiServer(logger *zap.Logger) (*Server, error) {
 		PoolSize:     cfg.Redis.PoolSize,
 		MinIdleConns: cfg.Redis.MinIdleConns,
 	})
+}
 
-	if err != nil {
-		logger.Fatal("redis服务开启失败！", zap.Error(err))
+//NewLogin 刷新token方式的登录，access token使用 Token() 签发，key轮换后不需要重新创建
+func NewLogin(cfg config.Config, repo cache.Repo) *login.RefreshTokenSystem {
+	return login.NewByRefreshToken(&login.RefreshTokenConfig{
+		Secret:          cfg.Jwt.Secret,
+		ExpireDuration:  cfg.Jwt.ExpireDuration,
+		RefreshDuration: cfg.Jwt.RefreshDuration,
+		MaxSessions:     cfg.Jwt.MaxSessions,
+	}, repo, login.WithToken(Token))
+}
+
+//NewMFA 两步登录，二次验证通过后由tokens签发正式token
+func NewMFA(cfg config.Config, tokens login.LoginTokenSystem, repo cache.Repo) *login.MFATokenSystem {
+	return login.NewMFA(&login.MFAConfig{
+		PendingDuration: cfg.Mfa.PendingDuration,
+		MaxAttempts:     cfg.Mfa.MaxAttempts,
+	}, tokens, repo)
+}
+
+//NewEmailCode 邮件验证码，email.driver为file时写入文件不真正发送
+func NewEmailCode(cfg config.Config, repo cache.Repo) verifycode.Service {
+	var mailer verifycode.Mailer
+	if cfg.Email.Driver == "file" {
+		mailer = verifycode.NewFileMailer(cfg.Email.Dir)
+	} else {
+		mailer = verifycode.NewSMTPMailer(&verifycode.SMTPConfig{
+			Host:   cfg.Email.QQ.SmtpHost,
+			Port:   cfg.Email.QQ.SmtpPort,
+			Sender: cfg.Email.QQ.Sender,
+			Secret: cfg.Email.QQ.Secret,
+		})
 	}
-	s.Cache = cacheRepo
+	return verifycode.New(repo, verifycode.NewEmailSender(mailer, cfg.Email.AppName),
+		verifycode.WithKeyPrefix(cfg.Server.ServerName+":email_code:"),
+		verifycode.WithTTL(cfg.VerifyCode.TTL),
+		verifycode.WithCooldown(cfg.VerifyCode.Cooldown),
+		verifycode.WithMaxAttempts(cfg.VerifyCode.MaxAttempts),
+		verifycode.WithLength(cfg.VerifyCode.Length),
+	)
+}
 
-	return s, nil
+//NewSMSCode 短信验证码，和邮件验证码使用同样的有效期、冷却时间和错误次数
+func NewSMSCode(cfg config.Config, repo cache.Repo, logger *zap.Logger) verifycode.Service {
+	var provider verifycode.SMSProvider
+	if cfg.Sms.Driver == "log" {
+		provider = verifycode.NewLogSMSProvider(logger.Named("sms"))
+	} else {
+		provider = verifycode.NewFileSMSProvider(cfg.Sms.Dir)
+	}
+	templates := make(map[verifycode.Purpose]string, len(cfg.Sms.Templates))
+	for purpose, template := range cfg.Sms.Templates {
+		templates[verifycode.Purpose(purpose)] = template
+	}
+	return verifycode.New(repo, verifycode.NewSMSSender(provider, cfg.Sms.SignName, templates),
+		verifycode.WithKeyPrefix(cfg.Server.ServerName+":sms_code:"),
+		verifycode.WithTTL(cfg.VerifyCode.TTL),
+		verifycode.WithCooldown(cfg.VerifyCode.Cooldown),
+		verifycode.WithMaxAttempts(cfg.VerifyCode.MaxAttempts),
+		verifycode.WithLength(cfg.VerifyCode.Length),
+	)
+}
+
+//NewLockout 按配置创建登录失败次数限制
+func NewLockout(cfg config.Config, repo cache.Repo) lockout.Guard {
+	return lockout.New(repo,
+		lockout.WithKeyPrefix(cfg.Server.ServerName+":lockout:"),
+		lockout.WithMaxFailures(cfg.Lockout.MaxFailures),
+		lockout.WithMaxIPFailures(cfg.Lockout.MaxIPFailures),
+		lockout.WithWindow(cfg.Lockout.Window),
+		lockout.WithLockDuration(cfg.Lockout.LockDuration),
+		lockout.WithDelay(cfg.Lockout.DelayAfter, cfg.Lockout.BaseDelay, cfg.Lockout.MaxDelay),
+	)
+}
+
+//NewCaptcha 按配置创建图形验证码
+func NewCaptcha(cfg config.Config, repo cache.Repo) captcha.Service {
+	charset := captcha.Alnum
+	if cfg.Captcha.Charset == "digits" {
+		charset = captcha.Digits
+	}
+	return captcha.New(repo,
+		captcha.WithKeyPrefix(cfg.Server.ServerName+":captcha:"),
+		captcha.WithLength(cfg.Captcha.Length),
+		captcha.WithTTL(cfg.Captcha.TTL),
+		captcha.WithTicketTTL(cfg.Captcha.TicketTTL),
+		captcha.WithSize(cfg.Captcha.Width, cfg.Captcha.Height),
+		captcha.WithCharset(charset),
+	)
+}
+
+//NewOAuth 按配置创建第三方登录，跳过clientId为空的平台
+func NewOAuth(cfg config.Config, repo cache.Repo) oauth.Service {
+	providers := make([]oauth.Provider, 0, len(cfg.Oauth.Providers))
+	for name, p := range cfg.Oauth.Providers {
+		if p.ClientID == "" {
+			continue
+		}
+		providers = append(providers, oauth.NewProvider(&oauth.ProviderConfig{
+			Name:               name,
+			ClientID:           p.ClientID,
+			ClientSecret:       p.ClientSecret,
+			AuthURL:            p.AuthURL,
+			TokenURL:           p.TokenURL,
+			UserInfoURL:        p.UserInfoURL,
+			RedirectURL:        p.RedirectURL,
+			Scopes:             p.Scopes,
+			PKCE:               p.PKCE,
+			SubjectField:       p.SubjectField,
+			EmailField:         p.EmailField,
+			EmailVerifiedField: p.EmailVerifiedField,
+			NameField:          p.NameField,
+			AvatarField:        p.AvatarField,
+			TrustEmail:         p.TrustEmail,
+		}, nil))
+	}
+	return oauth.New(repo, providers,
+		oauth.WithKeyPrefix(cfg.Server.ServerName+":oauth:"),
+		oauth.WithStateTTL(cfg.Oauth.StateTTL),
+	)
+}
+
+//NewKeyring 按配置创建敏感字段加密密钥
+func NewKeyring(cfg config.Config) (*pii.Keyring, error) {
+	return pii.NewKeyring(&pii.Config{
+		ActiveKey: cfg.Pii.ActiveKey,
+		Keys:      cfg.Pii.Keys,
+		IndexKey:  cfg.Pii.IndexKey,
+	})
 }
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"github/xujialingit/shopping-app/internal/user"
+	"github/xujialingit/shopping-app/pkg/core"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/response"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+type sessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"` //是否是当前请求使用的会话
+}
+
+//sessionRoutes 当前用户的登录设备
+//  GET    /sessions      列出全部设备
+//  DELETE /sessions/:id  注销一个设备
+//  DELETE /sessions      注销除当前设备之外的全部设备
+func (s *Server) sessionRoutes(group core.RouteGroup) {
+	group.GET("", s.listSessions)
+	group.DELETE("/:id", s.revokeSession)
+	group.DELETE("", s.revokeOtherSessions)
+}
+
+func (s *Server) listSessions(ctx core.Context) {
+	sessions, err := s.Login.Sessions(ctx.SvcContext().Context(), ctx.UserID())
+	if err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusInternalServerError, response.ServerError).WithErr(err))
+		return
+	}
+	current := ctx.Claims().SessionID
+	list := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		list = append(list, sessionResponse{
+			ID:         session.ID,
+			Device:     session.Device,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			Current:    session.ID == current,
+		})
+	}
+	ctx.Payload(list)
+}
+
+func (s *Server) revokeSession(ctx core.Context) {
+	var uri struct {
+		ID string `uri:"id" binding:"required"`
+	}
+	if err := ctx.ShouldBindURL(&uri); err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.ParamBindError).WithErr(err))
+		return
+	}
+	err := s.Login.RevokeSession(ctx.SvcContext().Context(), ctx.UserID(), uri.ID)
+	if errors.Is(err, model.SessionNotFound) {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusNotFound, response.SessionNotFound))
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusInternalServerError, response.ServerError).WithErr(err))
+		return
+	}
+	ctx.Payload(nil)
+}
+
+func (s *Server) revokeOtherSessions(ctx core.Context) {
+	current := ctx.Claims().SessionID
+	if err := s.Login.RevokeOtherSessions(ctx.SvcContext().Context(), ctx.UserID(), current); err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusInternalServerError, response.ServerError).WithErr(err))
+		return
+	}
+	ctx.Payload(nil)
+}
+
+//forceLogout 管理员强制用户下线，注销该用户的全部会话
+//  POST /system/users/:id/logout
+func (s *Server) forceLogout(ctx core.Context) {
+	var uri struct {
+		ID int64 `uri:"id" binding:"required"`
+	}
+	if err := ctx.ShouldBindURL(&uri); err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.ParamBindError).WithErr(err))
+		return
+	}
+	if err := s.Login.RevokeOtherSessions(ctx.SvcContext().Context(), uri.ID, ""); err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusInternalServerError, response.ServerError).WithErr(err))
+		return
+	}
+	ctx.Logger().Warn("管理员强制用户下线", zap.Int64("target_user_id", uri.ID))
+	ctx.Payload(nil)
+}
+
+//unlockUser 管理员解除用户因密码错误次数过多导致的登录锁定
+//  POST /system/users/:id/unlock
+func (s *Server) unlockUser(ctx core.Context) {
+	var uri struct {
+		ID int64 `uri:"id" binding:"required"`
+	}
+	if err := ctx.ShouldBindURL(&uri); err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.ParamBindError).WithErr(err))
+		return
+	}
+	err := s.User.UnlockByAdmin(ctx.SvcContext().Context(), uri.ID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusNotFound, response.UserNotExits))
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusInternalServerError, response.ServerError).WithErr(err))
+		return
+	}
+	ctx.Logger().Warn("管理员解除用户登录锁定", zap.Int64("target_user_id", uri.ID))
+	ctx.Payload(nil)
+}
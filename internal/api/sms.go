@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"github/xujialingit/shopping-app/internal/user"
+	"github/xujialingit/shopping-app/pkg/core"
+	"github/xujialingit/shopping-app/pkg/pkg/response"
+	"github/xujialingit/shopping-app/pkg/pkg/verifycode"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+//smsPurposes 可以通过短信发送的验证码，注册、重置密码等仍然使用邮件
+var smsPurposes = map[verifycode.Purpose]bool{
+	user.PurposeSMSLogin:  true,
+	user.PurposeBindPhone: true,
+}
+
+type sendSMSCodeRequest struct {
+	Phone string `json:"phone" binding:"required"`
+	Type  string `json:"type" binding:"required"` //sms_login bind_phone
+}
+
+//sendSMSCode 发送短信验证码
+//  POST /sms/code
+func (s *Server) sendSMSCode(ctx core.Context) {
+	var req sendSMSCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.ParamBindError).WithErr(err))
+		return
+	}
+	purpose, err := verifycode.ParsePurpose(req.Type)
+	if err != nil || !smsPurposes[purpose] {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.EmailCodeTypeError))
+		return
+	}
+	phone, err := user.NormalizePhone(req.Phone)
+	if err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.PhoneInvalid))
+		return
+	}
+
+	err = s.SMSCode.Send(ctx.SvcContext().Context(), purpose, phone)
+	if errors.Is(err, verifycode.ErrCooldown) {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusTooManyRequests, response.TooManyRequests))
+		return
+	}
+	if err != nil {
+		ctx.Logger().Error("发送短信验证码失败", zap.Error(err))
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusInternalServerError, response.SendEamilCodeError).WithErr(err))
+		return
+	}
+	ctx.Payload(nil)
+}
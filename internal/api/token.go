@@ -0,0 +1,67 @@
+package api
+
+import (
+	"github/xujialingit/shopping-app/internal/config"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"sync/atomic"
+)
+
+type tokenHolder struct {
+	token token.Token
+	keys  *token.KeySet //使用secret时为空
+}
+
+//当前的token生成器，配置热更新后替换
+var tokens atomic.Value //tokenHolder
+
+//NewToken 配置了jwt.keys时使用非对称签名，否则使用jwt.secret
+func NewToken(cfg config.Config) (token.Token, *token.KeySet, error) {
+	options := []token.Option{
+		token.WithIssuer(cfg.Jwt.Issuer),
+		token.WithAudience(cfg.Jwt.Audience...),
+		token.WithLeeway(cfg.Jwt.Leeway),
+	}
+	if len(cfg.Jwt.Keys) == 0 {
+		return token.New(cfg.Jwt.Secret, options...), nil, nil
+	}
+	keyConfig := &token.KeySetConfig{ActiveKey: cfg.Jwt.ActiveKey}
+	for _, key := range cfg.Jwt.Keys {
+		keyConfig.Keys = append(keyConfig.Keys, token.KeyConfig{
+			ID:             key.ID,
+			Algorithm:      key.Algorithm,
+			PrivateKeyFile: key.PrivateKeyFile,
+			PublicKeyFile:  key.PublicKeyFile,
+		})
+	}
+	keys, err := token.NewKeySet(keyConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token.NewWithKeySet(keys, options...), keys, nil
+}
+
+//SetToken 服务启动和配置热更新时调用
+func SetToken(tok token.Token, keys *token.KeySet) {
+	tokens.Store(tokenHolder{token: tok, keys: keys})
+}
+
+//Token 当前的token生成器，没有SetToken时按当前配置创建
+func Token() token.Token {
+	if holder, ok := tokens.Load().(tokenHolder); ok {
+		return holder.token
+	}
+	tok, _, err := NewToken(config.Get())
+	if err != nil {
+		//配置已经校验过，只有key文件读取失败时才会出错
+		panic(err)
+	}
+	return tok
+}
+
+//JWKS 当前的公钥，使用secret时为空
+func JWKS() token.JWKS {
+	if holder, ok := tokens.Load().(tokenHolder); ok && holder.keys != nil {
+		return holder.keys.JWKS()
+	}
+	return token.JWKS{Keys: []token.JWK{}}
+}
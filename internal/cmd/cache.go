@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github/xujialingit/shopping-app/internal/api"
+	"github/xujialingit/shopping-app/internal/config"
+	"go.uber.org/zap"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//每次SCAN和DEL的key数量
+const _FlushBatch = 500
+
+//flushCache 通过SCAN删除指定前缀的key，不使用KEYS避免阻塞redis
+func flushCache(args []string) error {
+	fs := newFlagSet("cache flush")
+	prefix := fs.String("prefix", "", "key前缀，不能为空")
+	dryRun := fs.Bool("dry-run", false, "只统计不删除")
+	_ = fs.Parse(args)
+	if *prefix == "" {
+		return fmt.Errorf("%w: 缺少 -prefix", errUsage)
+	}
+
+	app, err := setup()
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	repo, err := api.NewCache(config.Get())
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	count, err := deleteByPrefix(context.Background(), repo.Client(), *prefix, *dryRun)
+	if err != nil {
+		return err
+	}
+	app.logger.Warn("清除缓存", zap.String("prefix", *prefix), zap.Int("count", count), zap.Bool("dry_run", *dryRun))
+	fmt.Printf("前缀 %s 共%d个key\n", *prefix, count)
+	return nil
+}
+
+func deleteByPrefix(ctx context.Context, client *redis.Client, prefix string, dryRun bool) (int, error) {
+	count := 0
+	iter := client.Scan(ctx, 0, prefix+"*", _FlushBatch).Iterator()
+	keys := make([]string, 0, _FlushBatch)
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		count += len(keys)
+		if !dryRun {
+			if err := client.Unlink(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+		keys = keys[:0]
+		return nil
+	}
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) >= _FlushBatch {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return count, err
+	}
+	return count, flush()
+}
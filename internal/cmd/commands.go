@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+//command 子命令，有subcommands时run为空
+type command struct {
+	usage       string
+	run         func(args []string) error
+	subcommands map[string]*command
+}
+
+var commands = map[string]*command{
+	"serve":   {usage: "启动http服务", run: serve},
+	"migrate": {usage: "自动迁移数据库表", run: migrate},
+	"seed":    {usage: "导入json格式的初始化数据 -file fixtures.json", run: seed},
+	"user": {usage: "用户管理", subcommands: map[string]*command{
+		"create-admin": {usage: "创建管理员 -email -password-file，不指定文件时从标准输入读取密码", run: createAdmin},
+	}},
+	"token": {usage: "token调试", subcommands: map[string]*command{
+		"issue": {usage: "为用户签发jwt -user-id -user-name -ttl", run: issueToken},
+	}},
+	"config": {usage: "配置", subcommands: map[string]*command{
+		"print": {usage: "打印最终生效的配置，密码和密钥已隐藏", run: printConfig},
+	}},
+	"cache": {usage: "缓存", subcommands: map[string]*command{
+		"flush": {usage: "删除指定前缀的key -prefix", run: flushCache},
+	}},
+}
+
+//findCommand 按参数逐级查找子命令，返回命令和剩余参数
+func findCommand(commands map[string]*command, args []string) (*command, []string, error) {
+	path := make([]string, 0)
+	for len(args) > 0 {
+		cmd, ok := commands[args[0]]
+		if !ok {
+			break
+		}
+		path = append(path, args[0])
+		args = args[1:]
+		if cmd.subcommands == nil {
+			return cmd, args, nil
+		}
+		commands = cmd.subcommands
+	}
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("缺少子命令: %s", strings.Join(path, " "))
+	}
+	return nil, nil, fmt.Errorf("未知命令: %s", strings.Join(append(path, args[0]), " "))
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "用法: %s [全局参数] <命令> [参数]\n\n命令:\n", os.Args[0])
+	printCommands(commands, "")
+	fmt.Fprintln(out, "\n全局参数:")
+	flag.PrintDefaults()
+}
+
+func printCommands(commands map[string]*command, prefix string) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		if cmd.subcommands != nil {
+			printCommands(cmd.subcommands, prefix+name+" ")
+			continue
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-20s %s\n", prefix+name, cmd.usage)
+	}
+}
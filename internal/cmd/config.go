@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github/xujialingit/shopping-app/internal/config"
+)
+
+//printConfig 打印合并了环境配置、环境变量和密钥文件后的配置，密码和密钥已隐藏
+func printConfig(args []string) error {
+	fs := newFlagSet("config print")
+	_ = fs.Parse(args)
+
+	app, err := setup()
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	c := config.Get()
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(c.ToRedactedJSON()), "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(out.String())
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github/xujialingit/shopping-app/internal/config"
+)
+
+//全局参数，可以写在子命令前或子命令后：app -env prod serve 或 app serve -env prod
+var (
+	configFile = flag.String("config", config.DefaultFile, "基础配置文件路径，同目录下的 cfg.<env>.toml 会覆盖其中的配置")
+	env        = flag.String("env", os.Getenv("APP_ENV"), "运行环境 dev test prod，默认读取 APP_ENV，都没有设置时不能启动")
+	secretDir  = flag.String("secret-dir", "", "密钥文件目录，文件名为配置对应的环境变量名，如 APP_MYSQL_BASE_PASS")
+)
+
+//newFlagSet 子命令的参数，包含全局参数
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+}
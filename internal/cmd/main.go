@@ -1,52 +1,144 @@
 package main
 
 import (
-	"github/xujialingit/shopping-app/internal/api"
+	"errors"
+	"flag"
+	"fmt"
 	"github/xujialingit/shopping-app/internal/config"
 	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"reflect"
+	"time"
 )
 
 func main() {
-	//初始化config
-	config.InitConfig()
+	flag.Usage = usage
+	flag.Parse()
 
-	//日志配置
-	loggerOptions := findLogConfigOption()
-	logger, err := logger.New(loggerOptions...)
+	//默认启动服务
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	cmd, args, err := findCommand(commands, args)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+		os.Exit(2)
 	}
-	defer func() {
-		logger.Sync()
-	}()
+	if err := cmd.run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+//app 子命令共用的配置和日志
+type app struct {
+	logger *zap.Logger
+	levels *logger.Levels
+}
+
+//setup 加载配置并初始化日志，子命令解析完参数后调用
+func setup() (*app, error) {
+	//初始化config
+	config.InitConfig(
+		config.WithFile(*configFile),
+		config.WithEnv(*env),
+		config.WithSecretDir(*secretDir),
+	)
 
-	//服务
-	server, err := api.NewApiServer(logger)
+	//日志配置
+	logger.SetMasking(newMasking(config.Get()))
+	levels := newLogLevels(config.Get())
+	loggerOptions := findLogConfigOption(levels)
+	log, err := logger.New(loggerOptions...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	print(server)
+	//没有请求上下文时 logger.FromContext 使用全局logger
+	zap.ReplaceGlobals(log)
+	//线上只输出ERROR，使用的环境需要保留
+	logger.Unfiltered(log).Info("加载配置", zap.String("env", config.Env()), zap.String("file", *configFile))
+
+	config.SetErrorHandler(func(err error) {
+		log.Error("配置热更新失败", zap.Error(err))
+	})
+	//热更新 [log].level 和 [log.modules]
+	config.Subscribe(func(old, new config.Config) {
+		if old.Log.Level != new.Log.Level || !reflect.DeepEqual(old.Log.Modules, new.Log.Modules) {
+			applyLogLevels(levels, new)
+			logger.Unfiltered(log).Warn("日志级别已更新", zap.Stringer("levels", levels))
+		}
+	})
+	return &app{logger: log, levels: levels}, nil
+}
+
+func (a *app) close() {
+	_ = a.logger.Sync()
 }
 
-func findLogConfigOption() []logger.Option {
+var errUsage = errors.New("参数错误")
+
+func findLogConfigOption(levels *logger.Levels) []logger.Option {
 	c := config.Get()
 	result := make([]logger.Option, 0)
 
+	result = append(result, logger.WithLevels(levels))
+	if !c.Log.Stdout {
+		result = append(result, logger.WithDisableConsole())
+	}
+
+	//文件
+	encoding := logger.EncodingConsole
 	if c.Log.JsonFormat {
-		result = append(result, logger.WithJsonFormat())
+		encoding = logger.EncodingJson
+	}
+	rotation := logger.Rotation{
+		MaxSize:    c.Log.MaxSize,
+		MaxAge:     c.Log.MaxAge,
+		MaxBackups: c.Log.MaxBackups,
+		Compress:   c.Log.Compress,
+	}
+	result = append(result, logger.WithFileRotationP(c.Log.LogPath, logger.SinkEncoding(encoding), logger.SinkRotation(rotation)))
+	if c.Log.ErrorLogPath != "" {
+		result = append(result, logger.WithErrorFileRotationP(c.Log.ErrorLogPath, logger.SinkEncoding(encoding), logger.SinkRotation(rotation)))
 	}
 
-	switch c.Log.Level {
-	case "DEBUG":
-		result = append(result, logger.WithDebugLevel())
-	case "INFO":
-		result = append(result, logger.WithInfoLevel())
-	case "WARN":
-		result = append(result, logger.WithWarnLevel())
-	case "ERROR":
-		result = append(result, logger.WithErrorLevel())
+	if c.Log.Sampling.Initial > 0 {
+		result = append(result, logger.WithSampling(time.Second, c.Log.Sampling.Initial, c.Log.Sampling.Thereafter))
 	}
-	result = append(result, logger.WithDisableConsole())
-	result = append(result, logger.WithFileRotationP(c.Log.LogPath))
 	return result
 }
+
+//newMasking 在默认脱敏规则上追加 [log].maskKeys
+func newMasking(c config.Config) *logger.Masking {
+	masking := logger.NewMasking()
+	for _, key := range c.Log.MaskKeys {
+		masking.RegisterKey(key, logger.Redact)
+	}
+	return masking
+}
+
+//newLogLevels 根据配置创建可在运行时修改的日志级别
+func newLogLevels(c config.Config) *logger.Levels {
+	levels := logger.NewLevels(logger.DefaultLevel)
+	applyLogLevels(levels, c)
+	return levels
+}
+
+//applyLogLevels 配置已经校验过，这里不会解析失败
+func applyLogLevels(levels *logger.Levels, c config.Config) {
+	if level, err := logger.ParseLevel(c.Log.Level); err == nil {
+		levels.SetLevel(level)
+	}
+	modules := make(map[string]zapcore.Level, len(c.Log.Modules))
+	for module, text := range c.Log.Modules {
+		if level, err := logger.ParseLevel(text); err == nil {
+			modules[module] = level
+		}
+	}
+	levels.SetModuleLevels(modules)
+}
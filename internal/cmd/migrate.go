@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github/xujialingit/shopping-app/internal/api"
+	"github/xujialingit/shopping-app/internal/config"
+	"os"
+)
+
+func migrate(args []string) error {
+	fs := newFlagSet("migrate")
+	_ = fs.Parse(args)
+
+	app, err := setup()
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	repo, err := api.NewDB(config.Get())
+	if err != nil {
+		return err
+	}
+	defer repo.DbClose()
+
+	if err := api.Migrate(context.Background(), repo); err != nil {
+		return err
+	}
+	fmt.Printf("迁移完成，共%d张表\n", len(api.Models()))
+	return nil
+}
+
+func seed(args []string) error {
+	fs := newFlagSet("seed")
+	file := fs.String("file", "", "json格式的初始化数据文件")
+	_ = fs.Parse(args)
+	if *file == "" {
+		return fmt.Errorf("%w: 缺少 -file", errUsage)
+	}
+
+	app, err := setup()
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	repo, err := api.NewDB(config.Get())
+	if err != nil {
+		return err
+	}
+	defer repo.DbClose()
+
+	count, err := api.Seed(context.Background(), repo, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("导入完成，共%d行\n", count)
+	return nil
+}
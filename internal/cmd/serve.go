@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github/xujialingit/shopping-app/internal/api"
+	"github/xujialingit/shopping-app/internal/config"
+	"github/xujialingit/shopping-app/pkg/core"
+	"github/xujialingit/shopping-app/pkg/outbox"
+	"github/xujialingit/shopping-app/pkg/pkg/shutdown"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+//outbox事件投递到的redis stream长度上限
+const _OutboxStreamMaxLen = 100000
+
+//serve 启动http服务和outbox投递，收到退出信号后按阶段关闭
+func serve(args []string) error {
+	fs := newFlagSet("serve")
+	_ = fs.Parse(args)
+
+	app, err := setup()
+	if err != nil {
+		return err
+	}
+	defer app.close()
+	logger := app.logger
+	cfg := config.Get()
+
+	server, err := api.NewApiServer(logger)
+	if err != nil {
+		return err
+	}
+	server.LogLevels = app.levels
+
+	options := []core.Option{
+		core.WithLogLevel(app.levels, server.SystemAuth()),
+		core.WithJWKS(api.JWKS),
+	}
+	if server.Captcha != nil {
+		options = append(options, core.WithCaptcha(server.Captcha))
+	}
+	if !cfg.Server.Pprof {
+		options = append(options, core.WithDisablePProf())
+	}
+	engine, err := core.New(cfg.Server.ServerName, logger, options...)
+	if err != nil {
+		return err
+	}
+	server.Route(engine)
+	server.HttpServer = &http.Server{
+		Addr:    cfg.Server.Host,
+		Handler: engine,
+	}
+
+	//outbox投递
+	relay, err := outbox.NewRelay(server.DB,
+		outbox.NewRedisStreamSink(server.Cache.Client(), cfg.Server.ServerName+":outbox:", _OutboxStreamMaxLen),
+		logger.Named("outbox"),
+	)
+	if err != nil {
+		return err
+	}
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		_ = relay.Run(relayCtx)
+	}()
+
+	hook := shutdown.NewHook().
+		WithLogger(logger).
+		WithReload(config.Reload).
+		Register(shutdown.PhaseDrainHTTP, server.HttpServer.Shutdown).
+		Register(shutdown.PhaseStopWorkers, func(ctx context.Context) error {
+			stopRelay()
+			select {
+			case <-relayDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}).
+		Register(shutdown.PhaseCloseStores, func(context.Context) error {
+			return server.DB.DbClose()
+		}).
+		Register(shutdown.PhaseCloseStores, func(context.Context) error {
+			return server.Cache.Close()
+		})
+
+	listenErr := make(chan error, 1)
+	go func() {
+		logger.Info("http服务启动", zap.String("addr", cfg.Server.Host))
+		if err := server.HttpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			listenErr <- err
+		}
+	}()
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- hook.Wait()
+	}()
+
+	select {
+	case err := <-waitErr:
+		return err
+	case err := <-listenErr:
+		logger.Error("http服务启动失败", zap.Error(err))
+		if shutdownErr := hook.Shutdown(); shutdownErr != nil {
+			logger.Error("关闭失败", zap.Error(shutdownErr))
+		}
+		return err
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"github/xujialingit/shopping-app/internal/api"
+	"github/xujialingit/shopping-app/internal/config"
+	"go.uber.org/zap"
+)
+
+//issueToken 签发jwt用于调试
+func issueToken(args []string) error {
+	fs := newFlagSet("token issue")
+	userID := fs.Int64("user-id", 0, "用户id")
+	userName := fs.String("user-name", "", "用户名")
+	ttl := fs.Duration("ttl", 0, "有效期，默认 [jwt].expireDuration")
+	_ = fs.Parse(args)
+	if *userID <= 0 {
+		return fmt.Errorf("%w: 缺少 -user-id", errUsage)
+	}
+
+	app, err := setup()
+	if err != nil {
+		return err
+	}
+	defer app.close()
+
+	cfg := config.Get()
+	expire := *ttl
+	if expire <= 0 {
+		expire = cfg.Jwt.ExpireDuration
+	}
+	tok, _, err := api.NewToken(cfg)
+	if err != nil {
+		return err
+	}
+	tokenString, err := tok.JwtSign(*userID, *userName, expire)
+	if err != nil {
+		return err
+	}
+	app.logger.Warn("通过命令行签发token", zap.Int64("user_id", *userID), zap.Duration("ttl", expire))
+	fmt.Println(tokenString)
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github/xujialingit/shopping-app/internal/api"
+	"github/xujialingit/shopping-app/internal/config"
+	"github/xujialingit/shopping-app/internal/user"
+	"github/xujialingit/shopping-app/pkg/pkg/pii"
+	"io"
+	"os"
+	"strings"
+)
+
+//createAdmin 创建可以访问 /system 管理接口的管理员
+//密码不能通过命令行参数传入，避免出现在 ps 和 shell 历史中
+func createAdmin(args []string) error {
+	fs := newFlagSet("user create-admin")
+	email := fs.String("email", "", "邮箱")
+	passwordFile := fs.String("password-file", "", "密码文件，读取第一行；为空时从标准输入读取")
+	nickname := fs.String("nickname", "admin", "昵称")
+	_ = fs.Parse(args)
+	if *email == "" {
+		return fmt.Errorf("%w: 缺少 -email", errUsage)
+	}
+	password, err := readPassword(*passwordFile)
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		return fmt.Errorf("%w: 密码不能为空", errUsage)
+	}
+
+	app, err := setup()
+	if err != nil {
+		return err
+	}
+	defer app.close()
+	cfg := config.Get()
+
+	//邮箱加密存储
+	keyring, err := api.NewKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	pii.SetDefault(keyring)
+
+	repo, err := api.NewDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer repo.DbClose()
+
+	//只创建用户，不需要token和验证码
+	u, err := user.New(repo, nil, nil).CreateAdmin(context.Background(), *email, password, *nickname)
+	if err != nil {
+		return fmt.Errorf("user create-admin: %w", err)
+	}
+	fmt.Printf("创建成功，管理员id为%d\n", u.ID)
+	return nil
+}
+
+//readPassword 读取文件或标准输入的第一行，标准输入是终端时先输出提示
+func readPassword(file string) (string, error) {
+	var r io.Reader = os.Stdin
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	} else if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "密码: ")
+	}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
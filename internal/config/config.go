@@ -2,23 +2,41 @@ package config
 
 import (
 	"encoding/json"
-	"github.com/fsnotify/fsnotify"
-	"github.com/spf13/viper"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 /*
 config使用单例模式
 暴露出一个Get方法获取全局配置
+配置热更新时先校验，校验通过后整体替换快照，再通知Subscribe的订阅者
 */
 
-var config = new(Config)
+var (
+	current atomic.Value //*Config
+	env     string
+	loaded  *option //InitConfig使用的选项，Reload时复用
+
+	reloadMu     sync.Mutex
+	mu           sync.Mutex
+	subscribers  = make(map[int]func(old, new Config))
+	nextID       int
+	errorHandler = func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+)
 
 type Config struct {
 	Mysql struct {
 		Base struct {
+			Driver          string        `toml:"driver"`
 			ConnMaxLifeTime time.Duration `toml:"connMaxLifeTime"`
-			MaxIdleConne    int           `toml:"maxIdleConn"`
+			MaxIdleConne    int           `toml:"maxIdleConn" mapstructure:"maxIdleConn"`
 			MaxOpenConn     int           `toml:"maxOpenConn"`
 			Addr            string        `toml:"addr"`
 			Name            string        `toml:"name"`
@@ -30,8 +48,17 @@ type Config struct {
 	Jwt struct {
 		Secret          string        `toml:"secret"`
 		ExpireDuration  time.Duration `toml:"expireDuration"`
-		RefreshDuration time.Duration `json:"refreshDuration"`
-	}
+		RefreshDuration time.Duration `toml:"refreshDuration" json:"refreshDuration"`
+		//签发时写入，验证时必须一致；audience可以配置多个，签发时使用第一个
+		Issuer   string        `toml:"issuer"`
+		Audience []string      `toml:"audience"`
+		Leeway   time.Duration `toml:"leeway"` //验证过期时间时允许的时钟偏差
+		//每个用户同时登录的设备数，0为不限制，超出时注销最久未使用的
+		MaxSessions int `toml:"maxSessions"`
+		//配置了keys时使用非对称签名，secret不再使用；activeKey为签名使用的key id
+		ActiveKey string   `toml:"activeKey"`
+		Keys      []JwtKey `toml:"keys"`
+	} `toml:"jwt"`
 
 	Redis struct {
 		Addr         string `toml:"addr"`
@@ -47,22 +74,130 @@ type Config struct {
 		Level      string `toml:"level"`
 		Stdout     bool   `toml:"stdout"`
 		JsonFormat bool   `toml:"jsonFormat"`
+		//error及以上级别单独写入的文件，为空时不单独写入
+		ErrorLogPath string `toml:"errorLogPath"`
+		//日志文件切割
+		MaxSize    int  `toml:"maxSize"`
+		MaxAge     int  `toml:"maxAge"`
+		MaxBackups int  `toml:"maxBackups"`
+		Compress   bool `toml:"compress"`
+		//采样，initial为0时不采样
+		Sampling struct {
+			Initial    int `toml:"initial"`
+			Thereafter int `toml:"thereafter"`
+		} `toml:"sampling"`
+		//单独设置模块的日志级别 db = "DEBUG"
+		Modules map[string]string `toml:"modules"`
+		//额外需要脱敏的字段名，值全部隐藏
+		MaskKeys []string `toml:"maskKeys"`
 	} `toml:"log"`
 
 	Server struct {
 		ServerName string `toml:"serverName"`
 		Host       string `toml:"host"`
 		Pprof      bool   `toml:"pprof"`
+		//可以访问 /system 下管理接口的用户
+		AdminUserIDs []int64 `toml:"adminUserIds"`
 	} `toml:"server"`
 
+	Pii struct {
+		ActiveKey string            `toml:"activeKey"`
+		IndexKey  string            `toml:"indexKey"`
+		Keys      map[string]string `toml:"keys"`
+	} `toml:"pii"`
+
 	Email struct {
-		QQ struct {
+		Driver  string `toml:"driver"`  //smtp 使用QQ邮箱发送；file 写入dir目录，开发测试使用
+		Dir     string `toml:"dir"`     //driver为file时邮件保存的目录
+		AppName string `toml:"appName"` //邮件标题和正文中的名称
+		QQ      struct {
 			SmtpHost string `toml:"smtpHost"`
 			SmtpPort string `toml:"smtpPort"`
 			Sender   string `toml:"sender"`
 			Secret   string `toml:"secret"`
 		} `toml:"qq"`
 	} `toml:"email"`
+
+	//短信，验证码的有效期、冷却时间和错误次数使用 verifyCode 的配置
+	Sms struct {
+		Driver    string            `toml:"driver"`    //file 写入dir目录下的sms.log；log 写入日志；开发测试使用
+		Dir       string            `toml:"dir"`       //driver为file时短信保存的目录
+		SignName  string            `toml:"signName"`  //短信签名
+		Templates map[string]string `toml:"templates"` //验证码用途对应的短信模板编号
+	} `toml:"sms"`
+
+	//邮件、短信验证码
+	VerifyCode struct {
+		TTL         time.Duration `toml:"ttl"`
+		Cooldown    time.Duration `toml:"cooldown"` //同一个接收方两次发送的最小间隔
+		MaxAttempts int           `toml:"maxAttempts"`
+		Length      int           `toml:"length"`
+	} `toml:"verifyCode"`
+
+	//登录失败次数限制
+	Lockout struct {
+		MaxFailures   int           `toml:"maxFailures"`   //同一个账号在window内允许失败的次数，达到后锁定账号
+		MaxIPFailures int           `toml:"maxIPFailures"` //同一个IP在window内允许失败的次数，为0时不按IP统计
+		Window        time.Duration `toml:"window"`
+		LockDuration  time.Duration `toml:"lockDuration"`
+		DelayAfter    int           `toml:"delayAfter"` //失败多少次后开始要求等待，为0时不等待
+		BaseDelay     time.Duration `toml:"baseDelay"`  //每多失败一次等待时间翻倍
+		MaxDelay      time.Duration `toml:"maxDelay"`
+	} `toml:"lockout"`
+
+	//TOTP二次验证
+	Mfa struct {
+		Issuer          string        `toml:"issuer"`          //验证器中显示的名称
+		Skew            int           `toml:"skew"`            //允许客户端时间偏差的周期数
+		PendingDuration time.Duration `toml:"pendingDuration"` //密码校验通过后完成二次验证的时间
+		MaxAttempts     int           `toml:"maxAttempts"`     //每次登录验证码允许输错的次数
+		//为true时 /system 下的管理接口只允许二次验证登录的token访问
+		RequireForAdmins bool `toml:"requireForAdmins"`
+	} `toml:"mfa"`
+
+	//图形验证码
+	Captcha struct {
+		Enabled   bool          `toml:"enabled"` //为false时不注册验证码接口，发送邮件验证码等接口也不要求图形验证码
+		Length    int           `toml:"length"`
+		TTL       time.Duration `toml:"ttl"`
+		TicketTTL time.Duration `toml:"ticketTTL"` //校验通过后换取的ticket的有效期
+		Width     int           `toml:"width"`
+		Height    int           `toml:"height"`
+		Charset   string        `toml:"charset"` //digits 纯数字 alnum 数字和字母
+	} `toml:"captcha"`
+
+	//第三方登录，clientId为空的平台不启用
+	Oauth struct {
+		StateTTL  time.Duration            `toml:"stateTTL"` //发起授权到回调登录的最长时间
+		Providers map[string]OAuthProvider `toml:"providers"`
+	} `toml:"oauth"`
+}
+
+//OAuthProvider 标准OAuth2平台，用户信息字段为空时使用OIDC的 sub email email_verified name picture
+type OAuthProvider struct {
+	ClientID           string   `toml:"clientId"`
+	ClientSecret       string   `toml:"clientSecret"`
+	AuthURL            string   `toml:"authUrl"`
+	TokenURL           string   `toml:"tokenUrl"`
+	UserInfoURL        string   `toml:"userInfoUrl"`
+	RedirectURL        string   `toml:"redirectUrl"` //授权后跳转的前端页面
+	Scopes             []string `toml:"scopes"`
+	PKCE               bool     `toml:"pkce"`
+	SubjectField       string   `toml:"subjectField"`
+	EmailField         string   `toml:"emailField"`
+	EmailVerifiedField string   `toml:"emailVerifiedField"`
+	NameField          string   `toml:"nameField"`
+	AvatarField        string   `toml:"avatarField"`
+	TrustEmail         bool     `toml:"trustEmail"` //平台返回的邮箱都已验证
+}
+
+//JwtKey 非对称签名的key，algorithm 为 RS256 ES256 EdDSA 等
+//只用于验证的旧key可以不配置privateKeyFile，publicKeyFile为空时从私钥导出
+type JwtKey struct {
+	ID             string `toml:"id"`
+	Algorithm      string `toml:"algorithm"`
+	PrivateKeyFile string `toml:"privateKeyFile"`
+	PublicKeyFile  string `toml:"publicKeyFile"`
 }
 
 //序列化
@@ -71,27 +206,161 @@ func (c *Config) ToJSON() string {
 	return string(b)
 }
 
-func InitConfig() {
-	viper.SetConfigName("cfg")
-	viper.SetConfigType("toml")
-	viper.AddConfigPath("./internal/config")
+const _Redacted = "******"
+
+//ToRedactedJSON 隐藏密码和密钥后序列化，用于打印配置
+func (c *Config) ToRedactedJSON() string {
+	redacted := *c
+	redact := func(s *string) {
+		if *s != "" {
+			*s = _Redacted
+		}
+	}
+	redact(&redacted.Jwt.Secret)
+	redact(&redacted.Mysql.Base.Pass)
+	redact(&redacted.Redis.Pass)
+	redact(&redacted.Email.QQ.Secret)
+	redact(&redacted.Pii.IndexKey)
+	keys := make(map[string]string, len(c.Pii.Keys))
+	for version := range c.Pii.Keys {
+		keys[version] = _Redacted
+	}
+	redacted.Pii.Keys = keys
+	providers := make(map[string]OAuthProvider, len(c.Oauth.Providers))
+	for name, p := range c.Oauth.Providers {
+		redact(&p.ClientSecret)
+		providers[name] = p
+	}
+	redacted.Oauth.Providers = providers
+	return redacted.ToJSON()
+}
 
-	if err := viper.ReadInConfig(); err != nil {
+//InitConfig 加载配置，失败时panic
+//  config.InitConfig(config.WithFile("./cfg.toml"), config.WithEnv("prod"))
+func InitConfig(options ...Option) {
+	opt := newOption(options...)
+	if err := opt.checkEnv(); err != nil {
 		panic(err)
 	}
+	env = opt.env
 
-	if err := viper.Unmarshal(config); err != nil {
+	c, err := read(opt)
+	if err != nil {
 		panic(err)
 	}
+	current.Store(c)
+	loaded = opt
 
-	viper.WatchConfig()
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		if err := viper.Unmarshal(config); err != nil {
-			panic(err)
-		}
-	})
+	if err := watch(opt, reload); err != nil {
+		panic(err)
+	}
 }
 
+//read 加载、填充默认值并校验
+func read(opt *option) (*Config, error) {
+	v, err := load(opt)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	if err := v.Unmarshal(c); err != nil {
+		return nil, fmt.Errorf("解析配置失败:%w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//reload 配置文件变化时调用，失败时保留旧配置
+func reload(opt *option) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	c, err := read(opt)
+	if err != nil {
+		handleError(fmt.Errorf("配置热更新失败，继续使用旧配置:%w", err))
+		return
+	}
+	old := current.Swap(c).(*Config)
+	notify(*old, *c)
+}
+
+//Reload 手动重新加载配置，如收到SIGHUP时，失败时继续使用旧配置并交给 SetErrorHandler 设置的函数处理
+func Reload() {
+	if loaded == nil {
+		handleError(errors.New("配置未初始化，不能重新加载"))
+		return
+	}
+	reload(loaded)
+}
+
+//Subscribe 订阅配置热更新，回调在配置替换后按订阅顺序同步执行，返回取消订阅的函数
+//回调中panic不会导致进程退出，会交给 SetErrorHandler 设置的函数处理
+func Subscribe(fn func(old, new Config)) (cancel func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	id := nextID
+	nextID++
+	subscribers[id] = fn
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+//SetErrorHandler 设置热更新失败时的处理函数，默认输出到stderr
+func SetErrorHandler(fn func(err error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	errorHandler = fn
+}
+
+func notify(old, new Config) {
+	mu.Lock()
+	ids := make([]int, 0, len(subscribers))
+	for id := range subscribers {
+		ids = append(ids, id)
+	}
+	fns := make([]func(old, new Config), 0, len(ids))
+	sort.Ints(ids)
+	for _, id := range ids {
+		fns = append(fns, subscribers[id])
+	}
+	mu.Unlock()
+
+	for _, fn := range fns {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					handleError(fmt.Errorf("配置订阅者执行失败:%v", err))
+				}
+			}()
+			fn(old, new)
+		}()
+	}
+}
+
+func handleError(err error) {
+	mu.Lock()
+	handler := errorHandler
+	mu.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+//Get 获取当前配置的副本
 func Get() Config {
-	return *config
+	c, ok := current.Load().(*Config)
+	if !ok {
+		return Config{}
+	}
+	return *c
+}
+
+//Env 当前运行环境 dev test prod
+func Env() string {
+	return env
 }
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	return file
+}
+
+//测试用的pii密钥，都是32字节
+const (
+	key1     = "MTExMTExMTExMTExMTExMTExMTExMTExMTExMTExMTE="
+	key2     = "MjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjIyMjI="
+	indexKey = "aWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWk="
+)
+
+func TestInitConfig_Layered(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "cfg.toml", `
+[jwt]
+secret = "secret"
+
+[mysql.base]
+driver = "mysql"
+addr = "127.0.0.1:3306"
+name = "shop"
+user = "root"
+pass = ""
+
+[redis]
+addr = "127.0.0.1:6379"
+
+[server]
+serverName = "api"
+
+[oauth.providers.my_sso]
+clientId = "client"
+clientSecret = ""
+authUrl = "https://sso.example.com/authorize"
+tokenUrl = "https://sso.example.com/token"
+userInfoUrl = "https://sso.example.com/userinfo"
+redirectUrl = "https://shop.example.com/oauth/callback"
+`)
+	writeFile(t, dir, "cfg.prod.toml", `
+[mysql.base]
+addr = "10.0.0.1:3306"
+`)
+	secrets := t.TempDir()
+	writeFile(t, secrets, "APP_REDIS_PASS", "redis-secret\n")
+	writeFile(t, secrets, "APP_PII_KEYS_2", key2)
+	passFile := writeFile(t, dir, "db_pass", "db-secret\n")
+
+	t.Setenv("APP_MYSQL_BASE_USER", "shop")
+	t.Setenv("APP_MYSQL_BASE_PASS_FILE", passFile)
+	t.Setenv("APP_SERVER_HOST", ":9000")
+	t.Setenv("APP_PII_KEYS_1", key1)
+	t.Setenv("APP_PII_INDEXKEY", indexKey)
+	t.Setenv("APP_OAUTH_PROVIDERS_MY_SSO_CLIENTSECRET", "sso-secret")
+
+	InitConfig(WithFile(base), WithEnv(EnvProd), WithSecretDir(secrets))
+	c := Get()
+
+	assert.Equal(t, EnvProd, Env())
+	//环境配置覆盖基础配置
+	assert.Equal(t, "10.0.0.1:3306", c.Mysql.Base.Addr)
+	assert.Equal(t, "mysql", c.Mysql.Base.Driver)
+	//环境变量覆盖，包括配置文件中没有的key
+	assert.Equal(t, "shop", c.Mysql.Base.User)
+	assert.Equal(t, ":9000", c.Server.Host)
+	//密钥文件
+	assert.Equal(t, "db-secret", c.Mysql.Base.Pass)
+	assert.Equal(t, "redis-secret", c.Redis.Pass)
+	assert.Equal(t, map[string]string{"1": key1, "2": key2}, c.Pii.Keys)
+	assert.Equal(t, "client", c.Oauth.Providers["my_sso"].ClientID)
+	assert.Equal(t, "sso-secret", c.Oauth.Providers["my_sso"].ClientSecret)
+	//默认值
+	assert.Equal(t, 24*time.Hour, c.Jwt.ExpireDuration)
+	assert.Equal(t, "INFO", c.Log.Level)
+
+	//打印时隐藏密钥
+	redacted := c.ToRedactedJSON()
+	for _, secret := range []string{"db-secret", "redis-secret", key1, key2, indexKey, "sso-secret", `"secret"`} {
+		assert.NotContains(t, redacted, secret)
+	}
+	assert.Contains(t, redacted, "10.0.0.1:3306")
+	assert.Equal(t, "db-secret", Get().Mysql.Base.Pass)
+	assert.Equal(t, "sso-secret", Get().Oauth.Providers["my_sso"].ClientSecret)
+}
+
+func TestInitConfig_Env(t *testing.T) {
+	base := writeFile(t, t.TempDir(), "cfg.toml", "")
+
+	//没有指定环境时不能默认使用dev
+	t.Setenv("APP_ENV", "")
+	assert.PanicsWithError(t, "没有指定运行环境，使用 -env 或 APP_ENV 设置为 dev test prod", func() {
+		InitConfig(WithFile(base))
+	})
+	assert.PanicsWithError(t, `运行环境只能为 dev test prod，当前为 "production"`, func() {
+		InitConfig(WithFile(base), WithEnv("production"))
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	c := Config{}
+	c.Mysql.Base.Driver = "oracle"
+	c.Log.Level = "TRACE"
+
+	err := c.Validate()
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Contains(t, err.Error(), "jwt.secret 不能为空")
+	assert.Contains(t, err.Error(), `mysql.base.driver 只能为 mysql 或 sqlite，当前为 "oracle"`)
+	assert.Contains(t, err.Error(), `log.level 只能为 DEBUG INFO WARN ERROR，当前为 "TRACE"`)
+	assert.Contains(t, err.Error(), "pii.keys 不能为空")
+
+	//密钥长度和 pii.NewKeyring 的要求一致
+	c.Pii.ActiveKey = "1"
+	c.Pii.Keys = map[string]string{"1": "c2hvcnQ="}
+	c.Pii.IndexKey = "c2hvcnQ="
+	err = c.Validate()
+	assert.Contains(t, err.Error(), "pii.keys.1 必须是32字节密钥的base64")
+	assert.Contains(t, err.Error(), "pii.indexKey 必须是至少32字节密钥的base64")
+
+	c.Pii.Keys = map[string]string{"1": key1}
+	c.Pii.IndexKey = indexKey
+	assert.NotContains(t, c.Validate().Error(), "pii.")
+}
+
+func TestSubscribe_Reload(t *testing.T) {
+	dir := t.TempDir()
+	content := `
+[jwt]
+secret = "secret"
+expireDuration = "%s"
+
+[mysql.base]
+driver = "sqlite"
+
+[redis]
+addr = "127.0.0.1:6379"
+
+[log]
+level = "%s"
+
+[pii]
+indexKey = "` + indexKey + `"
+[pii.keys]
+1 = "` + key1 + `"
+`
+	base := writeFile(t, dir, "cfg.toml", fmt.Sprintf(content, "1h", "ERROR"))
+	InitConfig(WithFile(base), WithEnv(EnvTest))
+
+	changes := make(chan [2]string, 1)
+	errs := make(chan error, 1)
+	SetErrorHandler(func(err error) { errs <- err })
+	defer SetErrorHandler(nil)
+	cancel := Subscribe(func(old, new Config) {
+		changes <- [2]string{old.Log.Level, new.Log.Level}
+	})
+	defer cancel()
+	//订阅者panic不影响其他订阅者
+	defer Subscribe(func(old, new Config) { panic("bad subscriber") })()
+
+	writeFile(t, dir, "cfg.toml", fmt.Sprintf(content, "1h", "DEBUG"))
+	select {
+	case change := <-changes:
+		assert.Equal(t, [2]string{"ERROR", "DEBUG"}, change)
+	case <-time.After(3 * time.Second):
+		t.Fatal("没有收到配置变更")
+	}
+	assert.Equal(t, "DEBUG", Get().Log.Level)
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "bad subscriber")
+	case <-time.After(time.Second):
+		t.Fatal("没有收到订阅者的错误")
+	}
+
+	//校验失败时保留旧配置
+	writeFile(t, dir, "cfg.toml", fmt.Sprintf(content, "1h", "VERBOSE"))
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "log.level")
+	case <-time.After(3 * time.Second):
+		t.Fatal("没有收到校验错误")
+	}
+	assert.Equal(t, "DEBUG", Get().Log.Level)
+
+	//环境变量不会触发文件监听，通过Reload生效
+	writeFile(t, dir, "cfg.toml", fmt.Sprintf(content, "1h", "DEBUG"))
+	t.Setenv("APP_LOG_LEVEL", "WARN")
+	Reload()
+	assert.Equal(t, "WARN", Get().Log.Level)
+}
+
+func TestWatch_ConfigMap(t *testing.T) {
+	//模拟k8s configmap的挂载方式：cfg.toml -> ..data/cfg.toml，..data -> ..<时间戳>
+	dir := t.TempDir()
+	version := func(name, level string) {
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+		writeFile(t, filepath.Join(dir, name), "cfg.toml", "[log]\nlevel = \""+level+"\"\n")
+	}
+	version("..v1", "ERROR")
+	assert.NoError(t, os.Symlink("..v1", filepath.Join(dir, _ConfigMapData)))
+	assert.NoError(t, os.Symlink(filepath.Join(_ConfigMapData, "cfg.toml"), filepath.Join(dir, "cfg.toml")))
+
+	reloads := make(chan struct{}, 1)
+	opt := &option{file: filepath.Join(dir, "cfg.toml"), env: EnvTest}
+	assert.NoError(t, watch(opt, func(opt *option) { reloads <- struct{}{} }))
+
+	//kubelet先创建新版本和临时软链，再原子替换 ..data
+	version("..v2", "DEBUG")
+	assert.NoError(t, os.Symlink("..v2", filepath.Join(dir, "..data_tmp")))
+	assert.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, _ConfigMapData)))
+	select {
+	case <-reloads:
+	case <-time.After(3 * time.Second):
+		t.Fatal("替换 ..data 后没有重新加载")
+	}
+	raw, err := os.ReadFile(opt.file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(raw), "DEBUG")
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "APP_MYSQL_BASE_PASS", EnvName("mysql.base.pass"))
+	assert.Equal(t, "APP_EMAIL_QQ_SMTPHOST", EnvName("email.qq.smtpHost"))
+}
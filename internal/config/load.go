@@ -0,0 +1,261 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+/*
+配置加载顺序，后面的覆盖前面的：
+1. 基础配置文件 cfg.toml
+2. 环境配置文件 cfg.<env>.toml，与基础配置在同一目录，不存在时跳过
+3. 环境变量 APP_<KEY>，如 APP_MYSQL_BASE_PASS 覆盖 [mysql.base].pass
+4. 密钥文件：APP_<KEY>_FILE 指定的文件，或 secretDir 下名为 APP_<KEY> 的文件(k8s/docker secret挂载)
+*/
+
+const (
+	EnvPrefix = "APP"
+
+	EnvDev  = "dev"
+	EnvTest = "test"
+	EnvProd = "prod"
+
+	DefaultFile = "./internal/config/cfg.toml"
+)
+
+type Option func(*option)
+
+type option struct {
+	file      string
+	env       string
+	secretDir string
+}
+
+//WithFile 指定基础配置文件
+func WithFile(file string) Option {
+	return func(opt *option) {
+		opt.file = file
+	}
+}
+
+//WithEnv 指定环境 dev test prod，为空时读取 APP_ENV，都没有设置时 InitConfig 失败
+func WithEnv(env string) Option {
+	return func(opt *option) {
+		opt.env = env
+	}
+}
+
+//WithSecretDir 从目录中读取密钥文件，文件名为环境变量名，如 /run/secrets/APP_MYSQL_BASE_PASS
+func WithSecretDir(dir string) Option {
+	return func(opt *option) {
+		opt.secretDir = dir
+	}
+}
+
+func newOption(options ...Option) *option {
+	opt := &option{
+		file: DefaultFile,
+		env:  os.Getenv(EnvPrefix + "_ENV"),
+	}
+	for _, f := range options {
+		f(opt)
+	}
+	return opt
+}
+
+//checkEnv 不指定环境时不能默认使用dev，否则生产环境漏设 APP_ENV 会使用开发环境的数据库和密钥
+func (o *option) checkEnv() error {
+	switch o.env {
+	case EnvDev, EnvTest, EnvProd:
+		return nil
+	case "":
+		return errors.New("没有指定运行环境，使用 -env 或 APP_ENV 设置为 dev test prod")
+	default:
+		return fmt.Errorf("运行环境只能为 dev test prod，当前为 %q", o.env)
+	}
+}
+
+//profileFile cfg.toml -> cfg.prod.toml
+func (o *option) profileFile() string {
+	ext := filepath.Ext(o.file)
+	return strings.TrimSuffix(o.file, ext) + "." + o.env + ext
+}
+
+//load 按顺序读取配置
+func load(opt *option) (*viper.Viper, error) {
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigType("toml")
+	v.SetConfigFile(opt.file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("读取配置文件%s失败:%w", opt.file, err)
+	}
+
+	profile := opt.profileFile()
+	if _, err := os.Stat(profile); err == nil {
+		f, err := os.Open(profile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := v.MergeConfig(f); err != nil {
+			return nil, fmt.Errorf("读取配置文件%s失败:%w", profile, err)
+		}
+	}
+
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		if key.isMap {
+			if err := overrideMap(v, opt, key.name, key.elem); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		//绑定全部key，配置文件中没有的key也可以通过环境变量设置
+		if err := v.BindEnv(key.name); err != nil {
+			return nil, err
+		}
+		secret, ok, err := readSecret(opt, key.name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			v.Set(key.name, secret)
+		}
+	}
+	return v, nil
+}
+
+//EnvName 配置key对应的环境变量名 mysql.base.pass -> APP_MYSQL_BASE_PASS
+func EnvName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func readSecret(opt *option, key string) (string, bool, error) {
+	name := EnvName(key)
+	file := os.Getenv(name + "_FILE")
+	if file == "" && opt.secretDir != "" {
+		candidate := filepath.Join(opt.secretDir, name)
+		if _, err := os.Stat(candidate); err == nil {
+			file = candidate
+		}
+	}
+	if file == "" {
+		return "", false, nil
+	}
+
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("读取密钥文件%s失败:%w", file, err)
+	}
+	return strings.TrimRight(string(raw), "\r\n"), true, nil
+}
+
+//overrideMap map类型的配置按元素覆盖：APP_PII_KEYS_2 覆盖 [pii.keys].2，同样支持 _FILE 和 secretDir
+//元素为结构体时按字段覆盖：APP_OAUTH_PROVIDERS_GITHUB_CLIENTSECRET 覆盖 [oauth.providers.github].clientSecret
+func overrideMap(v *viper.Viper, opt *option, key string, elem reflect.Type) error {
+	prefix := EnvName(key) + "_"
+	values := make(map[string]string)
+
+	if opt.secretDir != "" {
+		entries, err := os.ReadDir(opt.secretDir)
+		if err != nil {
+			return fmt.Errorf("读取密钥目录%s失败:%w", opt.secretDir, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+				continue
+			}
+			raw, err := os.ReadFile(filepath.Join(opt.secretDir, entry.Name()))
+			if err != nil {
+				return err
+			}
+			values[strings.TrimPrefix(entry.Name(), prefix)] = strings.TrimRight(string(raw), "\r\n")
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		item := strings.TrimPrefix(name, prefix)
+		if strings.HasSuffix(item, "_FILE") {
+			raw, err := os.ReadFile(value)
+			if err != nil {
+				return fmt.Errorf("读取密钥文件%s失败:%w", value, err)
+			}
+			item, value = strings.TrimSuffix(item, "_FILE"), strings.TrimRight(string(raw), "\r\n")
+		}
+		values[item] = value
+	}
+
+	for item, value := range values {
+		name := strings.ToLower(item)
+		if elem.Kind() == reflect.Struct {
+			var ok bool
+			if name, ok = structItem(elem, name); !ok {
+				continue
+			}
+		}
+		v.Set(key+"."+name, value)
+	}
+	return nil
+}
+
+//structItem github_clientsecret -> github.clientsecret，元素名称中可以有下划线
+func structItem(elem reflect.Type, item string) (string, bool) {
+	for _, field := range configKeys(elem, "") {
+		element := strings.TrimSuffix(item, "_"+strings.ReplaceAll(field.name, ".", "_"))
+		if element != item && element != "" {
+			return element + "." + field.name, true
+		}
+	}
+	return "", false
+}
+
+type configKey struct {
+	name  string
+	isMap bool
+	elem  reflect.Type //map的元素类型
+}
+
+//configKeys 遍历Config得到全部叶子节点的key
+func configKeys(t reflect.Type, prefix string) []configKey {
+	keys := make([]configKey, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := keyName(field)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			keys = append(keys, configKeys(field.Type, name)...)
+		case reflect.Map:
+			keys = append(keys, configKey{name: name, isMap: true, elem: field.Type.Elem()})
+		default:
+			keys = append(keys, configKey{name: name})
+		}
+	}
+	return keys
+}
+
+func keyName(field reflect.StructField) string {
+	for _, tag := range []string{"mapstructure", "toml"} {
+		if name := strings.Split(field.Tag.Get(tag), ",")[0]; name != "" {
+			return strings.ToLower(name)
+		}
+	}
+	return strings.ToLower(field.Name)
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+//defaults 配置文件中缺少的key使用的默认值
+var defaults = map[string]interface{}{
+	"jwt.expireDuration":         24 * time.Hour,
+	"jwt.refreshDuration":        720 * time.Hour,
+	"jwt.leeway":                 30 * time.Second,
+	"mysql.base.driver":          "mysql",
+	"mysql.base.connMaxLifeTime": time.Minute,
+	"mysql.base.maxIdleConn":     10,
+	"mysql.base.maxOpenConn":     10,
+	"redis.db":                   0,
+	"redis.maxRetries":           3,
+	"redis.minIdleConns":         5,
+	"redis.poolSize":             10,
+	"log.logPath":                "./log/gee-code.log",
+	"log.level":                  "INFO",
+	"log.stdout":                 true,
+	"log.jsonFormat":             true,
+	"log.maxSize":                128,
+	"log.maxAge":                 30,
+	"log.maxBackups":             300,
+	"log.compress":               true,
+	"server.serverName":          "api",
+	"server.host":                ":8088",
+	"pii.activeKey":              "1",
+	"email.qq.smtpHost":          "smtp.qq.com",
+	"email.qq.smtpPort":          "587",
+	"email.driver":               "smtp",
+	"email.dir":                  "./log/mail",
+	"email.appName":              "shopping",
+	"sms.driver":                 "file",
+	"sms.dir":                    "./log/sms",
+	"sms.signName":               "shopping",
+	"verifyCode.ttl":             10 * time.Minute,
+	"verifyCode.cooldown":        time.Minute,
+	"verifyCode.maxAttempts":     5,
+	"verifyCode.length":          6,
+	"lockout.maxFailures":        5,
+	"lockout.maxIPFailures":      20,
+	"lockout.window":             15 * time.Minute,
+	"lockout.lockDuration":       30 * time.Minute,
+	"lockout.delayAfter":         3,
+	"lockout.baseDelay":          time.Second,
+	"lockout.maxDelay":           30 * time.Second,
+	"mfa.issuer":                 "shopping",
+	"mfa.skew":                   1,
+	"mfa.pendingDuration":        5 * time.Minute,
+	"mfa.maxAttempts":            5,
+	"captcha.length":             4,
+	"captcha.ttl":                2 * time.Minute,
+	"captcha.ticketTTL":          5 * time.Minute,
+	"captcha.width":              120,
+	"captcha.height":             40,
+	"captcha.charset":            "alnum",
+	"oauth.stateTTL":             10 * time.Minute,
+}
+
+func setDefaults(v *viper.Viper) {
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+}
+
+var logLevels = map[string]bool{
+	"DEBUG": true,
+	"INFO":  true,
+	"WARN":  true,
+	"ERROR": true,
+}
+
+var jwtAlgorithms = map[string]bool{
+	"RS256": true,
+	"RS384": true,
+	"RS512": true,
+	"ES256": true,
+	"ES384": true,
+	"ES512": true,
+	"EdDSA": true,
+}
+
+//ValidationError 配置校验失败，包含全部不合法的key
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "配置校验失败: " + strings.Join(e.Errors, "; ")
+}
+
+//Validate 校验配置，返回*ValidationError
+func (c *Config) Validate() error {
+	errs := make([]string, 0)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	if len(c.Jwt.Keys) == 0 {
+		check(c.Jwt.Secret != "", "jwt.secret 不能为空")
+	} else {
+		active := false
+		for i, key := range c.Jwt.Keys {
+			check(key.ID != "", "jwt.keys[%d].id 不能为空", i)
+			check(jwtAlgorithms[key.Algorithm], "jwt.keys[%d].algorithm 只能为 RS256 RS384 RS512 ES256 ES384 ES512 EdDSA，当前为 %q", i, key.Algorithm)
+			check(key.PrivateKeyFile != "" || key.PublicKeyFile != "", "jwt.keys[%d] 至少需要 privateKeyFile 或 publicKeyFile", i)
+			if key.ID == c.Jwt.ActiveKey {
+				active = true
+				check(key.PrivateKeyFile != "", "jwt.activeKey %q 必须配置 privateKeyFile", key.ID)
+			}
+		}
+		check(active, "jwt.activeKey %q 在 jwt.keys 中不存在", c.Jwt.ActiveKey)
+	}
+	check(c.Jwt.ExpireDuration > 0, "jwt.expireDuration 必须大于0，如 \"24h\"")
+	check(c.Jwt.RefreshDuration > c.Jwt.ExpireDuration, "jwt.refreshDuration 必须大于 jwt.expireDuration")
+	check(c.Jwt.MaxSessions >= 0, "jwt.maxSessions 不能小于0")
+	check(c.Jwt.Leeway >= 0 && c.Jwt.Leeway < c.Jwt.ExpireDuration, "jwt.leeway 不能小于0且必须小于 jwt.expireDuration")
+
+	base := c.Mysql.Base
+	switch base.Driver {
+	case "mysql":
+		check(base.Addr != "", "mysql.base.addr 不能为空")
+		check(base.User != "", "mysql.base.user 不能为空")
+		check(base.Name != "", "mysql.base.name 不能为空")
+	case "sqlite":
+	default:
+		check(false, "mysql.base.driver 只能为 mysql 或 sqlite，当前为 %q", base.Driver)
+	}
+	check(base.MaxOpenConn >= 0, "mysql.base.maxOpenConn 不能小于0")
+	check(base.MaxIdleConne >= 0, "mysql.base.maxIdleConn 不能小于0")
+	check(base.ConnMaxLifeTime >= 0, "mysql.base.connMaxLifeTime 不能小于0")
+
+	check(c.Redis.Addr != "", "redis.addr 不能为空")
+	check(c.Redis.Db >= 0, "redis.db 不能小于0")
+	check(c.Redis.PoolSize > 0, "redis.poolSize 必须大于0")
+
+	check(logLevels[c.Log.Level], "log.level 只能为 DEBUG INFO WARN ERROR，当前为 %q", c.Log.Level)
+	check(c.Log.LogPath != "", "log.logPath 不能为空")
+	check(c.Log.MaxSize > 0, "log.maxSize 必须大于0")
+	check(c.Log.MaxAge >= 0, "log.maxAge 不能小于0")
+	check(c.Log.MaxBackups >= 0, "log.maxBackups 不能小于0")
+	check(c.Log.Sampling.Initial >= 0 && c.Log.Sampling.Thereafter >= 0, "log.sampling 不能小于0")
+	for module, level := range c.Log.Modules {
+		check(logLevels[strings.ToUpper(level)], "log.modules.%s 只能为 DEBUG INFO WARN ERROR，当前为 %q", module, level)
+	}
+
+	check(c.Server.ServerName != "" && !strings.Contains(c.Server.ServerName, "/"), "server.serverName 不能为空且不能包含 /")
+	check(c.Server.Host != "", "server.host 不能为空")
+
+	switch c.Email.Driver {
+	case "smtp":
+		check(c.Email.QQ.SmtpHost != "" && c.Email.QQ.SmtpPort != "", "email.qq.smtpHost email.qq.smtpPort 不能为空")
+	case "file":
+		check(c.Email.Dir != "", "email.dir 不能为空")
+	default:
+		check(false, "email.driver 只能为 smtp 或 file，当前为 %q", c.Email.Driver)
+	}
+	switch c.Sms.Driver {
+	case "file":
+		check(c.Sms.Dir != "", "sms.dir 不能为空")
+	case "log":
+	default:
+		check(false, "sms.driver 只能为 file 或 log，当前为 %q", c.Sms.Driver)
+	}
+	check(c.VerifyCode.TTL > 0, "verifyCode.ttl 必须大于0")
+	check(c.VerifyCode.Cooldown >= 0, "verifyCode.cooldown 不能小于0")
+	check(c.VerifyCode.MaxAttempts > 0, "verifyCode.maxAttempts 必须大于0")
+	check(c.VerifyCode.Length >= 4 && c.VerifyCode.Length <= 10, "verifyCode.length 必须在4到10之间")
+	check(c.Lockout.MaxFailures > 0, "lockout.maxFailures 必须大于0")
+	check(c.Lockout.MaxIPFailures >= 0, "lockout.maxIPFailures 不能小于0")
+	check(c.Lockout.Window > 0, "lockout.window 必须大于0")
+	check(c.Lockout.LockDuration > 0, "lockout.lockDuration 必须大于0")
+	check(c.Lockout.DelayAfter >= 0, "lockout.delayAfter 不能小于0")
+	check(c.Lockout.BaseDelay >= 0 && c.Lockout.MaxDelay >= c.Lockout.BaseDelay, "lockout.maxDelay 不能小于 lockout.baseDelay")
+	check(c.Mfa.Issuer != "", "mfa.issuer 不能为空")
+	check(c.Mfa.Skew >= 0 && c.Mfa.Skew <= 10, "mfa.skew 必须在0到10之间")
+	check(c.Mfa.PendingDuration > 0, "mfa.pendingDuration 必须大于0")
+	check(c.Mfa.MaxAttempts > 0, "mfa.maxAttempts 必须大于0")
+	check(c.Captcha.Length >= 4 && c.Captcha.Length <= 8, "captcha.length 必须在4到8之间")
+	check(c.Captcha.TTL > 0, "captcha.ttl 必须大于0")
+	check(c.Captcha.TicketTTL > 0, "captcha.ticketTTL 必须大于0")
+	check(c.Captcha.Width >= 60 && c.Captcha.Height >= 20, "captcha.width 不能小于60，captcha.height 不能小于20")
+	check(c.Captcha.Charset == "digits" || c.Captcha.Charset == "alnum", "captcha.charset 只支持 digits alnum")
+	check(c.Oauth.StateTTL > 0, "oauth.stateTTL 必须大于0")
+	for name, p := range c.Oauth.Providers {
+		if p.ClientID == "" {
+			continue
+		}
+		check(p.ClientSecret != "", "oauth.providers.%s.clientSecret 不能为空", name)
+		check(p.AuthURL != "" && p.TokenURL != "" && p.UserInfoURL != "", "oauth.providers.%s 的 authUrl tokenUrl userInfoUrl 不能为空", name)
+		check(p.RedirectURL != "", "oauth.providers.%s.redirectUrl 不能为空", name)
+	}
+
+	//和 pii.NewKeyring 的要求一致，启动时不会因为密钥错误退出
+	check(len(c.Pii.Keys) > 0, "pii.keys 不能为空，生产环境通过 APP_PII_KEYS_<版本号> 设置")
+	if len(c.Pii.Keys) > 0 {
+		_, ok := c.Pii.Keys[c.Pii.ActiveKey]
+		check(ok, "pii.activeKey %q 在 pii.keys 中不存在", c.Pii.ActiveKey)
+	}
+	versions := make([]string, 0, len(c.Pii.Keys))
+	for version := range c.Pii.Keys {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		check(!strings.Contains(version, ":"), "pii.keys 的版本号 %q 不能包含冒号", version)
+		check(decodedLen(c.Pii.Keys[version]) == 32, "pii.keys.%s 必须是32字节密钥的base64", version)
+	}
+	check(decodedLen(c.Pii.IndexKey) >= 32, "pii.indexKey 必须是至少32字节密钥的base64")
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+//decodedLen base64解码后的长度，不是有效的base64时返回-1
+func decodedLen(encoded string) int {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return -1
+	}
+	return len(raw)
+}
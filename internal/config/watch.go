@@ -0,0 +1,86 @@
+package config
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+//监听基础配置和环境配置文件，编辑器保存时通常会连续触发多个事件，合并后只重新加载一次
+const _ReloadDelay = 100 * time.Millisecond
+
+//k8s挂载configmap时配置文件是指向 ..data/<文件名> 的软链，更新时只替换 ..data 软链，配置文件本身没有事件
+const _ConfigMapData = "..data"
+
+var (
+	watchMu sync.Mutex
+	watcher *fsnotify.Watcher
+)
+
+func watch(opt *option, onChange func(opt *option)) error {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	//重复初始化时关闭旧的监听
+	if watcher != nil {
+		_ = watcher.Close()
+		watcher = nil
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	files := map[string]bool{
+		filepath.Clean(opt.file):          true,
+		filepath.Clean(opt.profileFile()): true,
+	}
+	dirs := make(map[string]bool)
+	for file := range files {
+		dirs[filepath.Dir(file)] = true
+	}
+	for dir := range dirs {
+		//监听目录而不是文件，兼容先删除再创建的保存方式和k8s configmap的软链替换
+		if err := w.Add(dir); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	watcher = w
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Clean(event.Name)
+				changed := files[name] || (filepath.Base(name) == _ConfigMapData && dirs[filepath.Dir(name)])
+				if !changed || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(_ReloadDelay, func() {
+					watchMu.Lock()
+					closed := watcher != w
+					watchMu.Unlock()
+					if !closed {
+						onChange(opt)
+					}
+				})
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				handleError(err)
+			}
+		}
+	}()
+	return nil
+}
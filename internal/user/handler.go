@@ -0,0 +1,544 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"github/xujialingit/shopping-app/pkg/core"
+	"github/xujialingit/shopping-app/pkg/pkg/captcha"
+	"github/xujialingit/shopping-app/pkg/pkg/lockout"
+	"github/xujialingit/shopping-app/pkg/pkg/login"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/oauth"
+	"github/xujialingit/shopping-app/pkg/pkg/response"
+	"github/xujialingit/shopping-app/pkg/pkg/verifycode"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//HeaderDeviceName 客户端上报的设备名称，记录到登录会话中
+const HeaderDeviceName = "X-Device-Name"
+
+//OAuthNonceCookie 获取授权页面地址时写入的cookie，授权回调登录、关联时必须由同一个浏览器带上
+const OAuthNonceCookie = "oauth_nonce"
+
+type Handler struct {
+	svc     Service
+	tokens  Tokens
+	captcha core.HandlerFunc
+}
+
+type HandlerOption func(*Handler)
+
+//WithLoginCaptcha 邮箱登录失败次数达到 lockout 的等待次数后，登录需要同时校验图形验证码
+func WithLoginCaptcha(svc captcha.Service) HandlerOption {
+	return func(h *Handler) {
+		h.captcha = core.RequireCaptcha(svc)
+	}
+}
+
+func NewHandler(svc Service, tokens Tokens, options ...HandlerOption) *Handler {
+	h := &Handler{svc: svc, tokens: tokens}
+	for _, f := range options {
+		f(h)
+	}
+	return h
+}
+
+//Route 注册用户接口，auth 为登录鉴权
+//  POST /register          邮箱验证码注册
+//  POST /login             邮箱密码登录，开启了二次验证时返回 mfa_token，失败次数较多时需要图形验证码
+//  POST /login/sms         手机号短信验证码登录，开启了二次验证时返回 mfa_token
+//  POST /login/mfa         使用 mfa_token 和验证码完成登录
+//  POST /token/refresh     刷新token
+//  POST /logout            注销当前设备
+//  POST /password/reset    邮箱验证码重置密码
+//  POST /unlock            邮箱验证码解除登录锁定
+//  GET  /profile           个人资料
+//  PUT  /profile           修改个人资料
+//  PUT  /password          修改密码，其他设备需要重新登录
+//  PUT  /phone             绑定或更换手机号，需要新手机号的短信验证码
+//  POST /mfa/setup         获取二次验证秘钥和二维码
+//  POST /mfa/enable        校验验证码开启二次验证，返回恢复码
+//  POST /mfa/disable       关闭二次验证
+//  POST /mfa/recovery-codes 重新生成恢复码
+//  GET  /oauth/providers   已配置的第三方登录平台
+//  GET  /oauth/:provider/url 第三方授权页面地址，同时写入 oauth_nonce cookie
+//  POST /oauth/:provider/login 授权回调的code和state登录，未关联时按验证过的邮箱关联或注册
+//  GET  /oauth/accounts    已关联的第三方账号
+//  GET  /oauth/:provider/link/url 关联第三方账号的授权页面地址
+//  POST /oauth/:provider/link 授权回调的code和state关联第三方账号
+//  DELETE /oauth/:provider 解除关联第三方账号
+func (h *Handler) Route(group core.RouteGroup, auth core.HandlerFunc) {
+	group.POST("/register", core.MaskLogKeys("code"), h.register)
+	group.POST("/login", h.login)
+	group.POST("/login/sms", core.MaskLogKeys("code"), h.loginSMS)
+	group.POST("/login/mfa", core.MaskLogKeys("code"), h.loginMFA)
+	group.POST("/token/refresh", h.refresh)
+	group.POST("/logout", h.logout)
+	group.POST("/password/reset", core.MaskLogKeys("code"), h.resetPassword)
+	group.POST("/unlock", core.MaskLogKeys("code"), h.unlock)
+	group.GET("/profile", auth, h.profile)
+	group.PUT("/profile", auth, h.updateProfile)
+	group.PUT("/password", auth, h.changePassword)
+	group.PUT("/phone", auth, core.MaskLogKeys("code"), h.bindPhone)
+	group.POST("/mfa/setup", auth, h.mfaSetup)
+	group.POST("/mfa/enable", auth, core.MaskLogKeys("code"), h.mfaEnable)
+	group.POST("/mfa/disable", auth, core.MaskLogKeys("code"), h.mfaDisable)
+	group.POST("/mfa/recovery-codes", auth, core.MaskLogKeys("code"), h.mfaRecoveryCodes)
+	group.GET("/oauth/providers", h.oauthProviders)
+	group.GET("/oauth/:provider/url", h.oauthURL)
+	group.POST("/oauth/:provider/login", core.MaskLogKeys("code", "state"), h.oauthLogin)
+	group.GET("/oauth/accounts", auth, h.oauthAccounts)
+	group.GET("/oauth/:provider/link/url", auth, h.oauthURL)
+	group.POST("/oauth/:provider/link", auth, core.MaskLogKeys("code", "state"), h.oauthLink)
+	group.DELETE("/oauth/:provider", auth, h.oauthUnlink)
+}
+
+//deviceContext 把请求的设备信息放入ctx，登录和刷新token时记录到会话
+func deviceContext(ctx core.Context) context.Context {
+	return login.WithDevice(ctx.SvcContext().Context(), login.Device{
+		Name:      ctx.GetHeader(HeaderDeviceName),
+		UserAgent: ctx.GetHeader("User-Agent"),
+		IP:        ctx.RequestContext().ClientIP(),
+	})
+}
+
+//abort 把service返回的错误转换为接口错误，fallback 为未知错误时的业务码
+func abort(ctx core.Context, err error, fallback int) {
+	switch {
+	case errors.Is(err, ErrEmailExists):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusConflict, response.EmailIsExists))
+	case errors.Is(err, ErrPassword):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusUnauthorized, response.PwdError))
+	case errors.Is(err, ErrUserNotFound):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusNotFound, response.UserNotExits))
+	case errors.Is(err, ErrUserDisabled):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusForbidden, response.UserDisabled))
+	case errors.Is(err, ErrPhoneExists):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusConflict, response.PhoneExists))
+	case errors.Is(err, ErrPhoneInvalid):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.PhoneInvalid))
+	case errors.Is(err, ErrPasswordTooWeak):
+		ctx.AbortWithError(response.NewError(http.StatusBadRequest, response.ParamBindError, ErrPasswordTooWeak.Error()))
+	case errors.Is(err, verifycode.ErrCodeInvalid), errors.Is(err, verifycode.ErrTooManyAttempts):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.ValidEmailCodeFail))
+	case errors.Is(err, ErrMFACodeInvalid):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.MFACodeInvalid))
+	case errors.Is(err, ErrMFAAlreadyEnabled):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusConflict, response.MFAAlreadyEnabled))
+	case errors.Is(err, ErrMFANotEnabled):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.MFANotEnabled))
+	case errors.Is(err, model.MFATokenInvalid):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusUnauthorized, response.MFATokenInvalid))
+	case errors.Is(err, ErrOAuthDisabled), errors.Is(err, oauth.ErrUnknownProvider):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusNotFound, response.OAuthUnknown))
+	case errors.Is(err, oauth.ErrStateInvalid):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.OAuthStateInvalid))
+	case errors.Is(err, oauth.ErrProvider):
+		ctx.Logger().Warn("第三方授权失败", zap.Error(err))
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadGateway, response.OAuthFailed))
+	case errors.Is(err, ErrOAuthEmailUnverified):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusForbidden, response.OAuthNoEmail))
+	case errors.Is(err, ErrOAuthLinked):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusConflict, response.OAuthLinked))
+	case errors.Is(err, ErrOAuthNotLinked):
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusNotFound, response.OAuthNotLinked))
+	case errors.Is(err, lockout.ErrLocked):
+		setRetryAfter(ctx, lockout.RetryAfter(err))
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusTooManyRequests, response.AccountLocked))
+	case errors.Is(err, lockout.ErrTooFrequent):
+		setRetryAfter(ctx, lockout.RetryAfter(err))
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusTooManyRequests, response.TooManyRequests))
+	default:
+		ctx.Logger().Error("用户接口失败", zap.Error(err))
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusInternalServerError, fallback).WithErr(err))
+	}
+}
+
+//setRetryAfter 告诉客户端需要等待的秒数
+func setRetryAfter(ctx core.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	ctx.SetHeader("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+}
+
+func bindJSON(ctx core.Context, obj interface{}) bool {
+	if err := ctx.ShouldBindJSON(obj); err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.ParamBindError).WithErr(err))
+		return false
+	}
+	return true
+}
+
+//loginResponse 需要二次验证时不返回用户信息，token中为 mfa_token
+type loginResponse struct {
+	User        *User       `json:"user,omitempty"`
+	MFARequired bool        `json:"mfa_required"`
+	Token       interface{} `json:"token"`
+}
+
+func newLoginResponse(u *User, resp *model.LoginResponse) *loginResponse {
+	if _, ok := resp.Token.(*model.LoginResponseByMFA); ok {
+		return &loginResponse{MFARequired: true, Token: resp.Token}
+	}
+	return &loginResponse{User: u, Token: resp.Token}
+}
+
+type phoneCodeRequest struct {
+	Phone string `json:"phone" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
+
+func (h *Handler) register(ctx core.Context) {
+	var req RegisterRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	u, err := h.svc.Register(ctx.SvcContext().Context(), &req)
+	if err != nil {
+		abort(ctx, err, response.CreateUserError)
+		return
+	}
+	ctx.Payload(u)
+}
+
+func (h *Handler) login(ctx core.Context) {
+	var req struct {
+		Email    string `json:"email" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	if h.captcha != nil {
+		need, err := h.svc.LoginNeedCaptcha(ctx.SvcContext().Context(), req.Email)
+		if err != nil {
+			abort(ctx, err, response.ServerError)
+			return
+		}
+		if need {
+			h.captcha(ctx)
+			if ctx.RequestContext().IsAborted() {
+				return
+			}
+		}
+	}
+	u, resp, err := h.svc.Login(deviceContext(ctx), req.Email, req.Password)
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(newLoginResponse(u, resp))
+}
+
+func (h *Handler) loginSMS(ctx core.Context) {
+	var req phoneCodeRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	u, resp, err := h.svc.LoginSMS(deviceContext(ctx), req.Phone, req.Code)
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(newLoginResponse(u, resp))
+}
+
+func (h *Handler) loginMFA(ctx core.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	u, resp, err := h.svc.LoginMFA(deviceContext(ctx), req.MFAToken, req.Code)
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(&loginResponse{User: u, Token: resp.Token})
+}
+
+func (h *Handler) refresh(ctx core.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	resp, err := h.tokens.RefreshToken(deviceContext(ctx), req.RefreshToken)
+	if err != nil {
+		if !errors.Is(err, model.RefreshTokenInvalid) && !errors.Is(err, model.RefreshTokenReused) {
+			ctx.Logger().Error("刷新token失败", zap.Error(err))
+		}
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusUnauthorized, response.RefreshTokenError))
+		return
+	}
+	ctx.Payload(resp)
+}
+
+func (h *Handler) logout(ctx core.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	if err := h.tokens.TokenCancel(ctx.SvcContext().Context(), req.RefreshToken); err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(nil)
+}
+
+func (h *Handler) resetPassword(ctx core.Context) {
+	var req struct {
+		Email    string `json:"email" binding:"required,email"`
+		Code     string `json:"code" binding:"required"`
+		Password string `json:"password" binding:"required,min=8,max=72"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	if err := h.svc.ResetPassword(ctx.SvcContext().Context(), req.Email, req.Code, req.Password); err != nil {
+		abort(ctx, err, response.ChangePWDFail)
+		return
+	}
+	ctx.Payload(nil)
+}
+
+func (h *Handler) unlock(ctx core.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+		Code  string `json:"code" binding:"required"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	if err := h.svc.Unlock(ctx.SvcContext().Context(), req.Email, req.Code); err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(nil)
+}
+
+func (h *Handler) profile(ctx core.Context) {
+	u, err := h.svc.Profile(ctx.SvcContext().Context(), ctx.UserID())
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(u)
+}
+
+func (h *Handler) updateProfile(ctx core.Context) {
+	var req ProfileRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	u, err := h.svc.UpdateProfile(ctx.SvcContext().Context(), ctx.UserID(), &req)
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(u)
+}
+
+func (h *Handler) changePassword(ctx core.Context) {
+	var req struct {
+		OldPassword string `json:"old_password" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required,min=8,max=72"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	var session string
+	if claims := ctx.Claims(); claims != nil {
+		session = claims.SessionID
+	}
+	err := h.svc.ChangePassword(ctx.SvcContext().Context(), ctx.UserID(), session, req.OldPassword, req.NewPassword)
+	if err != nil {
+		abort(ctx, err, response.ChangePWDFail)
+		return
+	}
+	ctx.Payload(nil)
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"` //验证码，关闭和重新生成恢复码时也可以使用恢复码
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (h *Handler) bindPhone(ctx core.Context) {
+	var req phoneCodeRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	u, err := h.svc.BindPhone(ctx.SvcContext().Context(), ctx.UserID(), req.Phone, req.Code)
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(u)
+}
+
+func (h *Handler) mfaSetup(ctx core.Context) {
+	setup, err := h.svc.MFASetup(ctx.SvcContext().Context(), ctx.UserID())
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(setup)
+}
+
+func (h *Handler) mfaEnable(ctx core.Context) {
+	var req mfaCodeRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	codes, err := h.svc.MFAEnable(ctx.SvcContext().Context(), ctx.UserID(), req.Code)
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(&recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *Handler) mfaDisable(ctx core.Context) {
+	var req mfaCodeRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	if err := h.svc.MFADisable(ctx.SvcContext().Context(), ctx.UserID(), req.Code); err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(nil)
+}
+
+func (h *Handler) mfaRecoveryCodes(ctx core.Context) {
+	var req mfaCodeRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	codes, err := h.svc.MFARecoveryCodes(ctx.SvcContext().Context(), ctx.UserID(), req.Code)
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(&recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+type oauthURI struct {
+	Provider string `uri:"provider" binding:"required"`
+}
+
+type oauthCodeRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+func bindOAuthURI(ctx core.Context) (string, bool) {
+	var uri oauthURI
+	if err := ctx.ShouldBindURL(&uri); err != nil {
+		ctx.AbortWithError(response.NewErrorAutoMsg(http.StatusBadRequest, response.ParamBindError).WithErr(err))
+		return "", false
+	}
+	return uri.Provider, true
+}
+
+func (h *Handler) oauthProviders(ctx core.Context) {
+	ctx.Payload(h.svc.OAuthProviders())
+}
+
+//oauthURL 登录时未经过auth，UserID为0；关联时为当前用户
+func (h *Handler) oauthURL(ctx core.Context) {
+	provider, ok := bindOAuthURI(ctx)
+	if !ok {
+		return
+	}
+	u, nonce, err := h.svc.OAuthURL(ctx.SvcContext().Context(), provider, ctx.UserID())
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	setOAuthNonce(ctx, nonce, 0)
+	ctx.Payload(map[string]string{"url": u})
+}
+
+//setOAuthNonce 发起授权时写入cookie，回调时校验后清除；maxAge为0时浏览器关闭后失效，小于0时删除
+func setOAuthNonce(ctx core.Context, nonce string, maxAge int) {
+	req := ctx.RequestContext().Request
+	secure := req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https"
+	ctx.RequestContext().SetSameSite(http.SameSiteLaxMode)
+	ctx.RequestContext().SetCookie(OAuthNonceCookie, nonce, maxAge, "/", "", secure, true)
+}
+
+//oauthNonce 回调时读取并清除发起授权时的nonce
+func oauthNonce(ctx core.Context) string {
+	nonce, _ := ctx.RequestContext().Cookie(OAuthNonceCookie)
+	setOAuthNonce(ctx, "", -1)
+	return nonce
+}
+
+func (h *Handler) oauthLogin(ctx core.Context) {
+	provider, ok := bindOAuthURI(ctx)
+	if !ok {
+		return
+	}
+	var req oauthCodeRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	u, resp, err := h.svc.OAuthLogin(deviceContext(ctx), provider, req.Code, req.State, oauthNonce(ctx))
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(newLoginResponse(u, resp))
+}
+
+func (h *Handler) oauthAccounts(ctx core.Context) {
+	accounts, err := h.svc.OAuthAccounts(ctx.SvcContext().Context(), ctx.UserID())
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(accounts)
+}
+
+func (h *Handler) oauthLink(ctx core.Context) {
+	provider, ok := bindOAuthURI(ctx)
+	if !ok {
+		return
+	}
+	var req oauthCodeRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	account, err := h.svc.OAuthLink(ctx.SvcContext().Context(), ctx.UserID(), provider, req.Code, req.State, oauthNonce(ctx))
+	if err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(account)
+}
+
+func (h *Handler) oauthUnlink(ctx core.Context) {
+	provider, ok := bindOAuthURI(ctx)
+	if !ok {
+		return
+	}
+	if err := h.svc.OAuthUnlink(ctx.SvcContext().Context(), ctx.UserID(), provider); err != nil {
+		abort(ctx, err, response.ServerError)
+		return
+	}
+	ctx.Payload(nil)
+}
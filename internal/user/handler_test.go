@@ -0,0 +1,83 @@
+package user
+
+import (
+	"context"
+	"github/xujialingit/shopping-app/pkg/core"
+	"github/xujialingit/shopping-app/pkg/pkg/captcha"
+	"github/xujialingit/shopping-app/pkg/pkg/response"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+//fakeCaptcha 答案固定为1234
+type fakeCaptcha struct {
+	captcha.Service
+}
+
+func (fakeCaptcha) Verify(ctx context.Context, id, answer string) error {
+	if answer != "1234" {
+		return captcha.ErrInvalid
+	}
+	return nil
+}
+
+func TestHandler_LoginCaptcha(t *testing.T) {
+	ctx := context.Background()
+	svc, tokens, _ := newTestService(t, WithLockout(&fakeLockout{failures: make(map[string]int)}))
+	_, err := svc.Create(ctx, "tom@test.com", "password1", "tom")
+	assert.NoError(t, err)
+
+	engine, err := core.New("api", zap.NewNop(), core.WithDisablePProf(), core.WithDisableSwagger(), core.WithDisablePrometheus())
+	assert.NoError(t, err)
+	NewHandler(svc, tokens, WithLoginCaptcha(fakeCaptcha{})).Route(engine.Group("/user"), func(ctx core.Context) {})
+
+	login := func(password string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(`{"email":"Tom@test.com","password":"`+password+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	//失败次数较少时不需要图形验证码
+	assert.Equal(t, http.StatusUnauthorized, login("wrong-password").Code)
+	assert.Equal(t, http.StatusUnauthorized, login("wrong-password").Code)
+
+	w := login("password1")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), strconv.Itoa(response.CaptchaRequired))
+
+	w = login("password1", core.HeaderCaptchaID, "c1", core.HeaderCaptchaAnswer, "0000")
+	assert.Contains(t, w.Body.String(), strconv.Itoa(response.CaptchaInvalid))
+
+	w = login("password1", core.HeaderCaptchaID, "c1", core.HeaderCaptchaAnswer, "1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandler_OAuthAccessLog(t *testing.T) {
+	svc, tokens, _ := newTestService(t)
+	obs, logs := observer.New(zapcore.DebugLevel)
+	engine, err := core.New("api", zap.New(obs), core.WithDisablePProf(), core.WithDisableSwagger(), core.WithDisablePrometheus())
+	assert.NoError(t, err)
+	NewHandler(svc, tokens).Route(engine.Group("/user"), func(ctx core.Context) {})
+
+	//授权回调的code和state不记录
+	req := httptest.NewRequest(http.MethodPost, "/api/user/oauth/github/login", strings.NewReader(`{"code":"c1","state":"s1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+	access := logs.FilterMessage("access").All()
+	if assert.Len(t, access, 1) {
+		assert.Equal(t, `{"code":"******","state":"******"}`, access[0].ContextMap()["body"])
+	}
+}
@@ -0,0 +1,341 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github/xujialingit/shopping-app/pkg/db"
+	"github/xujialingit/shopping-app/pkg/outbox"
+	"github/xujialingit/shopping-app/pkg/pkg/login"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"github/xujialingit/shopping-app/pkg/pkg/totp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EventMFAEnabled  = "user.mfa_enabled"
+	EventMFADisabled = "user.mfa_disabled"
+
+	//RecoveryCodeCount 每次生成的恢复码数量
+	RecoveryCodeCount = 10
+	qrCodeSize        = 256
+)
+
+var (
+	ErrMFACodeInvalid    = errors.New("user: 二次验证码错误")
+	ErrMFAAlreadyEnabled = errors.New("user: 已开启二次验证")
+	ErrMFANotEnabled     = errors.New("user: 未开启二次验证")
+)
+
+//MFA user_mfa表，Secret加密存储；调用 MFASetup 后 Enabled 为false，校验一次验证码后开启
+type MFA struct {
+	db.BaseModel
+	UserID      int64  `gorm:"uniqueIndex;not null"`
+	Secret      string `gorm:"size:255;serializer:pii"`
+	Enabled     bool   `gorm:"not null;default:false"`
+	LastCounter int64  //最后一次使用的TOTP周期，同一个验证码不能使用两次
+	EnabledAt   *time.Time
+}
+
+func (MFA) TableName() string {
+	return "user_mfa"
+}
+
+//RecoveryCode user_recovery_code表，丢失验证器时代替验证码使用，只保存hash，每个只能使用一次
+type RecoveryCode struct {
+	ID        int64  `gorm:"primaryKey"`
+	UserID    int64  `gorm:"index;not null"`
+	CodeHash  string `gorm:"size:64;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (RecoveryCode) TableName() string {
+	return "user_recovery_code"
+}
+
+//MFASetup 导入验证器需要的信息，QRCode 为 data:image/png;base64 格式的二维码
+type MFASetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qr_code"`
+}
+
+//MFATokens 两步登录的mfa token，login.MFATokenSystem 实现
+type MFATokens interface {
+	Pending(ctx context.Context, userId int, userName string) (*model.LoginResponse, error)
+	Complete(ctx context.Context, mfaToken string, verify func(ctx context.Context, userId int64) error) (int64, *model.LoginResponse, error)
+}
+
+//mfaOption 二次验证配置，没有配置时不检查用户是否开启了二次验证
+type mfaOption struct {
+	tokens MFATokens
+	otp    *totp.TOTP
+	issuer string //验证器中显示的名称
+}
+
+//WithMFA 开启二次验证，开启了二次验证的用户登录时先返回 mfa token
+func WithMFA(tokens MFATokens, otp *totp.TOTP, issuer string) Option {
+	return func(opt *option) {
+		opt.mfa = &mfaOption{tokens: tokens, otp: otp, issuer: issuer}
+	}
+}
+
+//getMFA 不存在时返回 ErrMFANotEnabled
+func (s *service) getMFA(ctx context.Context, userId int64) (*MFA, error) {
+	m := new(MFA)
+	err := s.db.GetDb(ctx).Where("user_id = ?", userId).Take(m).Error
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrMFANotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *service) mfaEnabled(ctx context.Context, userId int64) (bool, error) {
+	m, err := s.getMFA(ctx, userId)
+	if errors.Is(err, ErrMFANotEnabled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return m.Enabled, nil
+}
+
+func (s *service) MFASetup(ctx context.Context, userId int64) (*MFASetup, error) {
+	if s.opt.mfa == nil {
+		return nil, ErrMFANotEnabled
+	}
+	u, err := s.get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.getMFA(ctx, userId)
+	if err != nil && !errors.Is(err, ErrMFANotEnabled) {
+		return nil, err
+	}
+	if m != nil && m.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		err = s.db.GetDb(ctx).Create(&MFA{UserID: userId, Secret: secret}).Error
+	} else {
+		//重新获取时覆盖之前没有开启的秘钥
+		m.Secret = secret
+		err = s.db.GetDb(ctx).Model(m).Select("secret").Updates(m).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	uri := s.opt.mfa.otp.URI(s.opt.mfa.issuer, u.Email, secret)
+	png, err := totp.QRCode(uri, qrCodeSize)
+	if err != nil {
+		return nil, err
+	}
+	return &MFASetup{
+		Secret: secret,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+func (s *service) MFAEnable(ctx context.Context, userId int64, code string) ([]string, error) {
+	if s.opt.mfa == nil {
+		return nil, ErrMFANotEnabled
+	}
+	m, err := s.getMFA(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if m.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	counter, ok := s.opt.mfa.otp.Validate(m.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrMFACodeInvalid
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	m.Enabled = true
+	m.EnabledAt = &now
+	m.LastCounter = counter
+	err = s.db.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(m).Select("enabled", "enabled_at", "last_counter").Updates(m).Error; err != nil {
+			return err
+		}
+		if err := replaceRecoveryCodes(tx, userId, codes); err != nil {
+			return err
+		}
+		evt, err := outbox.NewEvent("user", userId, EventMFAEnabled, map[string]interface{}{"user_id": userId})
+		if err != nil {
+			return err
+		}
+		return outbox.Add(tx, evt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *service) MFADisable(ctx context.Context, userId int64, code string) error {
+	if err := s.verifyMFA(ctx, userId, code); err != nil {
+		return err
+	}
+	return s.db.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		//user_id是唯一索引，直接删除，之后可以重新开启
+		if err := tx.Unscoped().Where("user_id = ?", userId).Delete(&MFA{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		evt, err := outbox.NewEvent("user", userId, EventMFADisabled, map[string]interface{}{"user_id": userId})
+		if err != nil {
+			return err
+		}
+		return outbox.Add(tx, evt)
+	})
+}
+
+func (s *service) MFARecoveryCodes(ctx context.Context, userId int64, code string) ([]string, error) {
+	if err := s.verifyMFA(ctx, userId, code); err != nil {
+		return nil, err
+	}
+	codes, err := totp.GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userId, codes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+//replaceRecoveryCodes 删除旧的恢复码，保存新恢复码的hash
+func replaceRecoveryCodes(tx *gorm.DB, userId int64, codes []string) error {
+	if err := tx.Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	records := make([]RecoveryCode, len(codes))
+	for i, code := range codes {
+		records[i] = RecoveryCode{UserID: userId, CodeHash: totp.HashRecoveryCode(code)}
+	}
+	return tx.Create(&records).Error
+}
+
+//verifyMFA 校验TOTP验证码或恢复码，未开启时返回 ErrMFANotEnabled
+func (s *service) verifyMFA(ctx context.Context, userId int64, code string) error {
+	if s.opt.mfa == nil {
+		return ErrMFANotEnabled
+	}
+	m, err := s.getMFA(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !m.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	if counter, ok := s.opt.mfa.otp.Validate(m.Secret, code, time.Now()); ok {
+		//只接受比上次更新的周期，并发使用同一个验证码只有一个能成功
+		result := s.db.GetDb(ctx).Model(&MFA{}).
+			Where("user_id = ? AND last_counter < ?", userId, counter).
+			Update("last_counter", counter)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrMFACodeInvalid
+		}
+		return nil
+	}
+
+	result := s.db.GetDb(ctx).Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, totp.HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMFACodeInvalid
+	}
+	return nil
+}
+
+//pendingMFA 密码校验通过后，开启了二次验证的用户签发 mfa token
+func (s *service) pendingMFA(ctx context.Context, u *User) (*model.LoginResponse, bool, error) {
+	if s.opt.mfa == nil {
+		return nil, false, nil
+	}
+	enabled, err := s.mfaEnabled(ctx, u.ID)
+	if err != nil || !enabled {
+		return nil, false, err
+	}
+	resp, err := s.opt.mfa.tokens.Pending(ctx, int(u.ID), u.Nickname)
+	if err != nil {
+		return nil, false, err
+	}
+	return resp, true, nil
+}
+
+func (s *service) LoginMFA(ctx context.Context, mfaToken, code string) (*User, *model.LoginResponse, error) {
+	if s.opt.mfa == nil {
+		return nil, nil, model.MFATokenInvalid
+	}
+	var ip string
+	if device, ok := login.DeviceFromContext(ctx); ok {
+		ip = device.IP
+	}
+	var u *User
+	userId, resp, err := s.opt.mfa.tokens.Complete(ctx, mfaToken, func(ctx context.Context, userId int64) error {
+		var err error
+		if u, err = s.get(ctx, userId); err != nil {
+			return err
+		}
+		if s.opt.lockout != nil {
+			if err := s.opt.lockout.Check(ctx, u.Email, ip); err != nil {
+				return err
+			}
+		}
+		err = s.verifyMFA(ctx, userId, code)
+		if errors.Is(err, ErrMFACodeInvalid) && s.opt.lockout != nil {
+			//验证码错误同样计入登录失败次数，避免换mfa token继续猜测
+			if lockErr := s.opt.lockout.Fail(ctx, u.Email, ip); lockErr != nil {
+				return lockErr
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if u == nil || u.ID != userId {
+		return nil, nil, model.MFATokenInvalid
+	}
+	return u, resp, nil
+}
+
+//passwordLogin 密码登录的认证方式，二次验证后由 MFATokenSystem 追加 otp
+func passwordLogin(ctx context.Context) context.Context {
+	return login.WithAuthMethods(ctx, token.AMRPassword)
+}
@@ -0,0 +1,268 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github/xujialingit/shopping-app/pkg/db"
+	"github/xujialingit/shopping-app/pkg/outbox"
+	"github/xujialingit/shopping-app/pkg/pkg/login"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/oauth"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EventOAuthLinked   = "user.oauth_linked"
+	EventOAuthUnlinked = "user.oauth_unlinked"
+)
+
+var (
+	ErrOAuthDisabled        = errors.New("user: 未开启第三方登录")
+	ErrOAuthEmailUnverified = errors.New("user: 第三方账号没有验证过的邮箱")
+	ErrOAuthLinked          = errors.New("user: 第三方账号已关联其他用户")
+	ErrOAuthNotLinked       = errors.New("user: 未关联该第三方账号")
+)
+
+//OAuthAccount user_oauth_account表，第三方账号和用户的关联
+//同一个第三方账号只能关联一个用户，一个用户在每个平台只能关联一个账号
+type OAuthAccount struct {
+	ID        int64     `gorm:"primaryKey" json:"-"`
+	UserID    int64     `gorm:"not null;uniqueIndex:idx_user_oauth_account_user_provider" json:"-"`
+	Provider  string    `gorm:"size:32;not null;uniqueIndex:idx_user_oauth_account_user_provider;uniqueIndex:idx_user_oauth_account_subject" json:"provider"`
+	Subject   string    `gorm:"size:128;not null;uniqueIndex:idx_user_oauth_account_subject" json:"-"`
+	Name      string    `gorm:"size:64" json:"name"` //第三方账号的昵称，用于展示
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (OAuthAccount) TableName() string {
+	return "user_oauth_account"
+}
+
+//WithOAuth 开启第三方登录
+func WithOAuth(client oauth.Service) Option {
+	return func(opt *option) {
+		opt.oauth = client
+	}
+}
+
+func (s *service) OAuthProviders() []string {
+	if s.opt.oauth == nil {
+		return []string{}
+	}
+	return s.opt.oauth.Providers()
+}
+
+func (s *service) OAuthURL(ctx context.Context, provider string, userId int64) (string, string, error) {
+	if s.opt.oauth == nil {
+		return "", "", ErrOAuthDisabled
+	}
+	return s.opt.oauth.AuthCodeURL(ctx, provider, userId)
+}
+
+//findOAuthAccount 不存在时返回 ErrOAuthNotLinked
+func (s *service) findOAuthAccount(ctx context.Context, provider, subject string) (*OAuthAccount, error) {
+	account := new(OAuthAccount)
+	err := s.db.GetDb(ctx).Where("provider = ? AND subject = ?", provider, subject).Take(account).Error
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrOAuthNotLinked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+//exchange 授权码换取第三方账号，linkUserId 必须和发起授权时一致，避免登录和关联的state混用
+func (s *service) exchange(ctx context.Context, provider, code, state, nonce string, linkUserId int64) (*oauth.Identity, error) {
+	if s.opt.oauth == nil {
+		return nil, ErrOAuthDisabled
+	}
+	auth, err := s.opt.oauth.Exchange(ctx, provider, code, state, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if auth.LinkUserID != linkUserId {
+		return nil, oauth.ErrStateInvalid
+	}
+	return auth.Identity, nil
+}
+
+func (s *service) OAuthLogin(ctx context.Context, provider, code, state, nonce string) (*User, *model.LoginResponse, error) {
+	identity, err := s.exchange(ctx, provider, code, state, nonce, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var u *User
+	account, err := s.findOAuthAccount(ctx, provider, identity.Subject)
+	switch {
+	case err == nil:
+		u, err = s.get(ctx, account.UserID)
+	case errors.Is(err, ErrOAuthNotLinked):
+		u, err = s.linkByEmail(ctx, identity)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Status != StatusActive {
+		return nil, nil, ErrUserDisabled
+	}
+
+	ctx = login.WithAuthMethods(ctx, token.AMRFederated)
+	if resp, pending, err := s.pendingMFA(ctx, u); err != nil || pending {
+		return u, resp, err
+	}
+	resp, err := s.tokens.GenerateToken(ctx, int(u.ID), u.Nickname)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, resp, nil
+}
+
+//linkByEmail 第三方账号第一次登录，按第三方验证过的邮箱关联已有账号，邮箱没有注册时注册新账号
+//没有验证过的邮箱时不能确定是同一个人，需要用户登录后手动关联
+func (s *service) linkByEmail(ctx context.Context, identity *oauth.Identity) (*User, error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrOAuthEmailUnverified
+	}
+	u, err := s.findByEmail(ctx, identity.Email)
+	if err == nil {
+		if _, err := s.linkOAuthAccount(ctx, u.ID, identity); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+
+	//新账号使用随机密码，需要密码登录时通过邮箱重置
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	hash, err := HashPassword(base64.RawURLEncoding.EncodeToString(password))
+	if err != nil {
+		return nil, err
+	}
+	email := NormalizeEmail(identity.Email)
+	index, err := emailIndex(email)
+	if err != nil {
+		return nil, err
+	}
+	u = &User{
+		Email:             email,
+		EmailIndex:        index,
+		PasswordHash:      hash,
+		Nickname:          truncate(identity.Name, 64),
+		Avatar:            truncate(identity.Avatar, 512),
+		Status:            StatusActive,
+		PasswordChangedAt: time.Now(),
+	}
+	err = s.db.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := createUser(tx, u); err != nil {
+			return err
+		}
+		_, err := addOAuthAccount(tx, u.ID, identity)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *service) OAuthLink(ctx context.Context, userId int64, provider, code, state, nonce string) (*OAuthAccount, error) {
+	identity, err := s.exchange(ctx, provider, code, state, nonce, userId)
+	if err != nil {
+		return nil, err
+	}
+	return s.linkOAuthAccount(ctx, userId, identity)
+}
+
+//linkOAuthAccount 已经关联到userId时直接返回
+func (s *service) linkOAuthAccount(ctx context.Context, userId int64, identity *oauth.Identity) (*OAuthAccount, error) {
+	account, err := s.findOAuthAccount(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if account.UserID != userId {
+			return nil, ErrOAuthLinked
+		}
+		return account, nil
+	}
+	if !errors.Is(err, ErrOAuthNotLinked) {
+		return nil, err
+	}
+	err = s.db.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		account, err = addOAuthAccount(tx, userId, identity)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+//addOAuthAccount 在事务中关联第三方账号，用户在该平台已经关联了其他账号时返回 ErrOAuthLinked
+func addOAuthAccount(tx *gorm.DB, userId int64, identity *oauth.Identity) (*OAuthAccount, error) {
+	var count int64
+	err := tx.Model(&OAuthAccount{}).
+		Where("(provider = ? AND subject = ?) OR (user_id = ? AND provider = ?)", identity.Provider, identity.Subject, userId, identity.Provider).
+		Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrOAuthLinked
+	}
+	account := &OAuthAccount{
+		UserID:   userId,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Name:     truncate(identity.Name, 64),
+	}
+	if err := tx.Create(account).Error; err != nil {
+		return nil, err
+	}
+	evt, err := outbox.NewEvent("user", userId, EventOAuthLinked, map[string]interface{}{"user_id": userId, "provider": identity.Provider})
+	if err != nil {
+		return nil, err
+	}
+	return account, outbox.Add(tx, evt)
+}
+
+func (s *service) OAuthUnlink(ctx context.Context, userId int64, provider string) error {
+	return s.db.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND provider = ?", userId, provider).Delete(&OAuthAccount{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOAuthNotLinked
+		}
+		evt, err := outbox.NewEvent("user", userId, EventOAuthUnlinked, map[string]interface{}{"user_id": userId, "provider": provider})
+		if err != nil {
+			return err
+		}
+		return outbox.Add(tx, evt)
+	})
+}
+
+func (s *service) OAuthAccounts(ctx context.Context, userId int64) ([]OAuthAccount, error) {
+	accounts := make([]OAuthAccount, 0)
+	err := s.db.GetDb(ctx).Where("user_id = ?", userId).Order("id").Find(&accounts).Error
+	return accounts, err
+}
+
+//truncate 按字符截断第三方返回的昵称等，不超过数据库字段长度
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,161 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"github/xujialingit/shopping-app/pkg/db"
+	"github/xujialingit/shopping-app/pkg/outbox"
+	"github/xujialingit/shopping-app/pkg/pkg/login"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/pii"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"github/xujialingit/shopping-app/pkg/pkg/verifycode"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EventPhoneChanged = "user.phone_changed"
+
+	//PurposeSMSLogin 短信验证码登录
+	PurposeSMSLogin verifycode.Purpose = "sms_login"
+	//PurposeBindPhone 绑定或更换手机号，验证码发送到新手机号
+	PurposeBindPhone verifycode.Purpose = "bind_phone"
+)
+
+func init() {
+	verifycode.RegisterPurpose(PurposeSMSLogin)
+	verifycode.RegisterPurpose(PurposeBindPhone)
+}
+
+var (
+	ErrPhoneInvalid = errors.New("user: 手机号格式错误")
+	ErrPhoneExists  = errors.New("user: 手机号已被其他账号绑定")
+	ErrSMSDisabled  = errors.New("user: 未开启短信验证码")
+)
+
+var phonePattern = regexp.MustCompile(`^1[3-9]\d{9}$`)
+
+//NormalizePhone 只支持中国大陆手机号，去掉空格、横线和 +86 前缀，发送和校验验证码、计算盲索引前都需要处理
+func NormalizePhone(phone string) (string, error) {
+	phone = strings.NewReplacer(" ", "", "-", "").Replace(phone)
+	phone = strings.TrimPrefix(phone, "+")
+	if len(phone) == 13 {
+		phone = strings.TrimPrefix(phone, "86")
+	}
+	if !phonePattern.MatchString(phone) {
+		return "", ErrPhoneInvalid
+	}
+	return phone, nil
+}
+
+//WithSMS 开启短信验证码登录和绑定手机号，codes 使用短信发送验证码
+func WithSMS(codes verifycode.Service) Option {
+	return func(opt *option) {
+		opt.sms = codes
+	}
+}
+
+//findByPhone 不存在时返回 ErrUserNotFound，phone需要先 NormalizePhone
+func (s *service) findByPhone(ctx context.Context, phone string) (*User, error) {
+	index, err := pii.BlindIndex(phone)
+	if err != nil {
+		return nil, err
+	}
+	u := new(User)
+	err = s.users.DB(ctx).Where("phone_index = ?", index).Take(u).Error
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *service) LoginSMS(ctx context.Context, phone, code string) (*User, *model.LoginResponse, error) {
+	if s.opt.sms == nil {
+		return nil, nil, ErrSMSDisabled
+	}
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, nil, err
+	}
+	//先校验验证码，避免通过接口判断手机号是否绑定了账号
+	if err := s.opt.sms.Verify(ctx, PurposeSMSLogin, phone, code); err != nil {
+		return nil, nil, err
+	}
+	u, err := s.findByPhone(ctx, phone)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Status != StatusActive {
+		return nil, nil, ErrUserDisabled
+	}
+
+	ctx = login.WithAuthMethods(ctx, token.AMRSMS)
+	if resp, pending, err := s.pendingMFA(ctx, u); err != nil || pending {
+		return u, resp, err
+	}
+	resp, err := s.tokens.GenerateToken(ctx, int(u.ID), u.Nickname)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, resp, nil
+}
+
+func (s *service) BindPhone(ctx context.Context, userId int64, phone, code string) (*User, error) {
+	if s.opt.sms == nil {
+		return nil, ErrSMSDisabled
+	}
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if other, err := s.findByPhone(ctx, phone); err == nil {
+		if other.ID == userId {
+			return u, nil
+		}
+		return nil, ErrPhoneExists
+	} else if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	if err := s.opt.sms.Verify(ctx, PurposeBindPhone, phone, code); err != nil {
+		return nil, err
+	}
+
+	index, err := pii.BlindIndex(phone)
+	if err != nil {
+		return nil, err
+	}
+	u.Phone = phone
+	u.PhoneIndex = &index
+	err = s.db.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&User{}).Where("phone_index = ? AND id <> ?", index, userId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrPhoneExists
+		}
+		if err := tx.Model(u).Select("phone", "phone_index").Updates(u).Error; err != nil {
+			return err
+		}
+		//事件中不包含手机号
+		evt, err := outbox.NewEvent("user", userId, EventPhoneChanged, map[string]interface{}{"user_id": userId})
+		if err != nil {
+			return err
+		}
+		return outbox.Add(tx, evt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,408 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"github/xujialingit/shopping-app/pkg/db"
+	"github/xujialingit/shopping-app/pkg/outbox"
+	"github/xujialingit/shopping-app/pkg/pkg/lockout"
+	"github/xujialingit/shopping-app/pkg/pkg/login"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/oauth"
+	"github/xujialingit/shopping-app/pkg/pkg/verifycode"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EventRegistered      = "user.registered"
+	EventPasswordChanged = "user.password_changed"
+)
+
+//Tokens 登录签发的token，login.RefreshTokenSystem 实现
+//修改密码后需要注销其他设备
+type Tokens interface {
+	login.LoginTokenSystem
+	RevokeOtherSessions(ctx context.Context, userId int64, current string) error
+}
+
+type Service interface {
+	i()
+	//Register 使用邮箱验证码注册
+	Register(ctx context.Context, req *RegisterRequest) (*User, error)
+	//Create 直接创建用户，不需要验证码
+	Create(ctx context.Context, email, password, nickname string) (*User, error)
+	//CreateAdmin 直接创建管理员，用于命令行
+	CreateAdmin(ctx context.Context, email, password, nickname string) (*User, error)
+	//IsAdmin 用户是 CreateAdmin 创建的管理员且没有被禁用
+	IsAdmin(ctx context.Context, userId int64) (bool, error)
+	//Login 邮箱密码登录，邮箱不存在和密码错误都返回 ErrPassword
+	//配置了 WithLockout 时失败次数过多返回 lockout.ErrLocked 或 lockout.ErrTooFrequent
+	//配置了 WithMFA 且用户开启了二次验证时，返回的token为 *model.LoginResponseByMFA，需要再调用 LoginMFA
+	Login(ctx context.Context, email, password string) (*User, *model.LoginResponse, error)
+	//LoginNeedCaptcha 邮箱登录失败次数较多，需要图形验证码，没有配置 WithLockout 时返回false
+	LoginNeedCaptcha(ctx context.Context, email string) (bool, error)
+	//LoginSMS 短信验证码登录，手机号需要已绑定账号，开启了二次验证时和 Login 一样返回 mfa token
+	LoginSMS(ctx context.Context, phone, code string) (*User, *model.LoginResponse, error)
+	//OAuthProviders 已配置的第三方登录平台
+	OAuthProviders() []string
+	//OAuthURL 第三方授权页面地址和nonce，userId 不为0时为已登录用户关联第三方账号，回调后调用 OAuthLink
+	//nonce需要放入发起授权的浏览器的cookie，回调时原样传回
+	OAuthURL(ctx context.Context, provider string, userId int64) (authURL, nonce string, err error)
+	//OAuthLogin 第三方登录，已关联时直接登录；未关联时按第三方验证过的邮箱关联已有账号，邮箱没有注册时注册新账号
+	//开启了二次验证时和 Login 一样返回 mfa token
+	OAuthLogin(ctx context.Context, provider, code, state, nonce string) (*User, *model.LoginResponse, error)
+	//LoginMFA 两步登录的第二步，code为TOTP验证码或恢复码
+	LoginMFA(ctx context.Context, mfaToken, code string) (*User, *model.LoginResponse, error)
+	Profile(ctx context.Context, userId int64) (*User, error)
+	UpdateProfile(ctx context.Context, userId int64, req *ProfileRequest) (*User, error)
+	//BindPhone 绑定或更换手机号，code 为发送到新手机号的短信验证码
+	BindPhone(ctx context.Context, userId int64, phone, code string) (*User, error)
+	//OAuthLink 已登录用户关联第三方账号，state 必须是该用户通过 OAuthURL 发起的授权
+	OAuthLink(ctx context.Context, userId int64, provider, code, state, nonce string) (*OAuthAccount, error)
+	//OAuthUnlink 解除关联第三方账号
+	OAuthUnlink(ctx context.Context, userId int64, provider string) error
+	//OAuthAccounts 已关联的第三方账号
+	OAuthAccounts(ctx context.Context, userId int64) ([]OAuthAccount, error)
+	//ChangePassword 修改密码，注销除currentSession之外的全部设备
+	ChangePassword(ctx context.Context, userId int64, currentSession, oldPassword, newPassword string) error
+	//ResetPassword 通过邮箱验证码重置密码，注销全部设备并解除登录锁定
+	ResetPassword(ctx context.Context, email, code, newPassword string) error
+	//Unlock 通过邮箱验证码解除登录锁定
+	Unlock(ctx context.Context, email, code string) error
+	//UnlockByAdmin 管理员解除用户的登录锁定
+	UnlockByAdmin(ctx context.Context, userId int64) error
+	//MFASetup 生成TOTP秘钥，校验一次验证码后才会开启
+	MFASetup(ctx context.Context, userId int64) (*MFASetup, error)
+	//MFAEnable 校验验证码并开启二次验证，返回恢复码明文，只会返回这一次
+	MFAEnable(ctx context.Context, userId int64, code string) ([]string, error)
+	//MFADisable 关闭二次验证，需要验证码或恢复码
+	MFADisable(ctx context.Context, userId int64, code string) error
+	//MFARecoveryCodes 重新生成恢复码，旧的恢复码失效
+	MFARecoveryCodes(ctx context.Context, userId int64, code string) ([]string, error)
+}
+
+type RegisterRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Code     string `json:"code" binding:"required"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+	Nickname string `json:"nickname" binding:"max=64"`
+}
+
+//ProfileRequest 为nil的字段不修改
+type ProfileRequest struct {
+	Nickname *string `json:"nickname" binding:"omitempty,max=64"`
+	Avatar   *string `json:"avatar" binding:"omitempty,max=512"`
+}
+
+type Option func(*option)
+
+type option struct {
+	lockout lockout.Guard
+	mfa     *mfaOption
+	sms     verifycode.Service
+	oauth   oauth.Service
+}
+
+//WithLockout 登录失败次数限制，按邮箱和请求IP统计，IP从 login.WithDevice 放入ctx的设备信息中获取
+func WithLockout(guard lockout.Guard) Option {
+	return func(opt *option) {
+		opt.lockout = guard
+	}
+}
+
+type service struct {
+	db     db.Repo
+	users  *db.Repository[User]
+	tokens Tokens
+	codes  verifycode.Service
+	opt    *option
+}
+
+func New(repo db.Repo, tokens Tokens, codes verifycode.Service, options ...Option) Service {
+	opt := &option{}
+	for _, f := range options {
+		f(opt)
+	}
+	return &service{
+		db:     repo,
+		users:  db.NewRepository[User](repo),
+		tokens: tokens,
+		codes:  codes,
+		opt:    opt,
+	}
+}
+
+func (s *service) i() {}
+
+//findByEmail 不存在时返回 ErrUserNotFound
+func (s *service) findByEmail(ctx context.Context, email string) (*User, error) {
+	index, err := emailIndex(email)
+	if err != nil {
+		return nil, err
+	}
+	u := new(User)
+	err = s.users.DB(ctx).Where("email_index = ?", index).Take(u).Error
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *service) get(ctx context.Context, userId int64) (*User, error) {
+	u, err := s.users.Get(ctx, userId)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (s *service) Register(ctx context.Context, req *RegisterRequest) (*User, error) {
+	email := NormalizeEmail(req.Email)
+	if _, err := s.findByEmail(ctx, email); !errors.Is(err, ErrUserNotFound) {
+		if err == nil {
+			return nil, ErrEmailExists
+		}
+		return nil, err
+	}
+	if err := s.codes.Verify(ctx, verifycode.PurposeRegister, email, req.Code); err != nil {
+		return nil, err
+	}
+	return s.Create(ctx, email, req.Password, req.Nickname)
+}
+
+func (s *service) Create(ctx context.Context, email, password, nickname string) (*User, error) {
+	return s.create(ctx, email, password, nickname, false)
+}
+
+func (s *service) CreateAdmin(ctx context.Context, email, password, nickname string) (*User, error) {
+	return s.create(ctx, email, password, nickname, true)
+}
+
+func (s *service) IsAdmin(ctx context.Context, userId int64) (bool, error) {
+	u, err := s.get(ctx, userId)
+	if errors.Is(err, ErrUserNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return u.Admin && u.Status == StatusActive, nil
+}
+
+func (s *service) create(ctx context.Context, email, password, nickname string, admin bool) (*User, error) {
+	email = NormalizeEmail(email)
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	index, err := emailIndex(email)
+	if err != nil {
+		return nil, err
+	}
+	u := &User{
+		Email:             email,
+		EmailIndex:        index,
+		PasswordHash:      hash,
+		Nickname:          nickname,
+		Status:            StatusActive,
+		PasswordChangedAt: time.Now(),
+		Admin:             admin,
+	}
+
+	err = s.db.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		return createUser(tx, u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+//createUser 在事务中创建用户并写入注册事件，邮箱已存在时返回 ErrEmailExists
+func createUser(tx *gorm.DB, u *User) error {
+	var count int64
+	if err := tx.Model(&User{}).Where("email_index = ?", u.EmailIndex).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEmailExists
+	}
+	if err := tx.Create(u).Error; err != nil {
+		return err
+	}
+	evt, err := outbox.NewEvent("user", u.ID, EventRegistered, map[string]interface{}{"user_id": u.ID})
+	if err != nil {
+		return err
+	}
+	return outbox.Add(tx, evt)
+}
+
+func (s *service) Login(ctx context.Context, email, password string) (*User, *model.LoginResponse, error) {
+	email = NormalizeEmail(email)
+	var ip string
+	if device, ok := login.DeviceFromContext(ctx); ok {
+		ip = device.IP
+	}
+	if s.opt.lockout != nil {
+		if err := s.opt.lockout.Check(ctx, email, ip); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	u, err := s.findByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		CheckPassword(string(dummyHash), password)
+		return nil, nil, s.loginFailed(ctx, email, ip)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !CheckPassword(u.PasswordHash, password) {
+		return nil, nil, s.loginFailed(ctx, email, ip)
+	}
+	if u.Status != StatusActive {
+		return nil, nil, ErrUserDisabled
+	}
+	if s.opt.lockout != nil {
+		if err := s.opt.lockout.Success(ctx, email); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ctx = passwordLogin(ctx)
+	if resp, pending, err := s.pendingMFA(ctx, u); err != nil || pending {
+		return u, resp, err
+	}
+	resp, err := s.tokens.GenerateToken(ctx, int(u.ID), u.Nickname)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, resp, nil
+}
+
+func (s *service) LoginNeedCaptcha(ctx context.Context, email string) (bool, error) {
+	if s.opt.lockout == nil {
+		return false, nil
+	}
+	return s.opt.lockout.NeedCaptcha(ctx, NormalizeEmail(email))
+}
+
+//loginFailed 记录失败次数，本次失败导致锁定时返回锁定错误，否则返回 ErrPassword
+//邮箱不存在时同样计数，避免通过是否锁定判断邮箱是否注册
+func (s *service) loginFailed(ctx context.Context, email, ip string) error {
+	if s.opt.lockout == nil {
+		return ErrPassword
+	}
+	if err := s.opt.lockout.Fail(ctx, email, ip); err != nil {
+		return err
+	}
+	return ErrPassword
+}
+
+func (s *service) Profile(ctx context.Context, userId int64) (*User, error) {
+	return s.get(ctx, userId)
+}
+
+func (s *service) UpdateProfile(ctx context.Context, userId int64, req *ProfileRequest) (*User, error) {
+	u, err := s.get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0, 2)
+	if req.Nickname != nil {
+		u.Nickname = *req.Nickname
+		fields = append(fields, "nickname")
+	}
+	if req.Avatar != nil {
+		u.Avatar = *req.Avatar
+		fields = append(fields, "avatar")
+	}
+	if len(fields) == 0 {
+		return u, nil
+	}
+	if err := s.users.Update(ctx, u, fields...); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *service) ChangePassword(ctx context.Context, userId int64, currentSession, oldPassword, newPassword string) error {
+	u, err := s.get(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !CheckPassword(u.PasswordHash, oldPassword) {
+		return ErrPassword
+	}
+	if err := s.setPassword(ctx, u, newPassword); err != nil {
+		return err
+	}
+	return s.tokens.RevokeOtherSessions(ctx, userId, currentSession)
+}
+
+func (s *service) ResetPassword(ctx context.Context, email, code, newPassword string) error {
+	email = NormalizeEmail(email)
+	if err := s.codes.Verify(ctx, verifycode.PurposeResetPassword, email, code); err != nil {
+		return err
+	}
+	u, err := s.findByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if err := s.setPassword(ctx, u, newPassword); err != nil {
+		return err
+	}
+	//已经通过邮箱验证，同时解除登录锁定
+	if s.opt.lockout != nil {
+		if err := s.opt.lockout.Unlock(ctx, email); err != nil {
+			return err
+		}
+	}
+	return s.tokens.RevokeOtherSessions(ctx, u.ID, "")
+}
+
+func (s *service) Unlock(ctx context.Context, email, code string) error {
+	email = NormalizeEmail(email)
+	if err := s.codes.Verify(ctx, verifycode.PurposeUnlock, email, code); err != nil {
+		return err
+	}
+	if s.opt.lockout == nil {
+		return nil
+	}
+	return s.opt.lockout.Unlock(ctx, email)
+}
+
+func (s *service) UnlockByAdmin(ctx context.Context, userId int64) error {
+	u, err := s.get(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if s.opt.lockout == nil {
+		return nil
+	}
+	return s.opt.lockout.Unlock(ctx, u.Email)
+}
+
+func (s *service) setPassword(ctx context.Context, u *User, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hash
+	u.PasswordChangedAt = time.Now()
+	return s.db.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(u).Select("password_hash", "password_changed_at").Updates(u).Error; err != nil {
+			return err
+		}
+		evt, err := outbox.NewEvent("user", u.ID, EventPasswordChanged, map[string]interface{}{"user_id": u.ID})
+		if err != nil {
+			return err
+		}
+		return outbox.Add(tx, evt)
+	})
+}
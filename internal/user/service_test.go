@@ -0,0 +1,520 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"github/xujialingit/shopping-app/pkg/db"
+	"github/xujialingit/shopping-app/pkg/outbox"
+	"github/xujialingit/shopping-app/pkg/pkg/lockout"
+	"github/xujialingit/shopping-app/pkg/pkg/login"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/oauth"
+	"github/xujialingit/shopping-app/pkg/pkg/pii"
+	"github/xujialingit/shopping-app/pkg/pkg/totp"
+	"github/xujialingit/shopping-app/pkg/pkg/verifycode"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//fakeCodes 验证码固定为 123456，嵌入接口只是为了实现未导出的方法
+type fakeCodes struct {
+	verifycode.Service
+}
+
+func (fakeCodes) Send(ctx context.Context, purpose verifycode.Purpose, to string) error {
+	return nil
+}
+
+func (fakeCodes) Verify(ctx context.Context, purpose verifycode.Purpose, to string, code string) error {
+	if code != "123456" {
+		return verifycode.ErrCodeInvalid
+	}
+	return nil
+}
+
+type fakeTokens struct {
+	revoked map[int64]string //用户id -> 保留的会话
+}
+
+func (f *fakeTokens) GenerateToken(ctx context.Context, userId int, userName string) (*model.LoginResponse, error) {
+	return &model.LoginResponse{Token: &model.LoginResponseByRefreshToekn{AccessToken: "access", RefreshToken: "refresh"}}, nil
+}
+
+func (f *fakeTokens) TokenCancel(ctx context.Context, token string) error {
+	return nil
+}
+
+func (f *fakeTokens) ToeknCancelById(ctx context.Context, userId int, userName string) error {
+	return nil
+}
+
+func (f *fakeTokens) RefreshToken(ctx context.Context, refreshToken string) (*model.LoginResponse, error) {
+	return nil, model.RefreshTokenInvalid
+}
+
+func (f *fakeTokens) RevokeOtherSessions(ctx context.Context, userId int64, current string) error {
+	f.revoked[userId] = current
+	return nil
+}
+
+//fakeLockout 失败3次后锁定账号
+type fakeLockout struct {
+	lockout.Guard
+	failures map[string]int
+	ips      []string
+}
+
+func (f *fakeLockout) Check(ctx context.Context, account, ip string) error {
+	if f.failures[account] >= 3 {
+		return &lockout.Error{Err: lockout.ErrLocked, Scope: lockout.ScopeAccount}
+	}
+	return nil
+}
+
+func (f *fakeLockout) Fail(ctx context.Context, account, ip string) error {
+	f.failures[account]++
+	f.ips = append(f.ips, ip)
+	return f.Check(ctx, account, ip)
+}
+
+//NeedCaptcha 失败2次后需要图形验证码
+func (f *fakeLockout) NeedCaptcha(ctx context.Context, account string) (bool, error) {
+	return f.failures[account] >= 2, nil
+}
+
+func (f *fakeLockout) Success(ctx context.Context, account string) error {
+	delete(f.failures, account)
+	return nil
+}
+
+func (f *fakeLockout) Unlock(ctx context.Context, account string) error {
+	delete(f.failures, account)
+	return nil
+}
+
+//fakeMFATokens mfa token只能成功使用一次
+type fakeMFATokens struct {
+	pending map[string]int
+}
+
+func (f *fakeMFATokens) Pending(ctx context.Context, userId int, userName string) (*model.LoginResponse, error) {
+	mfaToken := randomKey()
+	f.pending[mfaToken] = userId
+	return &model.LoginResponse{Token: &model.LoginResponseByMFA{MFAToken: mfaToken}}, nil
+}
+
+func (f *fakeMFATokens) Complete(ctx context.Context, mfaToken string, verify func(ctx context.Context, userId int64) error) (int64, *model.LoginResponse, error) {
+	userId, ok := f.pending[mfaToken]
+	if !ok {
+		return 0, nil, model.MFATokenInvalid
+	}
+	if err := verify(ctx, int64(userId)); err != nil {
+		return 0, nil, err
+	}
+	delete(f.pending, mfaToken)
+	return int64(userId), &model.LoginResponse{Token: &model.LoginResponseByRefreshToekn{AccessToken: "access"}}, nil
+}
+
+func randomKey() string {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newTestService(t *testing.T, options ...Option) (Service, *fakeTokens, db.Repo) {
+	keyring, err := pii.NewKeyring(&pii.Config{
+		ActiveKey: "1",
+		Keys:      map[string]string{"1": randomKey()},
+		IndexKey:  randomKey(),
+	})
+	assert.NoError(t, err)
+	pii.SetDefault(keyring)
+
+	repo, err := db.New(&db.DBConfig{Driver: db.DriverSqlite})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = repo.DbClose() })
+	assert.NoError(t, repo.GetDb(context.Background()).AutoMigrate(&User{}, &MFA{}, &RecoveryCode{}, &OAuthAccount{}, &outbox.Event{}))
+
+	tokens := &fakeTokens{revoked: make(map[int64]string)}
+	return New(repo, tokens, fakeCodes{}, options...), tokens, repo
+}
+
+func TestService_Register(t *testing.T) {
+	ctx := context.Background()
+	svc, _, repo := newTestService(t)
+
+	_, err := svc.Register(ctx, &RegisterRequest{Email: "Tom@Test.com", Code: "000000", Password: "password1"})
+	assert.ErrorIs(t, err, verifycode.ErrCodeInvalid)
+
+	u, err := svc.Register(ctx, &RegisterRequest{Email: "Tom@Test.com", Code: "123456", Password: "password1", Nickname: "tom"})
+	assert.NoError(t, err)
+	assert.Equal(t, "tom@test.com", u.Email)
+	assert.NotEqual(t, "password1", u.PasswordHash)
+
+	//邮箱加密存储
+	var stored string
+	assert.NoError(t, repo.GetDb(ctx).Model(&User{}).Select("email").Where("id = ?", u.ID).Scan(&stored).Error)
+	assert.True(t, pii.IsEncrypted(stored))
+
+	_, err = svc.Register(ctx, &RegisterRequest{Email: " TOM@test.com", Code: "123456", Password: "password1"})
+	assert.ErrorIs(t, err, ErrEmailExists)
+
+	var events int64
+	assert.NoError(t, repo.GetDb(ctx).Model(&outbox.Event{}).Where("event_type = ?", EventRegistered).Count(&events).Error)
+	assert.Equal(t, int64(1), events)
+}
+
+func TestService_CreateAdmin(t *testing.T) {
+	ctx := context.Background()
+	svc, _, repo := newTestService(t)
+	admin, err := svc.CreateAdmin(ctx, "admin@test.com", "password1", "admin")
+	assert.NoError(t, err)
+	u, err := svc.Create(ctx, "tom@test.com", "password1", "tom")
+	assert.NoError(t, err)
+
+	ok, err := svc.IsAdmin(ctx, admin.ID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = svc.IsAdmin(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = svc.IsAdmin(ctx, admin.ID+100)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	//禁用后不再是管理员
+	assert.NoError(t, repo.GetDb(ctx).Model(&User{}).Where("id = ?", admin.ID).Update("status", StatusDisabled).Error)
+	ok, err = svc.IsAdmin(ctx, admin.ID)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestService_LoginAndPassword(t *testing.T) {
+	ctx := context.Background()
+	svc, tokens, _ := newTestService(t)
+	created, err := svc.Create(ctx, "jerry@test.com", "password1", "jerry")
+	assert.NoError(t, err)
+
+	t.Run("登录", func(t *testing.T) {
+		_, _, err := svc.Login(ctx, "nobody@test.com", "password1")
+		assert.ErrorIs(t, err, ErrPassword)
+		_, _, err = svc.Login(ctx, "jerry@test.com", "wrong-password")
+		assert.ErrorIs(t, err, ErrPassword)
+
+		u, resp, err := svc.Login(ctx, "Jerry@test.com", "password1")
+		assert.NoError(t, err)
+		assert.Equal(t, created.ID, u.ID)
+		assert.NotNil(t, resp.Token)
+	})
+
+	t.Run("修改资料", func(t *testing.T) {
+		nickname := "jerry2"
+		u, err := svc.UpdateProfile(ctx, created.ID, &ProfileRequest{Nickname: &nickname})
+		assert.NoError(t, err)
+		assert.Equal(t, "jerry2", u.Nickname)
+
+		u, err = svc.Profile(ctx, created.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "jerry2", u.Nickname)
+		assert.Equal(t, "jerry@test.com", u.Email)
+
+		_, err = svc.Profile(ctx, created.ID+100)
+		assert.ErrorIs(t, err, ErrUserNotFound)
+	})
+
+	t.Run("修改密码注销其他设备", func(t *testing.T) {
+		assert.ErrorIs(t, svc.ChangePassword(ctx, created.ID, "s1", "wrong-password", "password2"), ErrPassword)
+		assert.ErrorIs(t, svc.ChangePassword(ctx, created.ID, "s1", "password1", "short"), ErrPasswordTooWeak)
+
+		assert.NoError(t, svc.ChangePassword(ctx, created.ID, "s1", "password1", "password2"))
+		assert.Equal(t, "s1", tokens.revoked[created.ID])
+		_, _, err := svc.Login(ctx, "jerry@test.com", "password1")
+		assert.ErrorIs(t, err, ErrPassword)
+	})
+
+	t.Run("重置密码注销全部设备", func(t *testing.T) {
+		assert.ErrorIs(t, svc.ResetPassword(ctx, "jerry@test.com", "000000", "password3"), verifycode.ErrCodeInvalid)
+		assert.ErrorIs(t, svc.ResetPassword(ctx, "nobody@test.com", "123456", "password3"), ErrUserNotFound)
+
+		assert.NoError(t, svc.ResetPassword(ctx, "jerry@test.com", "123456", "password3"))
+		assert.Equal(t, "", tokens.revoked[created.ID])
+		_, _, err := svc.Login(ctx, "jerry@test.com", "password3")
+		assert.NoError(t, err)
+	})
+}
+
+func TestService_Lockout(t *testing.T) {
+	guard := &fakeLockout{failures: make(map[string]int)}
+	svc, _, _ := newTestService(t, WithLockout(guard))
+	ctx := login.WithDevice(context.Background(), login.Device{IP: "10.0.0.1"})
+	created, err := svc.Create(ctx, "lock@test.com", "password1", "lock")
+	assert.NoError(t, err)
+
+	_, _, err = svc.Login(ctx, "lock@test.com", "wrong-password")
+	assert.ErrorIs(t, err, ErrPassword)
+	//成功后清空失败次数
+	_, _, err = svc.Login(ctx, "LOCK@test.com", "password1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, guard.failures["lock@test.com"])
+
+	//邮箱不存在同样计数
+	_, _, err = svc.Login(ctx, "nobody@test.com", "password1")
+	assert.ErrorIs(t, err, ErrPassword)
+	assert.Equal(t, 1, guard.failures["nobody@test.com"])
+
+	for i := 0; i < 2; i++ {
+		_, _, err = svc.Login(ctx, "lock@test.com", "wrong-password")
+		assert.ErrorIs(t, err, ErrPassword)
+	}
+	_, _, err = svc.Login(ctx, "lock@test.com", "wrong-password")
+	assert.ErrorIs(t, err, lockout.ErrLocked)
+	//锁定后密码正确也不能登录
+	_, _, err = svc.Login(ctx, "lock@test.com", "password1")
+	assert.ErrorIs(t, err, lockout.ErrLocked)
+	assert.Equal(t, "10.0.0.1", guard.ips[0])
+
+	assert.ErrorIs(t, svc.Unlock(ctx, "lock@test.com", "000000"), verifycode.ErrCodeInvalid)
+	assert.NoError(t, svc.Unlock(ctx, "lock@test.com", "123456"))
+	_, _, err = svc.Login(ctx, "lock@test.com", "password1")
+	assert.NoError(t, err)
+
+	guard.failures["lock@test.com"] = 3
+	assert.NoError(t, svc.UnlockByAdmin(ctx, created.ID))
+	_, _, err = svc.Login(ctx, "lock@test.com", "password1")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, svc.UnlockByAdmin(ctx, created.ID+100), ErrUserNotFound)
+}
+
+func TestService_MFA(t *testing.T) {
+	ctx := context.Background()
+	otp := totp.New()
+	svc, _, _ := newTestService(t, WithMFA(&fakeMFATokens{pending: make(map[string]int)}, otp, "shopping"))
+	created, err := svc.Create(ctx, "mfa@test.com", "password1", "mfa")
+	assert.NoError(t, err)
+
+	login := func() string {
+		_, resp, err := svc.Login(ctx, "mfa@test.com", "password1")
+		assert.NoError(t, err)
+		pending, ok := resp.Token.(*model.LoginResponseByMFA)
+		if !assert.True(t, ok) {
+			return ""
+		}
+		return pending.MFAToken
+	}
+
+	//未开启时直接签发token
+	_, resp, err := svc.Login(ctx, "mfa@test.com", "password1")
+	assert.NoError(t, err)
+	assert.IsType(t, &model.LoginResponseByRefreshToekn{}, resp.Token)
+
+	setup, err := svc.MFASetup(ctx, created.ID)
+	assert.NoError(t, err)
+	assert.Contains(t, setup.URI, "otpauth://totp/shopping:mfa@test.com")
+	assert.Contains(t, setup.QRCode, "data:image/png;base64,")
+	//校验验证码之前还没有开启
+	_, resp, err = svc.Login(ctx, "mfa@test.com", "password1")
+	assert.NoError(t, err)
+	assert.IsType(t, &model.LoginResponseByRefreshToekn{}, resp.Token)
+
+	_, err = svc.MFAEnable(ctx, created.ID, "000000")
+	assert.ErrorIs(t, err, ErrMFACodeInvalid)
+	code, err := otp.Code(setup.Secret, time.Now())
+	assert.NoError(t, err)
+	recoveryCodes, err := svc.MFAEnable(ctx, created.ID, code)
+	assert.NoError(t, err)
+	assert.Len(t, recoveryCodes, RecoveryCodeCount)
+	_, err = svc.MFASetup(ctx, created.ID)
+	assert.ErrorIs(t, err, ErrMFAAlreadyEnabled)
+
+	t.Run("TOTP登录", func(t *testing.T) {
+		mfaToken := login()
+		//开启时使用过的验证码不能再次使用
+		_, _, err := svc.LoginMFA(ctx, mfaToken, code)
+		assert.ErrorIs(t, err, ErrMFACodeInvalid)
+
+		next, err := otp.Code(setup.Secret, time.Now().Add(totp.DefaultPeriod))
+		assert.NoError(t, err)
+		u, resp, err := svc.LoginMFA(ctx, mfaToken, next)
+		assert.NoError(t, err)
+		assert.Equal(t, created.ID, u.ID)
+		assert.IsType(t, &model.LoginResponseByRefreshToekn{}, resp.Token)
+
+		_, _, err = svc.LoginMFA(ctx, mfaToken, next)
+		assert.ErrorIs(t, err, model.MFATokenInvalid)
+	})
+
+	t.Run("恢复码", func(t *testing.T) {
+		_, _, err := svc.LoginMFA(ctx, login(), recoveryCodes[0])
+		assert.NoError(t, err)
+		//只能使用一次
+		_, _, err = svc.LoginMFA(ctx, login(), recoveryCodes[0])
+		assert.ErrorIs(t, err, ErrMFACodeInvalid)
+
+		newCodes, err := svc.MFARecoveryCodes(ctx, created.ID, recoveryCodes[1])
+		assert.NoError(t, err)
+		_, _, err = svc.LoginMFA(ctx, login(), recoveryCodes[2])
+		assert.ErrorIs(t, err, ErrMFACodeInvalid)
+
+		assert.ErrorIs(t, svc.MFADisable(ctx, created.ID, "wrong"), ErrMFACodeInvalid)
+		assert.NoError(t, svc.MFADisable(ctx, created.ID, newCodes[0]))
+		_, resp, err := svc.Login(ctx, "mfa@test.com", "password1")
+		assert.NoError(t, err)
+		assert.IsType(t, &model.LoginResponseByRefreshToekn{}, resp.Token)
+
+		//关闭后可以重新开启
+		_, err = svc.MFASetup(ctx, created.ID)
+		assert.NoError(t, err)
+	})
+}
+
+func TestService_Phone(t *testing.T) {
+	ctx := context.Background()
+	svc, _, repo := newTestService(t, WithSMS(fakeCodes{}))
+	tom, err := svc.Create(ctx, "tom@test.com", "password1", "tom")
+	assert.NoError(t, err)
+	jerry, err := svc.Create(ctx, "jerry@test.com", "password1", "jerry")
+	assert.NoError(t, err)
+
+	//未绑定手机号
+	_, _, err = svc.LoginSMS(ctx, "13800138000", "123456")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	_, err = svc.BindPhone(ctx, tom.ID, "12345", "123456")
+	assert.ErrorIs(t, err, ErrPhoneInvalid)
+	_, err = svc.BindPhone(ctx, tom.ID, "13800138000", "000000")
+	assert.ErrorIs(t, err, verifycode.ErrCodeInvalid)
+	u, err := svc.BindPhone(ctx, tom.ID, "+86 138-0013-8000", "123456")
+	assert.NoError(t, err)
+	assert.Equal(t, "13800138000", u.Phone)
+
+	//手机号加密存储
+	var stored string
+	assert.NoError(t, repo.GetDb(ctx).Model(&User{}).Select("phone").Where("id = ?", tom.ID).Scan(&stored).Error)
+	assert.True(t, pii.IsEncrypted(stored))
+
+	_, err = svc.BindPhone(ctx, jerry.ID, "13800138000", "123456")
+	assert.ErrorIs(t, err, ErrPhoneExists)
+
+	_, _, err = svc.LoginSMS(ctx, "13800138000", "000000")
+	assert.ErrorIs(t, err, verifycode.ErrCodeInvalid)
+	u, resp, err := svc.LoginSMS(ctx, "8613800138000", "123456")
+	assert.NoError(t, err)
+	assert.Equal(t, tom.ID, u.ID)
+	assert.IsType(t, &model.LoginResponseByRefreshToekn{}, resp.Token)
+
+	//更换手机号后旧手机号不能再登录，可以被其他账号绑定
+	_, err = svc.BindPhone(ctx, tom.ID, "13900139000", "123456")
+	assert.NoError(t, err)
+	_, _, err = svc.LoginSMS(ctx, "13800138000", "123456")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	_, err = svc.BindPhone(ctx, jerry.ID, "13800138000", "123456")
+	assert.NoError(t, err)
+
+	var events int64
+	assert.NoError(t, repo.GetDb(ctx).Model(&outbox.Event{}).Where("event_type = ?", EventPhoneChanged).Count(&events).Error)
+	assert.Equal(t, int64(3), events)
+
+	noSMS, _, _ := newTestService(t)
+	_, _, err = noSMS.LoginSMS(ctx, "13800138000", "123456")
+	assert.ErrorIs(t, err, ErrSMSDisabled)
+}
+
+//fakeOAuth code对应第三方返回的授权结果，不存在时按state失效处理
+type fakeOAuth struct {
+	oauth.Service
+	auths map[string]*oauth.Authorization
+}
+
+func (f *fakeOAuth) Providers() []string {
+	return []string{"github"}
+}
+
+func (f *fakeOAuth) Exchange(ctx context.Context, provider, code, state, nonce string) (*oauth.Authorization, error) {
+	auth, ok := f.auths[code]
+	if !ok || auth.Identity.Provider != provider {
+		return nil, oauth.ErrStateInvalid
+	}
+	return auth, nil
+}
+
+func TestService_OAuth(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeOAuth{auths: make(map[string]*oauth.Authorization)}
+	identity := func(code string, linkUserId int64, subject, email string, verified bool) {
+		client.auths[code] = &oauth.Authorization{
+			Identity:   &oauth.Identity{Provider: "github", Subject: subject, Email: email, EmailVerified: verified, Name: "gh-" + subject},
+			LinkUserID: linkUserId,
+		}
+	}
+	svc, _, repo := newTestService(t, WithOAuth(client))
+	tom, err := svc.Create(ctx, "tom@test.com", "password1", "tom")
+	assert.NoError(t, err)
+	jerry, err := svc.Create(ctx, "jerry@test.com", "password1", "jerry")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"github"}, svc.OAuthProviders())
+
+	//没有验证过的邮箱不能自动关联
+	identity("unverified", 0, "1", "tom@test.com", false)
+	_, _, err = svc.OAuthLogin(ctx, "github", "unverified", "state", "nonce")
+	assert.ErrorIs(t, err, ErrOAuthEmailUnverified)
+
+	//按验证过的邮箱关联已有账号，之后邮箱变化也能登录
+	identity("tom", 0, "1", "Tom@Test.com", true)
+	u, resp, err := svc.OAuthLogin(ctx, "github", "tom", "state", "nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, tom.ID, u.ID)
+	assert.IsType(t, &model.LoginResponseByRefreshToekn{}, resp.Token)
+	identity("tom2", 0, "1", "other@test.com", false)
+	u, _, err = svc.OAuthLogin(ctx, "github", "tom2", "state", "nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, tom.ID, u.ID)
+
+	//邮箱没有注册时注册新账号，使用随机密码
+	identity("new", 0, "2", "new@test.com", true)
+	u, _, err = svc.OAuthLogin(ctx, "github", "new", "state", "nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, "new@test.com", u.Email)
+	assert.Equal(t, "gh-2", u.Nickname)
+	accounts, err := svc.OAuthAccounts(ctx, u.ID)
+	assert.NoError(t, err)
+	assert.Len(t, accounts, 1)
+
+	//关联的state不能用于登录，登录的state不能用于关联
+	identity("link", jerry.ID, "3", "", false)
+	_, _, err = svc.OAuthLogin(ctx, "github", "link", "state", "nonce")
+	assert.ErrorIs(t, err, oauth.ErrStateInvalid)
+	_, err = svc.OAuthLink(ctx, tom.ID, "github", "link", "state", "nonce")
+	assert.ErrorIs(t, err, oauth.ErrStateInvalid)
+	_, err = svc.OAuthLink(ctx, jerry.ID, "github", "unverified", "state", "nonce")
+	assert.ErrorIs(t, err, oauth.ErrStateInvalid)
+
+	account, err := svc.OAuthLink(ctx, jerry.ID, "github", "link", "state", "nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, "gh-3", account.Name)
+
+	//已关联其他用户的第三方账号，同一个平台关联第二个账号
+	identity("taken", jerry.ID, "1", "", false)
+	_, err = svc.OAuthLink(ctx, jerry.ID, "github", "taken", "state", "nonce")
+	assert.ErrorIs(t, err, ErrOAuthLinked)
+	identity("second", jerry.ID, "4", "", false)
+	_, err = svc.OAuthLink(ctx, jerry.ID, "github", "second", "state", "nonce")
+	assert.ErrorIs(t, err, ErrOAuthLinked)
+
+	assert.NoError(t, svc.OAuthUnlink(ctx, jerry.ID, "github"))
+	assert.ErrorIs(t, svc.OAuthUnlink(ctx, jerry.ID, "github"), ErrOAuthNotLinked)
+	accounts, err = svc.OAuthAccounts(ctx, jerry.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, accounts)
+
+	var events int64
+	assert.NoError(t, repo.GetDb(ctx).Model(&outbox.Event{}).Where("event_type IN ?", []string{EventOAuthLinked, EventOAuthUnlinked}).Count(&events).Error)
+	assert.Equal(t, int64(4), events)
+
+	noOAuth, _, _ := newTestService(t)
+	assert.Empty(t, noOAuth.OAuthProviders())
+	_, _, err = noOAuth.OAuthLogin(ctx, "github", "tom", "state", "nonce")
+	assert.ErrorIs(t, err, ErrOAuthDisabled)
+}
@@ -0,0 +1,50 @@
+//用户账号：邮箱验证码注册、密码登录、短信验证码登录、资料修改、修改和找回密码
+package user
+
+import (
+	"errors"
+	"github/xujialingit/shopping-app/pkg/db"
+	"github/xujialingit/shopping-app/pkg/pkg/pii"
+	"time"
+)
+
+type Status int
+
+const (
+	StatusActive   Status = 1
+	StatusDisabled Status = 2
+)
+
+//User user表，邮箱、手机号加密存储，按邮箱、手机号查询分别使用盲索引 EmailIndex PhoneIndex
+//没有绑定手机号时 PhoneIndex 为NULL，不占用唯一索引
+type User struct {
+	db.BaseModel
+	Email             string    `gorm:"size:255;serializer:pii" json:"email"`
+	EmailIndex        string    `gorm:"size:64;uniqueIndex" json:"-"`
+	Phone             string    `gorm:"size:255;serializer:pii" json:"phone"`
+	PhoneIndex        *string   `gorm:"size:64;uniqueIndex" json:"-"`
+	PasswordHash      string    `gorm:"size:255;not null" json:"-"`
+	Nickname          string    `gorm:"size:64" json:"nickname"`
+	Avatar            string    `gorm:"size:512" json:"avatar"`
+	Status            Status    `gorm:"not null;default:1" json:"status"`
+	PasswordChangedAt time.Time `json:"-"` //修改、重置密码时会注销其他会话，之前签发的access token随会话一起失效
+	//可以访问 /system 下的管理接口，由 user create-admin 命令创建
+	Admin bool `gorm:"not null;default:false" json:"-"`
+}
+
+var (
+	ErrEmailExists     = errors.New("user: 邮箱已被注册")
+	ErrUserNotFound    = errors.New("user: 用户不存在")
+	ErrPassword        = errors.New("user: 账号或密码错误")
+	ErrUserDisabled    = errors.New("user: 账号已被禁用")
+	ErrPasswordTooWeak = errors.New("user: 密码长度必须在8到72之间")
+)
+
+//NormalizeEmail 邮箱不区分大小写，发送和校验验证码、计算盲索引前都需要处理
+func NormalizeEmail(email string) string {
+	return pii.Normalize(email)
+}
+
+func emailIndex(email string) (string, error) {
+	return pii.BlindIndex(NormalizeEmail(email))
+}
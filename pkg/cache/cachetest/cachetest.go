@@ -0,0 +1,21 @@
+//Package cachetest 测试使用的进程内redis，不依赖外部的redis服务
+package cachetest
+
+import (
+	"github/xujialingit/shopping-app/pkg/cache"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+//New 启动进程内的redis并创建 cache.Repo，测试结束时关闭
+//key不会随时间自动过期，需要验证过期时直接使用 miniredis.RunT 并调用 FastForward
+func New(t testing.TB) cache.Repo {
+	mr := miniredis.RunT(t)
+	repo, err := cache.New("test", &cache.RedisConf{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+type startKey struct{}
+
+//loggingHook 把redis命令写入ctx中的logger(logger.FromContext)，模块名为cache
+//只记录命令名和key，不记录value；命令为debug，出错为warn(redis.Nil不算错误)
+//key中可能有刷新token、ticket、邮箱等，只记录最后一个冒号之前的命名空间和整个key的哈希，相同的key哈希相同
+type loggingHook struct{}
+
+var _ redis.Hook = loggingHook{}
+
+func (loggingHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (loggingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	logCommands(ctx, cmd)
+	return nil
+}
+
+func (loggingHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (loggingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	logCommands(ctx, cmds...)
+	return nil
+}
+
+func logCommands(ctx context.Context, cmds ...redis.Cmder) {
+	log := logger.FromContext(ctx).Named("cache")
+	var cost time.Duration
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		cost = time.Since(start)
+	}
+
+	for _, cmd := range cmds {
+		err := cmd.Err()
+		level := zap.DebugLevel
+		if err != nil && !errors.Is(err, redis.Nil) {
+			level = zap.WarnLevel
+		}
+		ce := log.Check(level, "redis")
+		if ce == nil {
+			continue
+		}
+		fields := []zap.Field{
+			zap.String("cmd", cmd.Name()),
+			zap.Duration("cost", cost),
+		}
+		if args := cmd.Args(); len(args) > 1 {
+			fields = append(fields, zap.String("key", maskKey(fmt.Sprint(args[1]))))
+		}
+		if err != nil {
+			fields = append(fields, zap.Error(err))
+		}
+		ce.Write(fields...)
+	}
+}
+
+//maskKey sx:refresh<token> -> sx:#1a2b3c4d
+func maskKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return key[:strings.LastIndexByte(key, ':')+1] + "#" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLoggingHook(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx := logger.NewContext(context.Background(), zap.New(core).With(zap.String(logger.FieldRequestID, "req-1")))
+
+	hook := loggingHook{}
+	ctx, _ = hook.BeforeProcess(ctx, nil)
+
+	miss := redis.NewStringCmd(ctx, "get", "user:1")
+	miss.SetErr(redis.Nil)
+	assert.NoError(t, hook.AfterProcess(ctx, miss))
+
+	failed := redis.NewStatusCmd(ctx, "set", "user:1", "secret-value")
+	failed.SetErr(errors.New("connection refused"))
+	assert.NoError(t, hook.AfterProcess(ctx, failed))
+
+	entries := logs.All()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, zapcore.DebugLevel, entries[0].Level)
+		assert.Equal(t, zapcore.WarnLevel, entries[1].Level)
+		fields := entries[1].ContextMap()
+		assert.Equal(t, "cache", entries[1].LoggerName)
+		assert.Equal(t, "req-1", fields[logger.FieldRequestID])
+		assert.Equal(t, "set", fields["cmd"])
+		assert.Equal(t, maskKey("user:1"), fields["key"])
+		assert.NotContains(t, fields, "secret-value")
+	}
+}
+
+func TestLoggingHook_MaskKey(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx := logger.NewContext(context.Background(), zap.New(core))
+	hook := loggingHook{}
+
+	//刷新token直接拼在前缀后面
+	refreshToken := "3fgeREmEN1x1EV_M4f5lZhIO21tqWo_qI1hPHDMHFSQ"
+	for _, key := range []string{"sx:refresh" + refreshToken, "sx:refresh_used:" + refreshToken} {
+		assert.NoError(t, hook.AfterProcess(ctx, redis.NewStringCmd(ctx, "get", key)))
+	}
+	assert.NoError(t, hook.AfterProcessPipeline(ctx, []redis.Cmder{redis.NewIntCmd(ctx, "del", "sx:refresh"+refreshToken)}))
+
+	entries := logs.All()
+	if assert.Len(t, entries, 3) {
+		for _, entry := range entries {
+			assert.NotContains(t, fmt.Sprint(entry.ContextMap()), refreshToken)
+		}
+		assert.Equal(t, "sx:", entries[0].ContextMap()["key"].(string)[:3])
+		assert.Equal(t, "sx:refresh_used:", entries[1].ContextMap()["key"].(string)[:16])
+		//相同的key可以关联
+		assert.Equal(t, entries[0].ContextMap()["key"], entries[2].ContextMap()["key"])
+		assert.NotEqual(t, entries[0].ContextMap()["key"], entries[1].ContextMap()["key"])
+	}
+}
@@ -73,6 +73,7 @@ func redisConnect(serverName string, cfg *RedisConf) (*redis.Client, error) {
 	client.AddHook(redisotel.NewTracingHook(redisotel.WithAttributes(
 		attribute.String("servername", serverName),
 	)))
+	client.AddHook(loggingHook{})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -95,12 +96,7 @@ func (c cacheRepo) Set(ctx context.Context, key, value string, ttl time.Duration
 }
 
 func (c cacheRepo) Get(ctx context.Context, key string) (string, error) {
-	var err error
-	value, err := c.client.Get(ctx, key).Result()
-	if err != nil {
-		err = err
-	}
-	return value, err
+	return c.client.Get(ctx, key).Result()
 }
 
 func (c cacheRepo) TTL(ctx context.Context, key string) (time.Duration, error) {
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	//请求体超过该大小时不记录
+	_MaxAccessLogBody = 64 << 10
+	//记录到日志中的请求体最大长度
+	_MaxAccessLogBodyPrint = 2 << 10
+)
+
+//accessLog 记录请求日志，请求体按 logger.DefaultMasking() 和路由上 MaskLogKeys 指定的字段脱敏
+//handler中调用 ctx.DisableLog(true) 可以跳过当前请求
+func accessLog() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c := newContext(ctx)
+		defer releaseContext(c)
+
+		start := time.Now()
+		var body []byte
+		if hasLoggableBody(ctx.Request) {
+			body = c.RequestData()
+		}
+
+		ctx.Next()
+
+		if c.getDisableLog() {
+			return
+		}
+		fields := []zap.Field{
+			zap.String("method", ctx.Request.Method),
+			zap.String("path", c.URI()),
+			zap.Int("status", ctx.Writer.Status()),
+			zap.Duration("cost", time.Since(start)),
+		}
+		if len(body) > 0 {
+			masking := logger.DefaultMasking()
+			if keys := c.getMaskKeys(); len(keys) > 0 {
+				masking = masking.WithKeys(logger.Redact, keys...)
+			}
+			masked := masking.MaskBody(ctx.ContentType(), body)
+			fields = append(fields, zap.String("body", truncateBody(masked)))
+		}
+		c.Logger().Named("access").Info("access", fields...)
+	}
+}
+
+//MaskLogKeys 当前路由的access log中额外隐藏的请求体字段，用于 code state 这类不适合加入全局脱敏规则的通用字段名
+//  group.POST("/login/mfa", core.MaskLogKeys("code"), h.loginMFA)
+func MaskLogKeys(keys ...string) HandlerFunc {
+	return func(ctx Context) {
+		ctx.setMaskKeys(keys)
+	}
+}
+
+func hasLoggableBody(req *http.Request) bool {
+	if req.Body == nil || req.ContentLength <= 0 || req.ContentLength > _MaxAccessLogBody {
+		return false
+	}
+	contentType := req.Header.Get("Content-Type")
+	return strings.Contains(contentType, "json") || strings.Contains(contentType, "x-www-form-urlencoded")
+}
+
+func truncateBody(body string) string {
+	if len(body) <= _MaxAccessLogBodyPrint {
+		return body
+	}
+	body = body[:_MaxAccessLogBodyPrint]
+	for !utf8.ValidString(body) {
+		body = body[:len(body)-1]
+	}
+	return body + "..."
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+
+	"github/xujialingit/shopping-app/pkg/pkg/captcha"
+	response2 "github/xujialingit/shopping-app/pkg/pkg/response"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderCaptchaID     = "X-Captcha-Id"
+	HeaderCaptchaAnswer = "X-Captcha-Answer"
+	HeaderCaptchaTicket = "X-Captcha-Ticket"
+)
+
+//WithCaptcha 注册图形验证码接口
+//  GET  /captcha         获取验证码图片
+//  POST /captcha/verify  校验答案，通过后返回一次性的ticket
+//需要验证码的接口使用 RequireCaptcha
+func WithCaptcha(svc captcha.Service) Option {
+	return func(opt *option) {
+		opt.captcha = svc
+	}
+}
+
+type captchaVerifyRequest struct {
+	CaptchaID string `json:"captcha_id" binding:"required"`
+	Answer    string `json:"answer" binding:"required"`
+}
+
+type captchaVerifyResponse struct {
+	Ticket string `json:"ticket"`
+}
+
+func captchaRoutes(group RouteGroup, svc captcha.Service) {
+	group.GET("", func(ctx Context) {
+		c, err := svc.Generate(ctx.SvcContext().Context())
+		if err != nil {
+			ctx.Logger().Error("生成图形验证码失败", zap.Error(err))
+			ctx.AbortWithError(response2.NewErrorAutoMsg(http.StatusInternalServerError, response2.ServerError).WithErr(err))
+			return
+		}
+		ctx.Payload(c)
+	})
+	group.POST("/verify", func(ctx Context) {
+		req := new(captchaVerifyRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.AbortWithError(response2.NewErrorAutoMsg(http.StatusBadRequest, response2.ParamBindError).WithErr(err))
+			return
+		}
+		ticket, err := svc.Exchange(ctx.SvcContext().Context(), req.CaptchaID, req.Answer)
+		if err != nil {
+			abortCaptcha(ctx, err)
+			return
+		}
+		ctx.Payload(&captchaVerifyResponse{Ticket: ticket})
+	})
+}
+
+//RequireCaptcha 要求请求带有效的图形验证码，放在需要人机校验的接口上
+//请求头带 X-Captcha-Ticket，或者直接带 X-Captcha-Id 和 X-Captcha-Answer；验证码和ticket都只能使用一次
+func RequireCaptcha(svc captcha.Service) HandlerFunc {
+	return func(ctx Context) {
+		var err error
+		if ticket := ctx.GetHeader(HeaderCaptchaTicket); ticket != "" {
+			err = svc.Redeem(ctx.SvcContext().Context(), ticket)
+		} else if id := ctx.GetHeader(HeaderCaptchaID); id != "" {
+			err = svc.Verify(ctx.SvcContext().Context(), id, ctx.GetHeader(HeaderCaptchaAnswer))
+		} else {
+			ctx.AbortWithError(response2.NewErrorAutoMsg(http.StatusBadRequest, response2.CaptchaRequired))
+			return
+		}
+		if err != nil {
+			abortCaptcha(ctx, err)
+		}
+	}
+}
+
+func abortCaptcha(ctx Context, err error) {
+	if errors.Is(err, captcha.ErrInvalid) {
+		ctx.AbortWithError(response2.NewErrorAutoMsg(http.StatusBadRequest, response2.CaptchaInvalid))
+		return
+	}
+	ctx.Logger().Error("校验图形验证码失败", zap.Error(err))
+	ctx.AbortWithError(response2.NewErrorAutoMsg(http.StatusInternalServerError, response2.ServerError).WithErr(err))
+}
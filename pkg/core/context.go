@@ -6,7 +6,10 @@ import (
 	stdContext "context"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/spf13/cast"
+	"github/xujialingit/shopping-app/pkg/db"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
 	response2 "github/xujialingit/shopping-app/pkg/pkg/response"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
 	"io/ioutil"
 	"net/url"
 
@@ -24,7 +27,9 @@ const (
 	_Response   = "_response_"
 	_UserId     = "_user_id_"
 	_UserName   = "_user_name_"
+	_Claims     = "_claims_"
 	_DisableLog = "_disable_log_"
+	_MaskKeys   = "_mask_keys_"
 )
 
 var contextPool = &sync.Pool{
@@ -64,6 +69,9 @@ type Context interface {
 	//tag `url:"xxx"`
 	ShouldBindURL(obj interface{}) error
 
+	//ShouldBindPage 反序列化 querystring中的分页参数 page size sort cursor
+	ShouldBindPage() (db.PageQuery, error)
+
 	//Header 获取Header对象
 	Header() http.Header
 
@@ -99,6 +107,9 @@ type Context interface {
 	DisableLog(flag bool)
 	getDisableLog() bool
 
+	setMaskKeys(keys []string)
+	getMaskKeys() []string
+
 	//UserId() 获取 UserID
 	UserID() int64
 	setUserID(userID int64)
@@ -107,6 +118,10 @@ type Context interface {
 	UserName() string
 	setUserName(userName string)
 
+	//Claims 获取鉴权时解析的jwt claims，没有经过 WarpClaimsAuthHandler 时为nil
+	Claims() *token.Claims
+	setClaims(claims *token.Claims)
+
 	//RequestContext 获取Gin的context
 	RequestContext() *gin.Context
 
@@ -131,6 +146,15 @@ func (c *context) ShouldBindURL(obj interface{}) error {
 	return c.ctx.ShouldBindUri(obj)
 }
 
+func (c *context) ShouldBindPage() (db.PageQuery, error) {
+	var query db.PageQuery
+	if err := c.ctx.ShouldBindWith(&query, binding.Query); err != nil {
+		return query, err
+	}
+	query.Normalize()
+	return query, nil
+}
+
 func (c *context) Header() http.Header {
 	header := c.ctx.Request.Header
 	clone := make(http.Header, len(header))
@@ -223,8 +247,10 @@ func (c *context) Logger() *zap.Logger {
 	return logger.(*zap.Logger)
 }
 
-func (c *context) setLogger(logger *zap.Logger) {
-	c.ctx.Set(_Logger, logger)
+//setLogger 同时放入request的context，RequestContext().Request.Context()中也能取到
+func (c *context) setLogger(log *zap.Logger) {
+	c.ctx.Set(_Logger, log)
+	c.ctx.Request = c.ctx.Request.WithContext(logger.NewContext(c.ctx.Request.Context(), log))
 }
 
 func (c *context) DisableLog(flag bool) {
@@ -239,6 +265,18 @@ func (c *context) getDisableLog() bool {
 	return val.(bool)
 }
 
+func (c *context) setMaskKeys(keys []string) {
+	c.ctx.Set(_MaskKeys, keys)
+}
+
+func (c *context) getMaskKeys() []string {
+	val, ok := c.ctx.Get(_MaskKeys)
+	if !ok {
+		return nil
+	}
+	return val.([]string)
+}
+
 func (c context) UserID() int64 {
 	val, ok := c.ctx.Get(_UserId)
 	if !ok {
@@ -249,6 +287,9 @@ func (c context) UserID() int64 {
 
 func (c context) setUserID(userID int64) {
 	c.ctx.Set(_UserId, userID)
+	if log := c.Logger(); log != nil {
+		c.setLogger(log.With(zap.Int64(logger.FieldUserID, userID)))
+	}
 }
 
 func (c context) UserName() string {
@@ -263,6 +304,18 @@ func (c context) setUserName(userName string) {
 	c.ctx.Set(_UserName, userName)
 }
 
+func (c context) Claims() *token.Claims {
+	val, ok := c.ctx.Get(_Claims)
+	if !ok {
+		return nil
+	}
+	return val.(*token.Claims)
+}
+
+func (c context) setClaims(claims *token.Claims) {
+	c.ctx.Set(_Claims, claims)
+}
+
 func (c context) RequestContext() *gin.Context {
 	return c.ctx
 }
@@ -273,6 +326,10 @@ func (c context) SvcContext() SvcContext {
 	//用户信息设置进去
 	ctx = stdContext.WithValue(ctx, _UserId, c.UserID())
 	ctx = stdContext.WithValue(ctx, _UserName, c.UserName())
+	//操作人，db层用来填充审计字段
+	ctx = db.WithActor(ctx, c.UserID())
+	//请求级别的logger，db、cache层通过 logger.FromContext 获取
+	ctx = logger.NewContext(ctx, c.Logger())
 
 	return &svcContext{
 		ctx:    ctx,
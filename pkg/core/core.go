@@ -9,7 +9,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	cors "github.com/rs/cors/wrapper/gin"
 	swaggerFiles "github.com/swaggo/files"
+	"github/xujialingit/shopping-app/pkg/pkg/captcha"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
 	response2 "github/xujialingit/shopping-app/pkg/pkg/response"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 	"net/http"
@@ -30,8 +33,13 @@ type option struct {
 	disablePrometheus bool
 	panicNotify       OnPanicNotify
 	//recordMetrics     RecordMetrics
-	enableCors bool //是否支持跨域
-	enableRate bool
+	enableCors       bool //是否支持跨域
+	enableRate       bool
+	disableAccessLog bool
+	logLevels        *logger.Levels
+	systemAuth       HandlerFunc
+	jwks             func() token.JWKS
+	captcha          captcha.Service
 }
 
 //发生panic时通知用
@@ -79,6 +87,30 @@ func WithEnableCors() Option {
 	}
 }
 
+//WithLogLevel 注册 GET/PUT /system/log/level，运行时查看和修改日志级别
+//auth 为接口的鉴权handler，不能为空
+func WithLogLevel(levels *logger.Levels, auth HandlerFunc) Option {
+	return func(opt *option) {
+		opt.logLevels = levels
+		opt.systemAuth = auth
+	}
+}
+
+//WithJWKS 注册 GET /.well-known/jwks.json，不带serverName前缀，返回非对称签名的公钥
+//jwks 每次请求时调用，key轮换后立即生效
+func WithJWKS(jwks func() token.JWKS) Option {
+	return func(opt *option) {
+		opt.jwks = jwks
+	}
+}
+
+//WithDisableAccessLog 关闭请求日志
+func WithDisableAccessLog() Option {
+	return func(opt *option) {
+		opt.disableAccessLog = true
+	}
+}
+
 func WithEnableRate() Option {
 	return func(opt *option) {
 		opt.enableRate = true
@@ -100,6 +132,41 @@ func WarpAuthHandler(handler func(Context) (userID int64, userName string, err r
 	}
 }
 
+//WarpClaimsAuthHandler 和 WarpAuthHandler 相同，额外保存claims，之后的handler可以通过 ctx.Claims() 获取角色、租户等信息
+func WarpClaimsAuthHandler(handler func(Context) (*token.Claims, response2.Error)) HandlerFunc {
+	return func(ctx Context) {
+		claims, err := handler(ctx)
+
+		if err != nil {
+			ctx.AbortWithError(err)
+			return
+		}
+		ctx.setUserID(claims.UserID)
+		ctx.setUserName(claims.UserName)
+		ctx.setClaims(claims)
+	}
+}
+
+//RequireRoles 需要放在 WarpClaimsAuthHandler 之后，claims中有任意一个角色即可通过，否则返回403
+func RequireRoles(roles ...string) HandlerFunc {
+	return func(ctx Context) {
+		if claims := ctx.Claims(); claims != nil && claims.HasRole(roles...) {
+			return
+		}
+		ctx.AbortWithError(response2.NewErrorAutoMsg(http.StatusForbidden, response2.PermissionDenied))
+	}
+}
+
+//RequireScopes 需要放在 WarpClaimsAuthHandler 之后，claims中必须包含全部scope，否则返回403
+func RequireScopes(scopes ...string) HandlerFunc {
+	return func(ctx Context) {
+		if claims := ctx.Claims(); claims != nil && claims.HasScope(scopes...) {
+			return
+		}
+		ctx.AbortWithError(response2.NewErrorAutoMsg(http.StatusForbidden, response2.PermissionDenied))
+	}
+}
+
 type RouteGroup interface {
 	Group(string, ...HandlerFunc) RouteGroup
 	Use(...HandlerFunc)
@@ -220,6 +287,17 @@ func New(serverName string, logger *zap.Logger, options ...Option) (Engine, erro
 	for _, f := range options {
 		f(opt)
 	}
+	if opt.logLevels != nil && opt.systemAuth == nil {
+		return nil, errors.New("修改日志级别的接口必须设置鉴权")
+	}
+
+	//公钥，验证方按token header中的kid查找
+	if opt.jwks != nil {
+		mux.e.GET("/.well-known/jwks.json", func(ctx *gin.Context) {
+			ctx.Header("Cache-Control", "public, max-age=300")
+			ctx.JSON(http.StatusOK, opt.jwks())
+		})
+	}
 
 	//????
 	if !opt.disablePProf {
@@ -259,13 +337,14 @@ func New(serverName string, logger *zap.Logger, options ...Option) (Engine, erro
 		c := newContext(ctx)
 		defer releaseContext(c)
 
-		//注入logger到ctx
-		c.setLogger(logger)
+		//注入请求级别的logger到ctx，下层通过 logger.FromContext 获取
+		reqLogger := requestLogger(ctx, logger)
+		c.setLogger(reqLogger)
 
 		defer func() {
 			if err := recover(); err != nil {
 				stackInfo := string(debug.Stack())
-				logger.Error("got panic", zap.String("panic", fmt.Sprintf("%+v", err)), zap.String("stack", stackInfo))
+				c.Logger().Error("got panic", zap.String("panic", fmt.Sprintf("%+v", err)), zap.String("stack", stackInfo))
 
 				if notify := opt.panicNotify; notify != nil {
 					//notify 中不能再panic错误
@@ -277,6 +356,11 @@ func New(serverName string, logger *zap.Logger, options ...Option) (Engine, erro
 		ctx.Next()
 	})
 
+	//请求日志
+	if !opt.disableAccessLog {
+		mux.baseGroup.Use(accessLog())
+	}
+
 	//???
 	if opt.enableRate {
 		limiter := rate.NewLimiter(rate.Every(time.Second*1), _MaxBurstSize)
@@ -312,6 +396,21 @@ func New(serverName string, logger *zap.Logger, options ...Option) (Engine, erro
 			}
 			ctx.Payload(resp)
 		})
+
+		//日志级别
+		if opt.logLevels != nil {
+			system.GET("/log/level", opt.systemAuth, func(ctx Context) {
+				ctx.Payload(newLogLevelResponse(opt.logLevels))
+			})
+			system.PUT("/log/level", opt.systemAuth, func(ctx Context) {
+				setLogLevel(ctx, opt.logLevels)
+			})
+		}
+	}
+
+	//图形验证码
+	if opt.captcha != nil {
+		captchaRoutes(mux.Group("/captcha"), opt.captcha)
 	}
 
 	// 注册全局 Telemetry
@@ -0,0 +1,243 @@
+package core
+
+import (
+	stdctx "context"
+	"github/xujialingit/shopping-app/pkg/pkg/captcha"
+	pkgLogger "github/xujialingit/shopping-app/pkg/pkg/logger"
+	"github/xujialingit/shopping-app/pkg/pkg/response"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	mux, err := New("api", zap.New(core), WithDisablePProf(), WithDisableSwagger(), WithDisablePrometheus())
+	assert.NoError(t, err)
+
+	auth := WarpAuthHandler(func(ctx Context) (int64, string, response.Error) {
+		return 7, "tom", nil
+	})
+	mux.Group("/user").POST("/:id", auth, func(ctx Context) {
+		pkgLogger.FromContext(ctx.SvcContext().Context()).Info("handled")
+		ctx.Payload("ok")
+	})
+	mux.Group("/login").POST("/mfa", MaskLogKeys("code"), func(ctx Context) {
+		ctx.Payload("ok")
+	})
+
+	t.Run("沿用请求头中的request id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/1", strings.NewReader(`{"password":"123456"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(HeaderRequestID, "req-1")
+		req.Header.Set(HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		assert.Equal(t, "req-1", w.Header().Get(HeaderRequestID))
+		handled := logs.FilterMessage("handled").All()
+		if assert.Len(t, handled, 1) {
+			fields := handled[0].ContextMap()
+			assert.Equal(t, "req-1", fields[pkgLogger.FieldRequestID])
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", fields[pkgLogger.FieldTraceID])
+			assert.Equal(t, int64(7), fields[pkgLogger.FieldUserID])
+			assert.Equal(t, "/api/user/:id", fields[pkgLogger.FieldRoute])
+		}
+		access := logs.FilterMessage("access").All()
+		if assert.Len(t, access, 1) {
+			assert.Equal(t, "req-1", access[0].ContextMap()[pkgLogger.FieldRequestID])
+			assert.Equal(t, `{"password":"******"}`, access[0].ContextMap()["body"])
+		}
+	})
+
+	t.Run("非法的request id重新生成", func(t *testing.T) {
+		logs.TakeAll()
+		req := httptest.NewRequest(http.MethodPost, "/api/user/1", nil)
+		req.Header.Set(HeaderRequestID, "bad id\n")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		requestID := w.Header().Get(HeaderRequestID)
+		assert.Len(t, requestID, 32)
+		handled := logs.FilterMessage("handled").All()
+		if assert.Len(t, handled, 1) {
+			assert.Equal(t, requestID, handled[0].ContextMap()[pkgLogger.FieldRequestID])
+		}
+	})
+
+	t.Run("路由指定的字段不记录", func(t *testing.T) {
+		body := func(path string) interface{} {
+			logs.TakeAll()
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"mfa_token":"t1","code":"123456"}`))
+			req.Header.Set("Content-Type", "application/json")
+			mux.ServeHTTP(httptest.NewRecorder(), req)
+			access := logs.FilterMessage("access").All()
+			if !assert.Len(t, access, 1) {
+				return nil
+			}
+			return access[0].ContextMap()["body"]
+		}
+
+		assert.Equal(t, `{"code":"******","mfa_token":"******"}`, body("/api/login/mfa"))
+		//其他路由不受影响
+		assert.Equal(t, `{"code":"123456","mfa_token":"******"}`, body("/api/user/1"))
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	jwks := token.JWKS{Keys: []token.JWK{{Kty: "OKP", Kid: "k1", Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: "abc"}}}
+	mux, err := New("api", zap.NewNop(), WithDisablePProf(), WithDisableSwagger(), WithDisablePrometheus(),
+		WithJWKS(func() token.JWKS { return jwks }))
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys":[{"kty":"OKP","kid":"k1","use":"sig","alg":"EdDSA","crv":"Ed25519","x":"abc"}]}`, w.Body.String())
+}
+
+func TestClaimsAuth(t *testing.T) {
+	mux, err := New("api", zap.NewNop(), WithDisablePProf(), WithDisableSwagger(), WithDisablePrometheus())
+	assert.NoError(t, err)
+
+	auth := WarpClaimsAuthHandler(func(ctx Context) (*token.Claims, response.Error) {
+		return &token.Claims{UserID: 7, UserName: "tom", Roles: []string{"merchant"}, Scopes: []string{"order:read"}, TenantID: 1001}, nil
+	})
+	mux.Group("/order").GET("/read", auth, RequireScopes("order:read"), func(ctx Context) {
+		ctx.Payload(ctx.Claims().TenantID)
+	})
+	mux.Group("/admin").GET("", auth, RequireRoles("admin"), func(ctx Context) {
+		ctx.Payload("ok")
+	})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/order/read", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "1001")
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestSetLogLevel(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	levels := pkgLogger.NewLevels(zapcore.ErrorLevel)
+	auth := WarpAuthHandler(func(ctx Context) (int64, string, response.Error) {
+		return 1, "admin", nil
+	})
+	mux, err := New("api", zap.New(core), WithDisablePProf(), WithDisableSwagger(), WithDisablePrometheus(),
+		WithLogLevel(levels, auth))
+	assert.NoError(t, err)
+
+	put := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/system/log/level", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, put(`{"module":"db","level":"DEBUG"}`))
+	changed := logs.FilterMessage("日志级别已修改").All()
+	if assert.Len(t, changed, 1) {
+		assert.Equal(t, zapcore.WarnLevel, changed[0].Level)
+		assert.Equal(t, int64(1), changed[0].ContextMap()["user_id"])
+		assert.Equal(t, "db", changed[0].ContextMap()["module"])
+	}
+	assert.Equal(t, zapcore.DebugLevel, levels.ModuleLevels()["db"])
+
+	//修改记录不受级别过滤，可以设置高于ERROR的级别
+	assert.Equal(t, http.StatusOK, put(`{"level":"FATAL"}`))
+	assert.Equal(t, zapcore.FatalLevel, levels.Level())
+	assert.Len(t, logs.FilterMessage("日志级别已修改").All(), 2)
+
+	assert.Equal(t, http.StatusBadRequest, put(`{"level":"VERBOSE"}`))
+}
+
+//fakeCaptcha 答案固定为1234，ticket固定为ok，都只能使用一次
+type fakeCaptcha struct {
+	captcha.Service
+	used map[string]bool
+}
+
+func (f *fakeCaptcha) Generate(stdctx.Context) (*captcha.Captcha, error) {
+	return &captcha.Captcha{ID: "c1", Image: "data:image/png;base64,", ExpiresIn: 120}, nil
+}
+
+func (f *fakeCaptcha) Verify(_ stdctx.Context, id, answer string) error {
+	if f.used[id] || answer != "1234" {
+		return captcha.ErrInvalid
+	}
+	f.used[id] = true
+	return nil
+}
+
+func (f *fakeCaptcha) Exchange(ctx stdctx.Context, id, answer string) (string, error) {
+	if err := f.Verify(ctx, id, answer); err != nil {
+		return "", err
+	}
+	return "ok", nil
+}
+
+func (f *fakeCaptcha) Redeem(_ stdctx.Context, ticket string) error {
+	if ticket != "ok" || f.used[ticket] {
+		return captcha.ErrInvalid
+	}
+	f.used[ticket] = true
+	return nil
+}
+
+func TestCaptcha(t *testing.T) {
+	svc := &fakeCaptcha{used: make(map[string]bool)}
+	mux, err := New("api", zap.NewNop(), WithDisablePProf(), WithDisableSwagger(), WithDisablePrometheus(), WithDisableAccessLog(),
+		WithCaptcha(svc))
+	assert.NoError(t, err)
+	mux.Group("/coupon").POST("/claim", RequireCaptcha(svc), func(ctx Context) {
+		ctx.Payload("ok")
+	})
+
+	do := func(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodGet, "/api/captcha", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"captcha_id":"c1"`)
+
+	w = do(http.MethodPost, "/api/coupon/claim", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), strconv.Itoa(response.CaptchaRequired))
+
+	//直接带答案
+	w = do(http.MethodPost, "/api/coupon/claim", "", HeaderCaptchaID, "c1", HeaderCaptchaAnswer, "0000")
+	assert.Contains(t, w.Body.String(), strconv.Itoa(response.CaptchaInvalid))
+	w = do(http.MethodPost, "/api/coupon/claim", "", HeaderCaptchaID, "c2", HeaderCaptchaAnswer, "1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = do(http.MethodPost, "/api/coupon/claim", "", HeaderCaptchaID, "c2", HeaderCaptchaAnswer, "1234")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	//先校验换取ticket
+	w = do(http.MethodPost, "/api/captcha/verify", `{"captcha_id":"c3","answer":"1234"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"ticket":"ok"`)
+	w = do(http.MethodPost, "/api/coupon/claim", "", HeaderCaptchaTicket, "ok")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = do(http.MethodPost, "/api/coupon/claim", "", HeaderCaptchaTicket, "ok")
+	assert.Contains(t, w.Body.String(), strconv.Itoa(response.CaptchaInvalid))
+}
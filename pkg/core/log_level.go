@@ -0,0 +1,61 @@
+package core
+
+import (
+	"net/http"
+
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	response2 "github/xujialingit/shopping-app/pkg/pkg/response"
+	"go.uber.org/zap"
+)
+
+type logLevelResponse struct {
+	Level   string            `json:"level"`   //根级别
+	Modules map[string]string `json:"modules"` //单独设置了级别的模块
+}
+
+func newLogLevelResponse(levels *logger.Levels) *logLevelResponse {
+	resp := &logLevelResponse{
+		Level:   levels.Level().String(),
+		Modules: make(map[string]string),
+	}
+	for module, level := range levels.ModuleLevels() {
+		resp.Modules[module] = level.String()
+	}
+	return resp
+}
+
+//setLogLevel module为空时修改根级别；module不为空且level为空时取消模块级别
+func setLogLevel(ctx Context, levels *logger.Levels) {
+	req := &struct {
+		Module string `json:"module"`
+		Level  string `json:"level"`
+	}{}
+	if err := ctx.ShouldBindJSON(req); err != nil {
+		ctx.AbortWithError(response2.NewErrorAutoMsg(http.StatusBadRequest, response2.ParamBindError).WithErr(err))
+		return
+	}
+
+	if req.Module != "" && req.Level == "" {
+		levels.ResetModuleLevel(req.Module)
+	} else {
+		level, err := logger.ParseLevel(req.Level)
+		if err != nil {
+			ctx.AbortWithError(response2.NewErrorAutoMsg(http.StatusBadRequest, response2.ParamBindError).WithErr(err))
+			return
+		}
+		if req.Module == "" {
+			levels.SetLevel(level)
+		} else {
+			levels.SetModuleLevel(req.Module, level)
+		}
+	}
+
+	//修改级别的记录需要保留，不受修改后的级别过滤
+	logger.Unfiltered(ctx.Logger()).Warn("日志级别已修改",
+		zap.Int64("user_id", ctx.UserID()),
+		zap.String("module", req.Module),
+		zap.String("level", req.Level),
+		zap.Stringer("levels", levels),
+	)
+	ctx.Payload(newLogLevelResponse(levels))
+}
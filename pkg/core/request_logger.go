@@ -0,0 +1,63 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderRequestID   = "X-Request-ID"
+	HeaderTraceParent = "traceparent"
+)
+
+//外部传入的request id只接受这些字符，防止日志注入
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+//requestLogger 为每个请求创建子logger，带上 request_id trace_id route client_ip
+//user_id 在鉴权通过后由 setUserID 追加
+func requestLogger(ctx *gin.Context, log *zap.Logger) *zap.Logger {
+	requestID := ctx.GetHeader(HeaderRequestID)
+	if !requestIDPattern.MatchString(requestID) {
+		requestID = newRequestID()
+	}
+	ctx.Header(HeaderRequestID, requestID)
+
+	fields := []zap.Field{
+		zap.String(logger.FieldRequestID, requestID),
+		zap.String(logger.FieldRoute, ctx.FullPath()),
+		zap.String(logger.FieldClientIP, ctx.ClientIP()),
+	}
+	if traceID := traceIDFromRequest(ctx); traceID != "" {
+		fields = append(fields, zap.String(logger.FieldTraceID, traceID))
+	}
+	return log.With(fields...)
+}
+
+//traceIDFromRequest 优先使用opentelemetry的span，其次解析W3C traceparent: 00-{trace-id}-{span-id}-{flags}
+func traceIDFromRequest(ctx *gin.Context) string {
+	if span := trace.SpanContextFromContext(ctx.Request.Context()); span.HasTraceID() {
+		return span.TraceID().String()
+	}
+	parts := strings.Split(ctx.GetHeader(HeaderTraceParent), "-")
+	if len(parts) != 4 {
+		return ""
+	}
+	traceID, err := trace.TraceIDFromHex(parts[1])
+	if err != nil {
+		return ""
+	}
+	return traceID.String()
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
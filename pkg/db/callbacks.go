@@ -0,0 +1,154 @@
+package db
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+//审计字段和乐观锁的gorm callback，对所有包含对应字段的实体生效
+
+const (
+	_FieldCreatedBy = "CreatedBy"
+	_FieldUpdatedBy = "UpdatedBy"
+	_FieldVersion   = "Version"
+
+	_LockVersionKey = "optimistic_lock:version"
+)
+
+func registerCallbacks(db *gorm.DB) error {
+	if err := db.Callback().Create().Before("gorm:create").Register("audit:before_create", beforeCreate); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("audit:before_update", beforeUpdate); err != nil {
+		return err
+	}
+	return db.Callback().Update().After("gorm:update").Register("optimistic_lock:after_update", afterUpdate)
+}
+
+//beforeCreate 填充创建人、修改人，版本号从1开始
+func beforeCreate(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil {
+		return
+	}
+
+	values := map[string]interface{}{
+		_FieldVersion: int64(1),
+	}
+	if actor := ActorFromContext(stmt.Context); actor != 0 {
+		values[_FieldCreatedBy] = actor
+		values[_FieldUpdatedBy] = actor
+	}
+
+	setIfZero := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct {
+			return
+		}
+		for name, value := range values {
+			field := stmt.Schema.LookUpField(name)
+			if field == nil {
+				continue
+			}
+			if _, zero := field.ValueOf(stmt.Context, rv); zero {
+				_ = db.AddError(field.Set(stmt.Context, rv, value))
+			}
+		}
+	}
+
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			setIfZero(stmt.ReflectValue.Index(i))
+		}
+	case reflect.Struct:
+		setIfZero(stmt.ReflectValue)
+	}
+}
+
+//beforeUpdate 填充修改人；实体带有版本号时追加 WHERE version = ? 并把版本号加1
+func beforeUpdate(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil {
+		return
+	}
+
+	if actor := ActorFromContext(stmt.Context); actor != 0 {
+		if field := stmt.Schema.LookUpField(_FieldUpdatedBy); field != nil {
+			stmt.SetColumn(_FieldUpdatedBy, actor, true)
+			selectColumn(stmt, field)
+		}
+	}
+
+	field := stmt.Schema.LookUpField(_FieldVersion)
+	if field == nil || stmt.ReflectValue.Kind() != reflect.Struct {
+		return
+	}
+	value, zero := field.ValueOf(stmt.Context, stmt.ReflectValue)
+	version, ok := value.(int64)
+	if zero || !ok {
+		//没有读取过的实体（如按条件批量更新）不加锁
+		return
+	}
+
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: version},
+	}})
+	stmt.SetColumn(_FieldVersion, version+1, true)
+	selectColumn(stmt, field)
+	db.InstanceSet(_LockVersionKey, version)
+}
+
+//afterUpdate 带版本号的更新没有影响到任何行时，行还存在说明版本号已经变化，不存在（已删除或id错误）时返回 ErrNotFound
+func afterUpdate(db *gorm.DB) {
+	version, ok := db.InstanceGet(_LockVersionKey)
+	if !ok || db.Error != nil || db.RowsAffected > 0 {
+		return
+	}
+
+	//还原实体上的版本号，调用方可以重新读取后再更新
+	if field := db.Statement.Schema.LookUpField(_FieldVersion); field != nil {
+		_ = field.Set(db.Statement.Context, db.Statement.ReflectValue, version)
+	}
+	exists, err := rowExists(db)
+	switch {
+	case err != nil:
+		_ = db.AddError(err)
+	case !exists:
+		_ = db.AddError(ErrNotFound)
+	default:
+		_ = db.AddError(ErrVersionConflict)
+	}
+}
+
+//rowExists 按主键查询实体是否存在，软删除的行视为不存在，和更新使用同一个连接（事务）
+func rowExists(db *gorm.DB) (bool, error) {
+	stmt := db.Statement
+	tx := db.Session(&gorm.Session{NewDB: true, Context: stmt.Context}).Model(reflect.New(stmt.Schema.ModelType).Interface())
+	for _, field := range stmt.Schema.PrimaryFields {
+		value, zero := field.ValueOf(stmt.Context, stmt.ReflectValue)
+		if zero {
+			return false, nil
+		}
+		tx = tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: value})
+	}
+	var count int64
+	err := tx.Count(&count).Error
+	return count > 0, err
+}
+
+//selectColumn 使用Select指定了更新字段时，把callback设置的字段也加进去
+func selectColumn(stmt *gorm.Statement, field *schema.Field) {
+	if len(stmt.Selects) == 0 {
+		return
+	}
+	for _, column := range stmt.Selects {
+		if column == "*" || column == field.Name || column == field.DBName {
+			return
+		}
+	}
+	stmt.Selects = append(stmt.Selects, field.DBName)
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type testProduct struct {
+	ID    int64
+	Name  string
+	Price int
+}
+
+func TestNew_Sqlite(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("内存数据库", func(t *testing.T) {
+		repo, err := New(&DBConfig{Driver: DriverSqlite, Name: ":memory:"})
+		assert.NoError(t, err)
+		defer repo.DbClose()
+
+		assert.NoError(t, repo.GetDb(ctx).AutoMigrate(&testProduct{}))
+		assert.NoError(t, repo.GetDb(ctx).Create(&testProduct{Name: "apple", Price: 10}).Error)
+
+		var got testProduct
+		assert.NoError(t, repo.GetDb(ctx).Where("name = ?", "apple").First(&got).Error)
+		assert.Equal(t, 10, got.Price)
+	})
+
+	t.Run("文件数据库", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "test.db")
+		repo, err := New(&DBConfig{Driver: DriverSqlite, Name: file, MaxOpenConn: 2, MaxIdleConn: 2})
+		assert.NoError(t, err)
+
+		assert.NoError(t, repo.GetDb(ctx).AutoMigrate(&testProduct{}))
+		assert.NoError(t, repo.GetDb(ctx).Create(&testProduct{Name: "pear", Price: 5}).Error)
+		assert.NoError(t, repo.DbClose())
+
+		//重新打开后数据仍在
+		repo, err = New(&DBConfig{Driver: DriverSqlite, Name: file})
+		assert.NoError(t, err)
+		defer repo.DbClose()
+
+		var count int64
+		assert.NoError(t, repo.GetDb(ctx).Model(&testProduct{}).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("name中带参数", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "data", "test.db")
+		repo, err := New(&DBConfig{Driver: DriverSqlite, Name: file + "?_pragma=journal_mode(WAL)"})
+		assert.NoError(t, err)
+		defer repo.DbClose()
+
+		var mode string
+		assert.NoError(t, repo.GetDb(ctx).Raw("PRAGMA journal_mode").Scan(&mode).Error)
+		assert.Equal(t, "wal", mode)
+		//默认参数仍然生效
+		var foreignKeys int
+		assert.NoError(t, repo.GetDb(ctx).Raw("PRAGMA foreign_keys").Scan(&foreignKeys).Error)
+		assert.Equal(t, 1, foreignKeys)
+	})
+
+	t.Run("不支持的驱动", func(t *testing.T) {
+		_, err := New(&DBConfig{Driver: "oracle"})
+		assert.Error(t, err)
+	})
+}
+
+func TestZapLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx := logger.NewContext(context.Background(), zap.New(core).With(zap.String(logger.FieldRequestID, "req-1")))
+
+	repo, err := New(&DBConfig{Driver: DriverSqlite})
+	assert.NoError(t, err)
+	defer repo.DbClose()
+
+	assert.NoError(t, repo.GetDb(ctx).AutoMigrate(&testProduct{}))
+	assert.Error(t, repo.GetDb(ctx).Exec("SELECT * FROM not_exist").Error)
+
+	errors := logs.FilterMessage("sql error").All()
+	if assert.Len(t, errors, 1) {
+		assert.Equal(t, "db", errors[0].LoggerName)
+		assert.Equal(t, "req-1", errors[0].ContextMap()[logger.FieldRequestID])
+		assert.Equal(t, "SELECT * FROM not_exist", errors[0].ContextMap()["sql"])
+	}
+	assert.NotZero(t, logs.FilterMessage("sql").Len())
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//Filter 列表查询的过滤条件构造器
+//列名会被校验并由gorm转义，值全部走参数绑定，可以直接使用请求参数
+//  db.NewFilter().Eq("status", 1).Like("name", keyword).In("category_id", ids)
+
+type Operator string
+
+const (
+	OpEq      Operator = "eq"
+	OpNe      Operator = "ne"
+	OpGt      Operator = "gt"
+	OpGte     Operator = "gte"
+	OpLt      Operator = "lt"
+	OpLte     Operator = "lte"
+	OpLike    Operator = "like"
+	OpIn      Operator = "in"
+	OpIsNull  Operator = "is_null"
+	OpNotNull Operator = "not_null"
+)
+
+var ErrInvalidColumn = errors.New("无效的字段名")
+
+var columnRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+type condition struct {
+	column string
+	op     Operator
+	value  interface{}
+}
+
+type Filter struct {
+	conds []condition
+}
+
+func NewFilter() *Filter {
+	return &Filter{}
+}
+
+func (f *Filter) add(column string, op Operator, value interface{}) *Filter {
+	f.conds = append(f.conds, condition{column: column, op: op, value: value})
+	return f
+}
+
+func (f *Filter) Eq(column string, value interface{}) *Filter {
+	return f.add(column, OpEq, value)
+}
+
+func (f *Filter) Ne(column string, value interface{}) *Filter {
+	return f.add(column, OpNe, value)
+}
+
+func (f *Filter) Gt(column string, value interface{}) *Filter {
+	return f.add(column, OpGt, value)
+}
+
+func (f *Filter) Gte(column string, value interface{}) *Filter {
+	return f.add(column, OpGte, value)
+}
+
+func (f *Filter) Lt(column string, value interface{}) *Filter {
+	return f.add(column, OpLt, value)
+}
+
+func (f *Filter) Lte(column string, value interface{}) *Filter {
+	return f.add(column, OpLte, value)
+}
+
+//Like 模糊匹配，value两侧自动加 %
+func (f *Filter) Like(column string, value string) *Filter {
+	return f.add(column, OpLike, "%"+value+"%")
+}
+
+//In 可以传多个值，也可以只传一个切片 In("category_id", ids)
+func (f *Filter) In(column string, values ...interface{}) *Filter {
+	if len(values) == 1 {
+		values = flatten(values[0])
+	}
+	return f.add(column, OpIn, values)
+}
+
+//flatten 切片和数组展开为多个值，[]byte 作为一个值
+func flatten(value interface{}) []interface{} {
+	rv := reflect.ValueOf(value)
+	if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return []interface{}{value}
+	}
+	values := make([]interface{}, rv.Len())
+	for i := range values {
+		values[i] = rv.Index(i).Interface()
+	}
+	return values
+}
+
+func (f *Filter) IsNull(column string) *Filter {
+	return f.add(column, OpIsNull, nil)
+}
+
+func (f *Filter) NotNull(column string) *Filter {
+	return f.add(column, OpNotNull, nil)
+}
+
+//When cond为true时才添加条件，方便处理可选的查询参数
+func (f *Filter) When(cond bool, fn func(f *Filter)) *Filter {
+	if cond {
+		fn(f)
+	}
+	return f
+}
+
+//Scope 转换为gorm的Scope
+func (f *Filter) Scope() func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if f == nil || len(f.conds) == 0 {
+			return tx
+		}
+		exprs := make([]clause.Expression, 0, len(f.conds))
+		for _, c := range f.conds {
+			expr, err := c.expression()
+			if err != nil {
+				_ = tx.AddError(err)
+				return tx
+			}
+			exprs = append(exprs, expr)
+		}
+		return tx.Clauses(clause.Where{Exprs: exprs})
+	}
+}
+
+func (c condition) expression() (clause.Expression, error) {
+	column, err := toColumn(c.column)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.op {
+	case OpEq:
+		return clause.Eq{Column: column, Value: c.value}, nil
+	case OpNe:
+		return clause.Neq{Column: column, Value: c.value}, nil
+	case OpGt:
+		return clause.Gt{Column: column, Value: c.value}, nil
+	case OpGte:
+		return clause.Gte{Column: column, Value: c.value}, nil
+	case OpLt:
+		return clause.Lt{Column: column, Value: c.value}, nil
+	case OpLte:
+		return clause.Lte{Column: column, Value: c.value}, nil
+	case OpLike:
+		return clause.Like{Column: column, Value: c.value}, nil
+	case OpIn:
+		return clause.IN{Column: column, Values: c.value.([]interface{})}, nil
+	case OpIsNull:
+		return clause.Eq{Column: column, Value: nil}, nil
+	case OpNotNull:
+		return clause.Neq{Column: column, Value: nil}, nil
+	default:
+		return nil, fmt.Errorf("不支持的查询操作:%s", c.op)
+	}
+}
+
+//toColumn 校验列名，支持 table.column 形式
+func toColumn(name string) (clause.Column, error) {
+	if !columnRegexp.MatchString(name) {
+		return clause.Column{}, fmt.Errorf("%w:%s", ErrInvalidColumn, name)
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return clause.Column{Table: name[:i], Name: name[i+1:]}, nil
+	}
+	return clause.Column{Name: name}, nil
+}
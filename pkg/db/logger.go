@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+//慢查询阈值
+const _SlowThreshold = 200 * time.Millisecond
+
+//zapLogger 把gorm的日志写入ctx中的logger(logger.FromContext)，模块名为db
+//级别由zap控制，gorm的LogMode不生效；sql为debug，慢查询为warn，出错为error
+type zapLogger struct {
+	slowThreshold time.Duration
+}
+
+var _ gormLogger.Interface = (*zapLogger)(nil)
+
+func newZapLogger() *zapLogger {
+	return &zapLogger{slowThreshold: _SlowThreshold}
+}
+
+func (l *zapLogger) log(ctx context.Context) *zap.Logger {
+	return logger.FromContext(ctx).Named("db")
+}
+
+func (l *zapLogger) LogMode(gormLogger.LogLevel) gormLogger.Interface {
+	return l
+}
+
+func (l *zapLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	l.log(ctx).Info(fmt.Sprintf(msg, args...), zap.String("file", utils.FileWithLineNum()))
+}
+
+func (l *zapLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	l.log(ctx).Warn(fmt.Sprintf(msg, args...), zap.String("file", utils.FileWithLineNum()))
+}
+
+func (l *zapLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	l.log(ctx).Error(fmt.Sprintf(msg, args...), zap.String("file", utils.FileWithLineNum()))
+}
+
+func (l *zapLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	log := l.log(ctx)
+	cost := time.Since(begin)
+
+	var ce *zapcore.CheckedEntry
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		ce = log.Check(zap.ErrorLevel, "sql error")
+	case l.slowThreshold > 0 && cost > l.slowThreshold:
+		ce = log.Check(zap.WarnLevel, "slow sql")
+	default:
+		ce = log.Check(zap.DebugLevel, "sql")
+	}
+	if ce == nil {
+		return
+	}
+
+	sql, rows := fc()
+	fields := []zap.Field{
+		zap.String("sql", sql),
+		zap.Int64("rows", rows),
+		zap.Duration("cost", cost),
+		zap.String("file", utils.FileWithLineNum()),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	ce.Write(fields...)
+}
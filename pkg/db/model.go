@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github/xujialingit/shopping-app/pkg/pkg/response"
+	"gorm.io/gorm"
+)
+
+//BaseModel 实体公用字段，嵌入到实体中使用
+//  type Product struct {
+//      db.BaseModel
+//      Name string
+//  }
+//CreatedBy/UpdatedBy 由callback从context中的操作人自动填充，见 WithActor
+//Version 为乐观锁版本号，通过带版本号的实体更新时会追加 WHERE version = ?，
+//没有更新到数据时返回 ErrVersionConflict
+type BaseModel struct {
+	ID        int64          `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	CreatedBy int64          `json:"created_by"`
+	UpdatedBy int64          `json:"updated_by"`
+	Version   int64          `gorm:"not null;default:1" json:"version"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+//ErrVersionConflict 乐观锁冲突，数据在读取后已被其他请求修改
+var ErrVersionConflict = response.NewErrorAutoMsg(http.StatusConflict, response.VersionConflict)
+
+type actorKey struct{}
+
+//WithActor 把当前操作人放入context，core.Context.SvcContext() 中会自动设置
+func WithActor(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+//ActorFromContext 获取当前操作人，没有时返回0
+func ActorFromContext(ctx context.Context) int64 {
+	if ctx == nil {
+		return 0
+	}
+	userID, _ := ctx.Value(actorKey{}).(int64)
+	return userID
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testStock struct {
+	BaseModel
+	Sku      string
+	Quantity int
+}
+
+func TestBaseModel_AuditAndVersion(t *testing.T) {
+	repo, err := New(&DBConfig{Driver: DriverSqlite})
+	assert.NoError(t, err)
+	defer repo.DbClose()
+	assert.NoError(t, repo.GetDb(context.Background()).AutoMigrate(&testStock{}))
+
+	stocks := NewRepository[testStock](repo)
+	creator := WithActor(context.Background(), 1)
+	editor := WithActor(context.Background(), 2)
+
+	stock := &testStock{Sku: "sku-1", Quantity: 10}
+	assert.NoError(t, stocks.Create(creator, stock))
+	assert.Equal(t, int64(1), stock.CreatedBy)
+	assert.Equal(t, int64(1), stock.UpdatedBy)
+	assert.Equal(t, int64(1), stock.Version)
+
+	//两个后台用户同时读取了同一条库存
+	first, err := stocks.Get(editor, stock.ID)
+	assert.NoError(t, err)
+	second, err := stocks.Get(editor, stock.ID)
+	assert.NoError(t, err)
+
+	first.Quantity = 8
+	assert.NoError(t, stocks.Update(editor, first, "quantity"))
+	assert.Equal(t, int64(2), first.Version)
+
+	second.Quantity = 0
+	err = stocks.Update(editor, second, "quantity")
+	assert.ErrorIs(t, err, ErrVersionConflict)
+	assert.Equal(t, int64(1), second.Version)
+
+	got, err := stocks.Get(context.Background(), stock.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, got.Quantity)
+	assert.Equal(t, int64(2), got.Version)
+	assert.Equal(t, int64(1), got.CreatedBy)
+	assert.Equal(t, int64(2), got.UpdatedBy)
+
+	//没有版本号的批量更新不加锁
+	err = stocks.DB(editor).Where("sku = ?", "sku-1").Update("quantity", 5).Error
+	assert.NoError(t, err)
+
+	//id错误或已经删除时不是版本冲突
+	missing := *got
+	missing.ID = 999
+	assert.ErrorIs(t, stocks.Update(editor, &missing, "quantity"), ErrNotFound)
+	assert.NoError(t, stocks.Delete(editor, stock.ID))
+	err = stocks.Update(editor, got, "quantity")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NotErrorIs(t, err, ErrVersionConflict)
+}
@@ -11,6 +11,12 @@ import (
 )
 
 //gorm--->连接mysql封装的一层
+//通过DBConfig.Driver可以切换为sqlite，本地开发和测试不再依赖远程mysql
+
+const (
+	DriverMysql  = "mysql"
+	DriverSqlite = "sqlite"
+)
 
 var _ Repo = (*dbRepor)(nil)
 
@@ -29,13 +35,18 @@ func New(cfg *DBConfig) (Repo, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := registerCallbacks(db); err != nil {
+		return nil, err
+	}
 	return &dbRepor{
 		Db: db,
 	}, nil
 }
 
 //mysql配置
+//Driver为空时默认mysql；为sqlite时Name为数据库文件路径，Name为空或":memory:"时使用内存数据库
 type DBConfig struct {
+	Driver          string
 	User            string
 	Pass            string
 	Addr            string
@@ -61,6 +72,28 @@ func (d *dbRepor) DbClose() error {
 }
 
 func dbConnect(cfg *DBConfig) (*gorm.DB, error) {
+	switch cfg.Driver {
+	case "", DriverMysql:
+		return mysqlConnect(cfg)
+	case DriverSqlite:
+		return sqliteConnect(cfg)
+	default:
+		return nil, fmt.Errorf("不支持的数据库驱动:%s", cfg.Driver)
+	}
+}
+
+func gormConfig() *gorm.Config {
+	return &gorm.Config{
+		//表名使用单数形式
+		NamingStrategy: schema.NamingStrategy{
+			SingularTable: true,
+		},
+		//日志写入请求的logger
+		Logger: newZapLogger(),
+	}
+}
+
+func mysqlConnect(cfg *DBConfig) (*gorm.DB, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=%t&loc=%s",
 		cfg.User,
 		cfg.Pass,
@@ -70,12 +103,7 @@ func dbConnect(cfg *DBConfig) (*gorm.DB, error) {
 		"Local",
 	)
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		//??
-		NamingStrategy: schema.NamingStrategy{
-			SingularTable: true,
-		},
-	})
+	db, err := gorm.Open(mysql.Open(dsn), gormConfig())
 
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("连接数据库:%s 失败！", cfg.Name))
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github/xujialingit/shopping-app/pkg/pkg/response"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var (
+	ErrInvalidSortField = errors.New("不支持的排序字段")
+	ErrInvalidCursor    = errors.New("无效的分页游标")
+)
+
+//PageQuery 分页查询参数，通过 core.Context.ShouldBindPage 从querystring中获取
+//sort 格式为逗号分隔的字段，字段前加 - 表示倒序：sort=-created_at,id
+type PageQuery struct {
+	Page   int    `form:"page"`
+	Size   int    `form:"size"`
+	Sort   string `form:"sort"`
+	Cursor string `form:"cursor"`
+}
+
+//Normalize 修正非法的页码和每页条数
+func (p *PageQuery) Normalize() {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Size <= 0 {
+		p.Size = DefaultPageSize
+	}
+	if p.Size > MaxPageSize {
+		p.Size = MaxPageSize
+	}
+}
+
+func (p PageQuery) Offset() int {
+	return (p.Page - 1) * p.Size
+}
+
+//Sorts 解析sort参数
+func (p PageQuery) Sorts() []Sort {
+	return ParseSort(p.Sort)
+}
+
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+func ParseSort(s string) []Sort {
+	sorts := make([]Sort, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		sort := Sort{Field: item}
+		if strings.HasPrefix(item, "-") {
+			sort = Sort{Field: item[1:], Desc: true}
+		} else if strings.HasPrefix(item, "+") {
+			sort.Field = item[1:]
+		}
+		sorts = append(sorts, sort)
+	}
+	return sorts
+}
+
+//Page 列表查询结果
+//offset分页时Total为总条数；游标分页时NextCursor为下一页游标，为空表示没有更多数据
+type Page[T any] struct {
+	List       []T
+	Total      int64
+	Page       int
+	Size       int
+	NextCursor string
+}
+
+//Payload 转换为统一的分页返回结构
+func (p *Page[T]) Payload() *response.PageData {
+	if p.Page == 0 {
+		return response.NewCursorPageData(p.List, p.NextCursor, p.Size)
+	}
+	return response.NewPageData(p.List, p.Total, p.Page, p.Size)
+}
+
+//cursor 游标分页时记录上一页最后一条数据的排序字段和主键
+type cursor struct {
+	Value json.RawMessage `json:"v"`
+	ID    json.RawMessage `json:"id"`
+}
+
+func encodeCursor(value, id interface{}) (string, error) {
+	v, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	pk, err := json.Marshal(id)
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(cursor{Value: v, ID: pk})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w:%v", ErrInvalidCursor, err)
+	}
+	c := new(cursor)
+	if err := json.Unmarshal(raw, c); err != nil || c.ID == nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
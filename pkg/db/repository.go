@@ -0,0 +1,302 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+//Repository 基于Repo封装的通用CRUD
+//实体中包含 gorm.DeletedAt 字段时Delete为软删除，查询时自动过滤已删除的数据
+//  type ProductRepo struct {
+//      *db.Repository[Product]
+//  }
+//  repo := db.NewRepository[Product](dbRepo, db.WithSortFields("id", "price", "created_at"))
+
+var ErrNotFound = gorm.ErrRecordNotFound
+
+type RepositoryOption func(*repositoryOption)
+
+type repositoryOption struct {
+	sortable    map[string]string
+	defaultSort []Sort
+}
+
+//WithSortFields 允许排序的字段，参数名与列名相同
+func WithSortFields(fields ...string) RepositoryOption {
+	return func(opt *repositoryOption) {
+		for _, field := range fields {
+			opt.sortable[field] = field
+		}
+	}
+}
+
+//WithSortAlias 允许排序的字段，参数名与列名不同时使用
+func WithSortAlias(field, column string) RepositoryOption {
+	return func(opt *repositoryOption) {
+		opt.sortable[field] = column
+	}
+}
+
+//WithDefaultSort 没有传sort参数时的排序，格式与PageQuery.Sort相同，不设置时按主键倒序
+func WithDefaultSort(sort string) RepositoryOption {
+	return func(opt *repositoryOption) {
+		opt.defaultSort = ParseSort(sort)
+	}
+}
+
+type Repository[T any] struct {
+	repo Repo
+	opt  *repositoryOption
+}
+
+func NewRepository[T any](repo Repo, options ...RepositoryOption) *Repository[T] {
+	opt := &repositoryOption{
+		sortable: make(map[string]string),
+	}
+	for _, f := range options {
+		f(opt)
+	}
+	return &Repository[T]{
+		repo: repo,
+		opt:  opt,
+	}
+}
+
+//DB 返回以T为Model的gorm.DB，用于Repository没有覆盖的查询
+func (r *Repository[T]) DB(ctx context.Context) *gorm.DB {
+	return r.repo.GetDb(ctx).Model(new(T))
+}
+
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	return r.repo.GetDb(ctx).Create(entity).Error
+}
+
+//Get 通过主键查询，不存在时返回ErrNotFound
+func (r *Repository[T]) Get(ctx context.Context, id interface{}) (*T, error) {
+	entity := new(T)
+	err := r.repo.GetDb(ctx).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Take(entity).Error
+	if err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+//Update 通过entity的主键更新，fields为需要更新的字段（可以更新零值）
+//fields为空时只更新非零值字段
+func (r *Repository[T]) Update(ctx context.Context, entity *T, fields ...string) error {
+	tx := r.repo.GetDb(ctx).Model(entity)
+	if len(fields) > 0 {
+		tx = tx.Select(fields)
+	}
+	return tx.Updates(entity).Error
+}
+
+//Delete 通过主键删除，不存在时返回ErrNotFound
+func (r *Repository[T]) Delete(ctx context.Context, id interface{}) error {
+	tx := r.repo.GetDb(ctx).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Delete(new(T))
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//List offset分页查询，返回总条数
+func (r *Repository[T]) List(ctx context.Context, filter *Filter, query PageQuery) (*Page[T], error) {
+	query.Normalize()
+	sch, err := r.schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := r.orderBy(sch, query.Sorts())
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	if err := r.DB(ctx).Scopes(filter.Scope()).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	list := make([]T, 0, query.Size)
+	if total > int64(query.Offset()) {
+		err = r.DB(ctx).Scopes(filter.Scope()).
+			Clauses(orders).
+			Offset(query.Offset()).
+			Limit(query.Size).
+			Find(&list).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Page[T]{
+		List:  list,
+		Total: total,
+		Page:  query.Page,
+		Size:  query.Size,
+	}, nil
+}
+
+//ListByCursor 游标(keyset)分页查询，适合数据量大或翻页很深的场景
+//只使用第一个排序字段，并以主键作为相同值时的排序依据；不返回总条数
+func (r *Repository[T]) ListByCursor(ctx context.Context, filter *Filter, query PageQuery) (*Page[T], error) {
+	query.Normalize()
+	sch, err := r.schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pk := sch.PrioritizedPrimaryField
+	if pk == nil {
+		return nil, fmt.Errorf("%s 没有主键，不能使用游标分页", sch.Name)
+	}
+
+	sorts := query.Sorts()
+	if len(sorts) == 0 {
+		sorts = r.defaultSort()
+	}
+	sortField := pk
+	desc := sorts[0].Desc
+	if sorts[0].Field != "" && sorts[0].Field != pk.DBName {
+		column, ok := r.opt.sortable[sorts[0].Field]
+		if !ok {
+			return nil, fmt.Errorf("%w:%s", ErrInvalidSortField, sorts[0].Field)
+		}
+		if sortField = sch.LookUpField(column); sortField == nil {
+			return nil, fmt.Errorf("%w:%s", ErrInvalidSortField, sorts[0].Field)
+		}
+	}
+
+	tx := r.DB(ctx).Scopes(filter.Scope())
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		id, err := decodeValue(pk, c.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		pkColumn := clause.Column{Table: clause.CurrentTable, Name: pk.DBName}
+		if sortField == pk {
+			tx = tx.Where(keysetCompare(pkColumn, id, desc))
+		} else {
+			value, err := decodeValue(sortField, c.Value)
+			if err != nil {
+				return nil, err
+			}
+			column := clause.Column{Table: clause.CurrentTable, Name: sortField.DBName}
+			//(column > value) OR (column = value AND id > lastId)
+			tx = tx.Where(clause.Or(
+				keysetCompare(column, value, desc),
+				clause.And(clause.Eq{Column: column, Value: value}, keysetCompare(pkColumn, id, desc)),
+			))
+		}
+	}
+
+	orders := clause.OrderBy{Columns: []clause.OrderByColumn{{
+		Column: clause.Column{Table: clause.CurrentTable, Name: sortField.DBName},
+		Desc:   desc,
+	}}}
+	if sortField != pk {
+		orders.Columns = append(orders.Columns, clause.OrderByColumn{
+			Column: clause.Column{Table: clause.CurrentTable, Name: pk.DBName},
+			Desc:   desc,
+		})
+	}
+
+	//多查一条用来判断是否还有下一页
+	list := make([]T, 0, query.Size+1)
+	if err := tx.Clauses(orders).Limit(query.Size + 1).Find(&list).Error; err != nil {
+		return nil, err
+	}
+
+	page := &Page[T]{Size: query.Size}
+	if len(list) > query.Size {
+		list = list[:query.Size]
+		last := reflect.ValueOf(&list[len(list)-1]).Elem()
+		value, _ := sortField.ValueOf(ctx, last)
+		id, _ := pk.ValueOf(ctx, last)
+		if page.NextCursor, err = encodeCursor(value, id); err != nil {
+			return nil, err
+		}
+	}
+	page.List = list
+	return page, nil
+}
+
+func (r *Repository[T]) schema(ctx context.Context) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: r.repo.GetDb(ctx)}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+func (r *Repository[T]) defaultSort() []Sort {
+	if len(r.opt.defaultSort) > 0 {
+		return r.opt.defaultSort
+	}
+	return []Sort{{Field: "", Desc: true}}
+}
+
+//orderBy 校验排序字段，始终在最后追加主键排序保证分页结果稳定
+func (r *Repository[T]) orderBy(sch *schema.Schema, sorts []Sort) (clause.OrderBy, error) {
+	if len(sorts) == 0 {
+		sorts = r.defaultSort()
+	}
+
+	pk := ""
+	if sch.PrioritizedPrimaryField != nil {
+		pk = sch.PrioritizedPrimaryField.DBName
+	}
+
+	orders := clause.OrderBy{}
+	hasPk := false
+	for _, sort := range sorts {
+		column := sort.Field
+		if column == "" || column == pk {
+			column = pk
+			hasPk = true
+		} else if column = r.opt.sortable[sort.Field]; column == "" {
+			return orders, fmt.Errorf("%w:%s", ErrInvalidSortField, sort.Field)
+		}
+		if column == "" {
+			continue
+		}
+		orders.Columns = append(orders.Columns, clause.OrderByColumn{
+			Column: clause.Column{Table: clause.CurrentTable, Name: column},
+			Desc:   sort.Desc,
+		})
+	}
+	if !hasPk && pk != "" {
+		orders.Columns = append(orders.Columns, clause.OrderByColumn{
+			Column: clause.Column{Table: clause.CurrentTable, Name: pk},
+		})
+	}
+	return orders, nil
+}
+
+func keysetCompare(column clause.Column, value interface{}, desc bool) clause.Expression {
+	if desc {
+		return clause.Lt{Column: column, Value: value}
+	}
+	return clause.Gt{Column: column, Value: value}
+}
+
+func decodeValue(field *schema.Field, raw json.RawMessage) (interface{}, error) {
+	value := reflect.New(field.FieldType)
+	if err := json.Unmarshal(raw, value.Interface()); err != nil {
+		return nil, fmt.Errorf("%w:%v", ErrInvalidCursor, err)
+	}
+	return value.Elem().Interface(), nil
+}
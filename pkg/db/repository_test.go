@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type testGoods struct {
+	ID        int64
+	Name      string
+	Price     int
+	CreatedAt time.Time
+	DeletedAt gorm.DeletedAt
+}
+
+func newTestRepository(t *testing.T) *Repository[testGoods] {
+	repo, err := New(&DBConfig{Driver: DriverSqlite})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = repo.DbClose() })
+	assert.NoError(t, repo.GetDb(context.Background()).AutoMigrate(&testGoods{}))
+
+	return NewRepository[testGoods](repo, WithSortFields("price"), WithSortAlias("time", "created_at"))
+}
+
+func TestRepository_CRUD(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+
+	goods := &testGoods{Name: "apple", Price: 10}
+	assert.NoError(t, repo.Create(ctx, goods))
+	assert.NotZero(t, goods.ID)
+
+	//更新零值字段
+	goods.Price = 0
+	goods.Name = "ignored"
+	assert.NoError(t, repo.Update(ctx, goods, "price"))
+
+	got, err := repo.Get(ctx, goods.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, got.Price)
+	assert.Equal(t, "apple", got.Name)
+
+	//软删除后查询不到，但数据还在
+	assert.NoError(t, repo.Delete(ctx, goods.ID))
+	_, err = repo.Get(ctx, goods.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, repo.Delete(ctx, goods.ID), ErrNotFound)
+
+	var count int64
+	assert.NoError(t, repo.DB(ctx).Unscoped().Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestRepository_List(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	for i := 1; i <= 25; i++ {
+		assert.NoError(t, repo.Create(ctx, &testGoods{Name: "goods", Price: i % 5}))
+	}
+
+	t.Run("offset分页", func(t *testing.T) {
+		page, err := repo.List(ctx, NewFilter().Gte("price", 3), PageQuery{Page: 2, Size: 4, Sort: "-price"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(10), page.Total)
+		assert.Len(t, page.List, 4)
+		//price倒序，相同price按id正序
+		assert.Equal(t, 4, page.List[0].Price)
+		assert.Equal(t, 3, page.List[1].Price)
+		assert.Less(t, page.List[1].ID, page.List[2].ID)
+	})
+
+	t.Run("空的offset分页返回total", func(t *testing.T) {
+		page, err := repo.List(ctx, NewFilter().Gt("price", 100), PageQuery{Page: 1, Size: 4})
+		assert.NoError(t, err)
+		raw, err := json.Marshal(page.Payload())
+		assert.NoError(t, err)
+		assert.Contains(t, string(raw), `"total":0`)
+
+		cursorPage, err := repo.ListByCursor(ctx, nil, PageQuery{Size: 4})
+		assert.NoError(t, err)
+		raw, err = json.Marshal(cursorPage.Payload())
+		assert.NoError(t, err)
+		assert.NotContains(t, string(raw), `"total"`)
+	})
+
+	t.Run("In传入切片", func(t *testing.T) {
+		ids := []int64{1, 2, 3}
+		page, err := repo.List(ctx, NewFilter().In("id", ids), PageQuery{Size: 10})
+		assert.NoError(t, err)
+		assert.Len(t, page.List, 3)
+
+		page, err = repo.List(ctx, NewFilter().In("id", []int64{}), PageQuery{Size: 10})
+		assert.NoError(t, err)
+		assert.Empty(t, page.List)
+	})
+
+	t.Run("非法排序字段", func(t *testing.T) {
+		_, err := repo.List(ctx, nil, PageQuery{Sort: "name"})
+		assert.ErrorIs(t, err, ErrInvalidSortField)
+	})
+
+	t.Run("非法过滤字段", func(t *testing.T) {
+		_, err := repo.List(ctx, NewFilter().Eq("price; drop table", 1), PageQuery{})
+		assert.ErrorIs(t, err, ErrInvalidColumn)
+	})
+
+	t.Run("游标分页", func(t *testing.T) {
+		query := PageQuery{Size: 4, Sort: "price"}
+		seen := make(map[int64]bool)
+		lastPrice := -1
+		for pages := 0; ; pages++ {
+			page, err := repo.ListByCursor(ctx, NewFilter().In("price", 1, 2), query)
+			assert.NoError(t, err)
+			for _, goods := range page.List {
+				assert.False(t, seen[goods.ID])
+				assert.GreaterOrEqual(t, goods.Price, lastPrice)
+				seen[goods.ID] = true
+				lastPrice = goods.Price
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		assert.Len(t, seen, 10)
+
+		_, err := repo.ListByCursor(ctx, nil, PageQuery{Cursor: "bad"})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+//sqlite使用纯go实现的驱动，不需要cgo
+
+const _MemoryName = ":memory:"
+
+func isMemory(name string) bool {
+	return name == "" || name == _MemoryName
+}
+
+func sqliteConnect(cfg *DBConfig) (*gorm.DB, error) {
+	dsn := cfg.Name
+	if isMemory(dsn) {
+		dsn = _MemoryName
+	} else if err := os.MkdirAll(filepath.Dir(strings.SplitN(dsn, "?", 2)[0]), 0755); err != nil {
+		return nil, err
+	}
+	//开启外键约束，等待锁5秒，避免并发写入时直接报 database is locked
+	//name中已经有参数时追加，如 ./data/shop.db?_pragma=journal_mode(WAL)
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	dsn = fmt.Sprintf("%s%s_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", dsn, sep)
+
+	db, err := gorm.Open(sqlite.Open(dsn), gormConfig())
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("连接数据库:%s 失败！", cfg.Name))
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	if isMemory(cfg.Name) {
+		// 内存数据库每个连接都是独立的库，只能保留一个连接且不能过期，否则数据会丢失
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		return db, nil
+	}
+
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConn)
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConn)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifeTime)
+	return db, nil
+}
@@ -0,0 +1,81 @@
+//事务发件箱：业务数据和事件在同一个事务中写入，由Relay异步投递，保证事件不丢
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//  err := dbRepo.GetDb(ctx).Transaction(func(tx *gorm.DB) error {
+//      if err := tx.Create(order).Error; err != nil {
+//          return err
+//      }
+//      evt, err := outbox.NewEvent("order", order.ID, "order.created", order)
+//      if err != nil {
+//          return err
+//      }
+//      return outbox.Add(tx, evt)
+//  })
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"   //等待投递
+	StatusPublished Status = "published" //投递成功
+	StatusDead      Status = "dead"      //超过最大重试次数，需要人工处理
+)
+
+//Event outbox_event表
+//同一个聚合(AggregateType+AggregateID)的事件按ID顺序投递
+type Event struct {
+	ID            int64      `gorm:"primaryKey" json:"id"`
+	AggregateType string     `gorm:"size:64;not null;index:idx_outbox_aggregate,priority:1" json:"aggregate_type"`
+	AggregateID   string     `gorm:"size:64;not null;index:idx_outbox_aggregate,priority:2" json:"aggregate_id"`
+	EventType     string     `gorm:"size:128;not null" json:"event_type"`
+	Payload       string     `gorm:"type:text" json:"payload"`
+	Status        Status     `gorm:"size:16;not null;index:idx_outbox_status" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `gorm:"size:512" json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	PublishedAt   *time.Time `json:"published_at"`
+}
+
+func (Event) TableName() string {
+	return "outbox_event"
+}
+
+//AggregateKey 聚合的唯一标识
+func (e *Event) AggregateKey() string {
+	return e.AggregateType + ":" + e.AggregateID
+}
+
+//NewEvent payload会被序列化为json
+func NewEvent(aggregateType string, aggregateID interface{}, eventType string, payload interface{}) (*Event, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("序列化事件%s失败:%w", eventType, err)
+	}
+	return &Event{
+		AggregateType: aggregateType,
+		AggregateID:   fmt.Sprint(aggregateID),
+		EventType:     eventType,
+		Payload:       string(raw),
+	}, nil
+}
+
+//Add 在业务事务中写入事件，tx需要是同一个事务的gorm.DB
+func Add(tx *gorm.DB, events ...*Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	now := tx.NowFunc()
+	for _, evt := range events {
+		evt.Status = StatusPending
+		evt.NextAttemptAt = now
+	}
+	return tx.Create(events).Error
+}
@@ -0,0 +1,249 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github/xujialingit/shopping-app/pkg/db"
+	"go.uber.org/zap"
+)
+
+//Relay 轮询outbox_event表并投递到Sink
+//同一聚合的事件严格按顺序投递：前一个事件在退避等待时，后面的事件不会被投递；
+//前一个事件进入死信状态后，后面的事件继续投递
+//多个实例同时运行会导致重复投递，部署时只运行一个Relay
+
+type Option func(*option)
+
+type option struct {
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	now          func() time.Time
+}
+
+//WithPollInterval 轮询间隔，默认1秒
+func WithPollInterval(interval time.Duration) Option {
+	return func(opt *option) {
+		opt.pollInterval = interval
+	}
+}
+
+//WithBatchSize 每次轮询最多读取的事件数，默认100
+func WithBatchSize(size int) Option {
+	return func(opt *option) {
+		opt.batchSize = size
+	}
+}
+
+//WithMaxAttempts 最大投递次数，超过后进入死信状态，默认10
+func WithMaxAttempts(attempts int) Option {
+	return func(opt *option) {
+		opt.maxAttempts = attempts
+	}
+}
+
+//WithBackoff 失败后的重试间隔，从min开始每次翻倍，最大为max
+func WithBackoff(min, max time.Duration) Option {
+	return func(opt *option) {
+		opt.minBackoff = min
+		opt.maxBackoff = max
+	}
+}
+
+type Relay struct {
+	repo   db.Repo
+	sink   Sink
+	logger *zap.Logger
+	opt    *option
+}
+
+func NewRelay(repo db.Repo, sink Sink, logger *zap.Logger, options ...Option) (*Relay, error) {
+	if logger == nil {
+		return nil, errors.New("logger参数不能为空")
+	}
+	opt := &option{
+		pollInterval: time.Second,
+		batchSize:    100,
+		maxAttempts:  10,
+		minBackoff:   time.Second,
+		maxBackoff:   5 * time.Minute,
+		now:          time.Now,
+	}
+	for _, f := range options {
+		f(opt)
+	}
+	return &Relay{
+		repo:   repo,
+		sink:   sink,
+		logger: logger,
+		opt:    opt,
+	}, nil
+}
+
+//Run 持续轮询直到ctx结束
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.opt.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Process(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			r.logger.Error("outbox 投递失败", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+//Process 执行一轮投递，返回投递成功的事件数
+func (r *Relay) Process(ctx context.Context) (int, error) {
+	now := r.opt.now()
+	//同一聚合中前面的事件在退避等待时，后面的事件也不能投递，查询时一起跳过，避免它们占满批次导致其他聚合等待
+	blocked := r.repo.GetDb(ctx).Table("outbox_event AS head").Select("1").
+		Where("head.aggregate_type = outbox_event.aggregate_type AND head.aggregate_id = outbox_event.aggregate_id").
+		Where("head.status = ? AND head.id < outbox_event.id AND head.next_attempt_at > ?", StatusPending, now)
+	events := make([]*Event, 0, r.opt.batchSize)
+	err := r.repo.GetDb(ctx).
+		Where("status = ? AND next_attempt_at <= ?", StatusPending, now).
+		Where("NOT EXISTS (?)", blocked).
+		Order("id").
+		Limit(r.opt.batchSize).
+		Find(&events).Error
+	if err != nil {
+		return 0, err
+	}
+
+	//按聚合分组，保持组内顺序
+	order := make([]string, 0)
+	groups := make(map[string][]*Event)
+	for _, evt := range events {
+		key := evt.AggregateKey()
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], evt)
+	}
+
+	published := 0
+	for _, key := range order {
+		for _, evt := range groups[key] {
+			if ctx.Err() != nil {
+				return published, ctx.Err()
+			}
+			ok, err := r.deliver(ctx, evt)
+			if err != nil {
+				return published, err
+			}
+			if !ok {
+				//当前事件还没投递成功，同一聚合后面的事件需要等待
+				break
+			}
+			if evt.Status == StatusPublished {
+				published++
+			}
+		}
+	}
+	return published, nil
+}
+
+//deliver 投递单个事件，返回同一聚合后面的事件是否可以继续投递
+func (r *Relay) deliver(ctx context.Context, evt *Event) (bool, error) {
+	now := r.opt.now()
+	if evt.NextAttemptAt.After(now) {
+		return false, nil
+	}
+
+	publishErr := r.sink.Publish(ctx, evt)
+	evt.Attempts++
+
+	updates := map[string]interface{}{
+		"attempts": evt.Attempts,
+	}
+	if publishErr == nil {
+		evt.Status = StatusPublished
+		evt.PublishedAt = &now
+		updates["status"] = evt.Status
+		updates["published_at"] = now
+		updates["last_error"] = ""
+	} else {
+		evt.LastError = truncate(publishErr.Error(), 512)
+		updates["last_error"] = evt.LastError
+		if evt.Attempts >= r.opt.maxAttempts {
+			evt.Status = StatusDead
+			updates["status"] = evt.Status
+			r.logger.Error("outbox 事件进入死信状态",
+				zap.Int64("id", evt.ID),
+				zap.String("aggregate", evt.AggregateKey()),
+				zap.String("event_type", evt.EventType),
+				zap.Int("attempts", evt.Attempts),
+				zap.Error(publishErr),
+			)
+		} else {
+			evt.NextAttemptAt = now.Add(r.backoff(evt.Attempts))
+			updates["next_attempt_at"] = evt.NextAttemptAt
+			r.logger.Warn("outbox 事件投递失败，等待重试",
+				zap.Int64("id", evt.ID),
+				zap.String("aggregate", evt.AggregateKey()),
+				zap.Int("attempts", evt.Attempts),
+				zap.Time("next_attempt_at", evt.NextAttemptAt),
+				zap.Error(publishErr),
+			)
+		}
+	}
+
+	err := r.repo.GetDb(ctx).Model(&Event{}).
+		Where("id = ? AND status = ?", evt.ID, StatusPending).
+		Updates(updates).Error
+	if err != nil {
+		return false, err
+	}
+	return evt.Status != StatusPending, nil
+}
+
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.opt.minBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= r.opt.maxBackoff {
+			return r.opt.maxBackoff
+		}
+	}
+	return delay
+}
+
+//Retry 把死信事件重新放回投递队列
+func Retry(ctx context.Context, repo db.Repo, id int64) error {
+	tx := repo.GetDb(ctx).Model(&Event{}).
+		Where("id = ? AND status = ?", id, StatusDead).
+		Updates(map[string]interface{}{
+			"status":          StatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	//不截断多字节字符
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,176 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github/xujialingit/shopping-app/pkg/db"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type testOrder struct {
+	ID     int64
+	Amount int
+}
+
+func newTestRepo(t *testing.T) db.Repo {
+	repo, err := db.New(&db.DBConfig{Driver: db.DriverSqlite})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = repo.DbClose() })
+	assert.NoError(t, repo.GetDb(context.Background()).AutoMigrate(&testOrder{}, &Event{}))
+	return repo
+}
+
+func addOrderEvent(t *testing.T, repo db.Repo, orderID int64, eventType string) {
+	err := repo.GetDb(context.Background()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&testOrder{ID: orderID, Amount: 100}).Error; err != nil {
+			return err
+		}
+		evt, err := NewEvent("order", orderID, eventType, map[string]interface{}{"order_id": orderID})
+		if err != nil {
+			return err
+		}
+		return Add(tx, evt)
+	})
+	assert.NoError(t, err)
+}
+
+func TestAdd_RollbackWithBusiness(t *testing.T) {
+	repo := newTestRepo(t)
+	err := repo.GetDb(context.Background()).Transaction(func(tx *gorm.DB) error {
+		evt, _ := NewEvent("order", 1, "order.created", nil)
+		if err := Add(tx, evt); err != nil {
+			return err
+		}
+		return errors.New("业务失败")
+	})
+	assert.Error(t, err)
+
+	var count int64
+	repo.GetDb(context.Background()).Model(&Event{}).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestRelay_ProcessBlockedAggregate(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+
+	bus := NewBus()
+	received := make([]string, 0)
+	bus.Subscribe(AllEvents, func(ctx context.Context, evt *Event) error {
+		if evt.AggregateID == "1" && evt.EventType == "order.created" {
+			return errors.New("下游不可用")
+		}
+		received = append(received, evt.AggregateID+":"+evt.EventType)
+		return nil
+	})
+
+	relay, err := NewRelay(repo, bus, zap.NewNop(), WithBatchSize(2), WithBackoff(time.Minute, time.Hour))
+	assert.NoError(t, err)
+
+	//order 1 待投递的事件超过一个批次，且第一个事件在退避等待
+	addOrderEvent(t, repo, 1, "order.created")
+	addOrderEvent(t, repo, 1, "order.paid")
+	addOrderEvent(t, repo, 1, "order.shipped")
+	addOrderEvent(t, repo, 2, "order.created")
+	now := time.Now()
+	relay.opt.now = func() time.Time { return now }
+
+	n, err := relay.Process(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	//order 2 不会被 order 1 的事件挡住
+	n, err = relay.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"2:order.created"}, received)
+
+	//order 1 仍然按顺序等待第一个事件
+	n, err = relay.Process(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestRelay_Process(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+
+	bus := NewBus()
+	received := make([]string, 0)
+	failing := map[string]bool{"order.paid": true}
+	bus.Subscribe(AllEvents, func(ctx context.Context, evt *Event) error {
+		if failing[evt.EventType] {
+			return errors.New("下游不可用")
+		}
+		received = append(received, evt.AggregateID+":"+evt.EventType)
+		return nil
+	})
+
+	relay, err := NewRelay(repo, bus, zap.NewNop(), WithMaxAttempts(3), WithBackoff(time.Second, time.Minute))
+	assert.NoError(t, err)
+
+	addOrderEvent(t, repo, 1, "order.created")
+	addOrderEvent(t, repo, 1, "order.paid")
+	addOrderEvent(t, repo, 1, "order.shipped")
+	addOrderEvent(t, repo, 2, "order.created")
+
+	now := time.Now()
+	relay.opt.now = func() time.Time { return now }
+
+	//order 1 的paid失败后，shipped需要等待；order 2 不受影响
+	n, err := relay.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"1:order.created", "2:order.created"}, received)
+
+	//退避时间内不会重试
+	n, err = relay.Process(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	//第三次失败后进入死信，后面的事件继续投递
+	now = now.Add(time.Second)
+	_, _ = relay.Process(ctx)
+	now = now.Add(2 * time.Second)
+	n, err = relay.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "1:order.shipped", received[len(received)-1])
+
+	var dead Event
+	assert.NoError(t, repo.GetDb(ctx).Where("status = ?", StatusDead).Take(&dead).Error)
+	assert.Equal(t, "order.paid", dead.EventType)
+	assert.Equal(t, 3, dead.Attempts)
+	assert.Equal(t, "下游不可用", dead.LastError)
+
+	//死信重新投递
+	failing["order.paid"] = false
+	assert.NoError(t, Retry(ctx, repo, dead.ID))
+	relay.opt.now = time.Now
+	n, err = relay.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "1:order.paid", received[len(received)-1])
+}
+
+func TestWebhookSink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Event-Type") != "order.created" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, time.Second)
+	assert.NoError(t, sink.Publish(context.Background(), &Event{ID: 1, EventType: "order.created", Payload: "{}"}))
+	assert.Error(t, sink.Publish(context.Background(), &Event{ID: 2, EventType: "order.paid", Payload: "{}"}))
+}
@@ -0,0 +1,127 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//Sink 事件的投递目标，返回error时Relay会按退避策略重试
+//投递语义为至少一次，消费方需要用Event.ID做幂等
+type Sink interface {
+	Publish(ctx context.Context, evt *Event) error
+}
+
+type redisStreamSink struct {
+	client *redis.Client
+	prefix string
+	maxLen int64
+}
+
+//NewRedisStreamSink 投递到 redis stream，stream名称为 prefix + AggregateType
+//maxLen大于0时按近似长度裁剪stream
+func NewRedisStreamSink(client *redis.Client, prefix string, maxLen int64) Sink {
+	return &redisStreamSink{
+		client: client,
+		prefix: prefix,
+		maxLen: maxLen,
+	}
+}
+
+func (s *redisStreamSink) Publish(ctx context.Context, evt *Event) error {
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.prefix + evt.AggregateType,
+		MaxLen: s.maxLen,
+		Approx: s.maxLen > 0,
+		Values: map[string]interface{}{
+			"id":             evt.ID,
+			"aggregate_type": evt.AggregateType,
+			"aggregate_id":   evt.AggregateID,
+			"event_type":     evt.EventType,
+			"payload":        evt.Payload,
+			"created_at":     evt.CreatedAt.Unix(),
+		},
+	}).Err()
+}
+
+//Handler 进程内事件处理函数
+type Handler func(ctx context.Context, evt *Event) error
+
+//Bus 进程内的事件总线，同步调用订阅者，任意订阅者返回error都会导致重试
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+//AllEvents 订阅全部事件类型
+const AllEvents = "*"
+
+func NewBus() *Bus {
+	return &Bus{
+		handlers: make(map[string][]Handler),
+	}
+}
+
+//Subscribe 订阅事件类型，eventType为AllEvents时接收全部事件
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+func (b *Bus) Publish(ctx context.Context, evt *Event) error {
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.handlers[evt.EventType])+len(b.handlers[AllEvents]))
+	handlers = append(handlers, b.handlers[evt.EventType]...)
+	handlers = append(handlers, b.handlers[AllEvents]...)
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		if err := handler(ctx, evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+//NewWebhookSink 以json POST到url，返回非2xx状态码视为失败
+func NewWebhookSink(url string, timeout time.Duration) Sink {
+	return &webhookSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *webhookSink) Publish(ctx context.Context, evt *Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewBufferString(evt.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", strconv.FormatInt(evt.ID, 10))
+	req.Header.Set("X-Event-Type", evt.EventType)
+	req.Header.Set("X-Aggregate-Type", evt.AggregateType)
+	req.Header.Set("X-Aggregate-Id", evt.AggregateID)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook返回状态码:%d", resp.StatusCode)
+	}
+	return nil
+}
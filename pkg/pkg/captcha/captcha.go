@@ -0,0 +1,234 @@
+//图形验证码，不依赖外部服务
+//答案保存在redis中，有效期内只能校验一次，校验错误同样失效；校验通过后可以换取一次性的ticket交给后续接口使用
+package captcha
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github/xujialingit/shopping-app/pkg/cache"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	//Digits 纯数字
+	Digits = "0123456789"
+	//Alnum 数字和大写字母，去掉了容易混淆的 0 1 I O
+	Alnum = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+const (
+	DefaultLength    = 4
+	DefaultTTL       = 2 * time.Minute
+	DefaultTicketTTL = 5 * time.Minute
+	DefaultWidth     = 120
+	DefaultHeight    = 40
+	DefaultKeyPrefix = "captcha:"
+)
+
+var ErrInvalid = errors.New("captcha: 图形验证码错误或已过期")
+
+//Captcha 返回给前端的验证码，Image 为 data:image/png;base64 格式
+type Captcha struct {
+	ID        string `json:"captcha_id"`
+	Image     string `json:"image"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+type Service interface {
+	i()
+	//Generate 生成验证码图片，答案保存到redis
+	Generate(ctx context.Context) (*Captcha, error)
+	//Verify 校验答案，不区分大小写；无论是否正确验证码都失效
+	Verify(ctx context.Context, id, answer string) error
+	//Exchange 校验答案，通过后返回一次性的ticket，用于先校验验证码、再提交表单的场景
+	Exchange(ctx context.Context, id, answer string) (string, error)
+	//Redeem 使用ticket，只能使用一次
+	Redeem(ctx context.Context, ticket string) error
+}
+
+type Option func(*option)
+
+type option struct {
+	length    int
+	ttl       time.Duration
+	ticketTTL time.Duration
+	width     int
+	height    int
+	charset   string
+	keyPrefix string
+}
+
+//WithLength 字符个数
+func WithLength(length int) Option {
+	return func(opt *option) {
+		opt.length = length
+	}
+}
+
+//WithTTL 验证码有效期
+func WithTTL(ttl time.Duration) Option {
+	return func(opt *option) {
+		opt.ttl = ttl
+	}
+}
+
+//WithTicketTTL Exchange 返回的ticket的有效期
+func WithTicketTTL(ttl time.Duration) Option {
+	return func(opt *option) {
+		opt.ticketTTL = ttl
+	}
+}
+
+//WithSize 图片宽高
+func WithSize(width, height int) Option {
+	return func(opt *option) {
+		opt.width = width
+		opt.height = height
+	}
+}
+
+//WithCharset 使用的字符，只支持 Digits Alnum 中的字符
+func WithCharset(charset string) Option {
+	return func(opt *option) {
+		opt.charset = charset
+	}
+}
+
+//WithKeyPrefix redis key前缀
+func WithKeyPrefix(prefix string) Option {
+	return func(opt *option) {
+		opt.keyPrefix = prefix
+	}
+}
+
+type service struct {
+	cache cache.Repo
+	opt   *option
+}
+
+func New(repo cache.Repo, options ...Option) Service {
+	opt := &option{
+		length:    DefaultLength,
+		ttl:       DefaultTTL,
+		ticketTTL: DefaultTicketTTL,
+		width:     DefaultWidth,
+		height:    DefaultHeight,
+		charset:   Alnum,
+		keyPrefix: DefaultKeyPrefix,
+	}
+	for _, f := range options {
+		f(opt)
+	}
+	return &service{
+		cache: repo,
+		opt:   opt,
+	}
+}
+
+func (s *service) i() {}
+
+func (s *service) answerKey(id string) string {
+	return s.opt.keyPrefix + "answer:" + id
+}
+
+func (s *service) ticketKey(ticket string) string {
+	return s.opt.keyPrefix + "ticket:" + ticket
+}
+
+func (s *service) Generate(ctx context.Context) (*Captcha, error) {
+	answer, err := randomText(s.opt.charset, s.opt.length)
+	if err != nil {
+		return nil, err
+	}
+	png, err := Render(answer, s.opt.width, s.opt.height)
+	if err != nil {
+		return nil, err
+	}
+	id := token.NewJTI()
+	if err := s.cache.Client().Set(ctx, s.answerKey(id), hashAnswer(answer), s.opt.ttl).Err(); err != nil {
+		return nil, err
+	}
+	return &Captcha{
+		ID:        id,
+		Image:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		ExpiresIn: int64(s.opt.ttl / time.Second),
+	}, nil
+}
+
+//KEYS[1] 答案 读取后删除，并发校验只有一个能拿到答案
+var takeScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if value then
+	redis.call('DEL', KEYS[1])
+end
+return value
+`)
+
+func (s *service) Verify(ctx context.Context, id, answer string) error {
+	if id == "" || answer == "" {
+		return ErrInvalid
+	}
+	want, err := takeScript.Run(ctx, s.cache.Client(), []string{s.answerKey(id)}).Text()
+	if errors.Is(err, redis.Nil) {
+		return ErrInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if want != hashAnswer(answer) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func (s *service) Exchange(ctx context.Context, id, answer string) (string, error) {
+	if err := s.Verify(ctx, id, answer); err != nil {
+		return "", err
+	}
+	ticket := token.NewJTI()
+	if err := s.cache.Client().Set(ctx, s.ticketKey(ticket), "1", s.opt.ticketTTL).Err(); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+func (s *service) Redeem(ctx context.Context, ticket string) error {
+	if ticket == "" {
+		return ErrInvalid
+	}
+	n, err := s.cache.Client().Del(ctx, s.ticketKey(ticket)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+//hashAnswer redis中不保存答案明文，忽略大小写和首尾空格
+func hashAnswer(answer string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(answer))))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomText(charset string, length int) (string, error) {
+	text := make([]byte, length)
+	max := big.NewInt(int64(len(charset)))
+	for i := range text {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		text[i] = charset[n.Int64()]
+	}
+	return string(text), nil
+}
@@ -0,0 +1,80 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github/xujialingit/shopping-app/pkg/cache/cachetest"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	for _, c := range []byte(Digits + Alnum) {
+		_, ok := glyphs[c]
+		assert.True(t, ok, string(c))
+	}
+
+	raw, err := Render("A7K3", 120, 40)
+	assert.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, 120, img.Bounds().Dx())
+	assert.Equal(t, 40, img.Bounds().Dy())
+
+	//同样的文字每次扭曲不同
+	other, err := Render("A7K3", 120, 40)
+	assert.NoError(t, err)
+	assert.NotEqual(t, raw, other)
+}
+
+func TestService(t *testing.T) {
+	cacheRepo := cachetest.New(t)
+
+	ctx := context.Background()
+	svc := New(cacheRepo, WithCharset(Digits), WithLength(5)).(*service)
+
+	generate := func(t *testing.T) (string, string) {
+		c, err := svc.Generate(ctx)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(c.Image, "data:image/png;base64,"))
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(c.Image, "data:image/png;base64,"))
+		assert.NoError(t, err)
+		_, err = png.Decode(bytes.NewReader(raw))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(DefaultTTL.Seconds()), c.ExpiresIn)
+
+		//直接改写答案，图片中的文字无法在测试中识别
+		assert.NoError(t, cacheRepo.Client().Set(ctx, svc.answerKey(c.ID), hashAnswer("ab12c"), DefaultTTL).Err())
+		return c.ID, "ab12c"
+	}
+
+	t.Run("校验通过后失效", func(t *testing.T) {
+		id, answer := generate(t)
+		assert.NoError(t, svc.Verify(ctx, id, " "+strings.ToUpper(answer)))
+		assert.Equal(t, ErrInvalid, svc.Verify(ctx, id, answer))
+	})
+
+	t.Run("答案错误同样失效", func(t *testing.T) {
+		id, answer := generate(t)
+		assert.Equal(t, ErrInvalid, svc.Verify(ctx, id, "00000"))
+		assert.Equal(t, ErrInvalid, svc.Verify(ctx, id, answer))
+		assert.Equal(t, ErrInvalid, svc.Verify(ctx, "", answer))
+		assert.Equal(t, ErrInvalid, svc.Verify(ctx, "not-exist", answer))
+	})
+
+	t.Run("换取ticket", func(t *testing.T) {
+		id, answer := generate(t)
+		ticket, err := svc.Exchange(ctx, id, answer)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, ticket)
+		assert.NoError(t, svc.Redeem(ctx, ticket))
+		assert.Equal(t, ErrInvalid, svc.Redeem(ctx, ticket))
+
+		_, err = svc.Exchange(ctx, id, answer)
+		assert.Equal(t, ErrInvalid, err)
+	})
+}
@@ -0,0 +1,140 @@
+package captcha
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+)
+
+//Render 把text绘制为扭曲的png图片，text中没有点阵字体的字符不绘制
+//每个字符随机缩放、旋转和偏移，整体做正弦扭曲，再加上干扰点和干扰线
+func Render(text string, width, height int) ([]byte, error) {
+	rnd := rand.New(rand.NewSource(randomSeed()))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	//浅色背景
+	bg := color.NRGBA{R: uint8(220 + rnd.Intn(36)), G: uint8(220 + rnd.Intn(36)), B: uint8(220 + rnd.Intn(36)), A: 255}
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = bg.R, bg.G, bg.B, bg.A
+	}
+
+	//干扰点
+	for n := width * height / 25; n > 0; n-- {
+		img.SetNRGBA(rnd.Intn(width), rnd.Intn(height), randomColor(rnd, 160))
+	}
+
+	drawWarped(img, drawText(text, width, height, rnd), rnd)
+
+	//干扰线
+	for n := 2; n > 0; n-- {
+		drawCurve(img, randomColor(rnd, 140), rnd)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//drawText 在透明图层上逐个绘制字符
+func drawText(text string, width, height int, rnd *rand.Rand) *image.NRGBA {
+	layer := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if len(text) == 0 {
+		return layer
+	}
+	cell := float64(width) / float64(len(text))
+	for i := 0; i < len(text); i++ {
+		glyph, ok := glyphs[text[i]]
+		if !ok {
+			continue
+		}
+		scale := math.Min(cell*0.75/glyphWidth, float64(height)*0.7/glyphHeight) * (0.85 + rnd.Float64()*0.25)
+		cx := cell*(float64(i)+0.5) + (rnd.Float64()-0.5)*cell*0.2
+		cy := float64(height)/2 + (rnd.Float64()-0.5)*float64(height)*0.15
+		angle := (rnd.Float64() - 0.5) * 0.7
+		drawGlyph(layer, glyph, cx, cy, scale, angle, randomColor(rnd, 110))
+	}
+	return layer
+}
+
+//drawGlyph 对字符外接圆内的每个像素反向旋转、缩放，落在点阵的笔画上时着色
+func drawGlyph(img *image.NRGBA, glyph [glyphHeight]string, cx, cy, scale, angle float64, c color.NRGBA) {
+	sin, cos := math.Sincos(angle)
+	radius := scale * math.Hypot(glyphWidth, glyphHeight) / 2
+	bounds := img.Bounds()
+	for y := int(cy - radius); y <= int(cy+radius); y++ {
+		for x := int(cx - radius); x <= int(cx+radius); x++ {
+			if !(image.Point{X: x, Y: y}).In(bounds) {
+				continue
+			}
+			dx, dy := float64(x)-cx, float64(y)-cy
+			gx := (dx*cos+dy*sin)/scale + glyphWidth/2.0
+			gy := (-dx*sin+dy*cos)/scale + glyphHeight/2.0
+			if gx < 0 || gy < 0 || gx >= glyphWidth || gy >= glyphHeight {
+				continue
+			}
+			if glyph[int(gy)][int(gx)] == '#' {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+//drawWarped 把文字图层按正弦波扭曲后画到dst上
+func drawWarped(dst, layer *image.NRGBA, rnd *rand.Rand) {
+	bounds := dst.Bounds()
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	ampX, ampY := height*0.08, height*0.1
+	freqX, freqY := 2*math.Pi/(height*(1+rnd.Float64())), 2*math.Pi/(width*(0.4+rnd.Float64()*0.4))
+	phaseX, phaseY := rnd.Float64()*2*math.Pi, rnd.Float64()*2*math.Pi
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sx := x + int(ampX*math.Sin(float64(y)*freqX+phaseX))
+			sy := y + int(ampY*math.Sin(float64(x)*freqY+phaseY))
+			if !(image.Point{X: sx, Y: sy}).In(bounds) {
+				continue
+			}
+			if c := layer.NRGBAAt(sx, sy); c.A > 0 {
+				dst.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+//drawCurve 横穿图片的正弦曲线，线宽2像素
+func drawCurve(img *image.NRGBA, c color.NRGBA, rnd *rand.Rand) {
+	bounds := img.Bounds()
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	amp := height * (0.1 + rnd.Float64()*0.2)
+	freq := 2 * math.Pi / (width * (0.5 + rnd.Float64()))
+	phase := rnd.Float64() * 2 * math.Pi
+	base := height * (0.3 + rnd.Float64()*0.4)
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		y := int(base + amp*math.Sin(float64(x)*freq+phase))
+		for _, p := range []image.Point{{X: x, Y: y}, {X: x, Y: y + 1}} {
+			if p.In(bounds) {
+				img.SetNRGBA(p.X, p.Y, c)
+			}
+		}
+	}
+}
+
+//randomColor 各通道不超过max的深色
+func randomColor(rnd *rand.Rand, max int) color.NRGBA {
+	return color.NRGBA{R: uint8(rnd.Intn(max)), G: uint8(rnd.Intn(max)), B: uint8(rnd.Intn(max)), A: 255}
+}
+
+//randomSeed 图片的扭曲参数不需要安全随机数，只用crypto/rand生成种子，避免多张图片相同
+func randomSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return rand.Int63()
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
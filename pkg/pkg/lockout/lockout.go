@@ -0,0 +1,363 @@
+//登录防暴力破解：按账号和IP统计失败次数，账号失败多次后逐步增加等待时间，账号或IP超过限制后临时锁定
+//可以用于任意需要校验密码、验证码的登录类接口：尝试前调用 Check，失败调用 Fail，成功调用 Success
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github/xujialingit/shopping-app/pkg/cache"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//Scope 失败次数的统计维度
+type Scope string
+
+const (
+	ScopeAccount Scope = "account"
+	ScopeIP      Scope = "ip"
+)
+
+const (
+	DefaultMaxFailures   = 5
+	DefaultMaxIPFailures = 20
+	DefaultWindow        = 15 * time.Minute
+	DefaultLockDuration  = 30 * time.Minute
+	DefaultDelayAfter    = 3
+	DefaultBaseDelay     = time.Second
+	DefaultMaxDelay      = 30 * time.Second
+	DefaultKeyPrefix     = "lockout:"
+)
+
+var (
+	ErrLocked      = errors.New("lockout: 失败次数过多，已被临时锁定")
+	ErrTooFrequent = errors.New("lockout: 尝试过于频繁，请稍后再试")
+)
+
+//Error 被锁定或需要等待时返回，errors.Is 可以判断是 ErrLocked 还是 ErrTooFrequent
+type Error struct {
+	Err        error
+	Scope      Scope
+	RetryAfter time.Duration //需要等待的时间
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s(%s)，%s后重试", e.Err.Error(), e.Scope, e.RetryAfter.Round(time.Second))
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+//RetryAfter 从错误中取出需要等待的时间，不是 *Error 时返回0
+func RetryAfter(err error) time.Duration {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.RetryAfter
+	}
+	return 0
+}
+
+const (
+	EventLocked   = "locked"
+	EventUnlocked = "unlocked"
+)
+
+//Event 锁定、解锁事件
+type Event struct {
+	Type     string
+	Scope    Scope
+	Key      string //账号或IP
+	Failures int64
+	Time     time.Time
+}
+
+type Guard interface {
+	i()
+	//Check 尝试前调用，被锁定或需要等待时返回 *Error；ip为空时只检查账号
+	Check(ctx context.Context, account, ip string) error
+	//Fail 记录一次失败，本次失败导致锁定时返回 ErrLocked
+	Fail(ctx context.Context, account, ip string) error
+	//Success 成功后清空账号的失败次数，IP的失败次数不清空，避免用一个账号掩护对其他账号的猜测
+	Success(ctx context.Context, account string) error
+	//Unlock 解除账号锁定并清空失败次数，用于管理员解锁或用户通过验证码解锁
+	Unlock(ctx context.Context, account string) error
+	//Locked 账号剩余的锁定时间，未锁定时返回0
+	Locked(ctx context.Context, account string) (time.Duration, error)
+	//NeedCaptcha 账号失败次数达到 WithDelay 的after后返回true，登录时需要同时校验图形验证码；after为0时总是返回false
+	NeedCaptcha(ctx context.Context, account string) (bool, error)
+}
+
+type Option func(*option)
+
+type option struct {
+	maxFailures   int
+	maxIPFailures int
+	window        time.Duration
+	lockDuration  time.Duration
+	delayAfter    int
+	baseDelay     time.Duration
+	maxDelay      time.Duration
+	keyPrefix     string
+	onEvent       func(ctx context.Context, event Event)
+}
+
+//WithMaxFailures 账号在统计窗口内允许失败的次数，达到后锁定账号
+func WithMaxFailures(n int) Option {
+	return func(opt *option) {
+		opt.maxFailures = n
+	}
+}
+
+//WithMaxIPFailures 同一个IP在统计窗口内允许失败的次数，达到后锁定IP，为0时不按IP统计
+func WithMaxIPFailures(n int) Option {
+	return func(opt *option) {
+		opt.maxIPFailures = n
+	}
+}
+
+//WithWindow 失败次数的统计窗口，从第一次失败开始计算
+func WithWindow(window time.Duration) Option {
+	return func(opt *option) {
+		opt.window = window
+	}
+}
+
+//WithLockDuration 锁定时间
+func WithLockDuration(d time.Duration) Option {
+	return func(opt *option) {
+		opt.lockDuration = d
+	}
+}
+
+//WithDelay 账号失败after次后，每次尝试前需要等待 base*2^(失败次数-after)，最多等待max
+func WithDelay(after int, base, max time.Duration) Option {
+	return func(opt *option) {
+		opt.delayAfter = after
+		opt.baseDelay = base
+		opt.maxDelay = max
+	}
+}
+
+//WithKeyPrefix redis key前缀，不同的登录方式需要分开统计时使用
+func WithKeyPrefix(prefix string) Option {
+	return func(opt *option) {
+		opt.keyPrefix = prefix
+	}
+}
+
+//WithEventHandler 锁定、解锁事件的处理函数，如告警；事件总会以warn级别写入日志
+func WithEventHandler(fn func(ctx context.Context, event Event)) Option {
+	return func(opt *option) {
+		opt.onEvent = fn
+	}
+}
+
+type guard struct {
+	cache cache.Repo
+	opt   *option
+	now   func() time.Time
+}
+
+func New(repo cache.Repo, options ...Option) Guard {
+	opt := &option{
+		maxFailures:   DefaultMaxFailures,
+		maxIPFailures: DefaultMaxIPFailures,
+		window:        DefaultWindow,
+		lockDuration:  DefaultLockDuration,
+		delayAfter:    DefaultDelayAfter,
+		baseDelay:     DefaultBaseDelay,
+		maxDelay:      DefaultMaxDelay,
+		keyPrefix:     DefaultKeyPrefix,
+	}
+	for _, f := range options {
+		f(opt)
+	}
+	return &guard{
+		cache: repo,
+		opt:   opt,
+		now:   time.Now,
+	}
+}
+
+func (g *guard) i() {}
+
+//failKey hash，failures 失败次数，last 最后一次失败的时间(毫秒)
+func (g *guard) failKey(scope Scope, key string) string {
+	return fmt.Sprintf("%sfail:%s:%s", g.opt.keyPrefix, scope, key)
+}
+
+func (g *guard) lockKey(scope Scope, key string) string {
+	return fmt.Sprintf("%slock:%s:%s", g.opt.keyPrefix, scope, key)
+}
+
+//targets 需要统计的维度，ip为空或未开启按IP统计时只有账号
+func (g *guard) targets(account, ip string) map[Scope]string {
+	targets := map[Scope]string{ScopeAccount: account}
+	if ip != "" && g.opt.maxIPFailures > 0 {
+		targets[ScopeIP] = ip
+	}
+	return targets
+}
+
+func (g *guard) maxFailures(scope Scope) int {
+	if scope == ScopeIP {
+		return g.opt.maxIPFailures
+	}
+	return g.opt.maxFailures
+}
+
+//delay 失败failures次后下一次尝试需要等待的时间
+func (g *guard) delay(failures int64) time.Duration {
+	if g.opt.delayAfter <= 0 || g.opt.baseDelay <= 0 || failures < int64(g.opt.delayAfter) {
+		return 0
+	}
+	d := g.opt.baseDelay
+	for n := failures - int64(g.opt.delayAfter); n > 0 && d < g.opt.maxDelay; n-- {
+		d *= 2
+	}
+	if g.opt.maxDelay > 0 && d > g.opt.maxDelay {
+		d = g.opt.maxDelay
+	}
+	return d
+}
+
+func (g *guard) Check(ctx context.Context, account, ip string) error {
+	client := g.cache.Client()
+	targets := g.targets(account, ip)
+	for _, scope := range []Scope{ScopeAccount, ScopeIP} {
+		key, ok := targets[scope]
+		if !ok {
+			continue
+		}
+		ttl, err := client.PTTL(ctx, g.lockKey(scope, key)).Result()
+		if err != nil {
+			return err
+		}
+		if ttl > 0 {
+			return &Error{Err: ErrLocked, Scope: scope, RetryAfter: ttl}
+		}
+	}
+
+	//等待时间只按账号计算，同一个出口IP下的正常用户不受其他人输错密码影响
+	values, err := client.HMGet(ctx, g.failKey(ScopeAccount, account), "failures", "last").Result()
+	if err != nil {
+		return err
+	}
+	failures, _ := strconv.ParseInt(toString(values[0]), 10, 64)
+	last, _ := strconv.ParseInt(toString(values[1]), 10, 64)
+	if wait := time.UnixMilli(last).Add(g.delay(failures)).Sub(g.now()); wait > 0 {
+		return &Error{Err: ErrTooFrequent, Scope: ScopeAccount, RetryAfter: wait}
+	}
+	return nil
+}
+
+func toString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+//KEYS[1] 失败次数 KEYS[2] 锁定 ARGV[1] 当前时间(毫秒) ARGV[2] 统计窗口(毫秒) ARGV[3] 允许失败的次数 ARGV[4] 锁定时间(毫秒)
+//返回 {失败次数, 是否锁定}，锁定后清空失败次数
+var failScript = redis.NewScript(`
+local failures = redis.call('HINCRBY', KEYS[1], 'failures', 1)
+redis.call('HSET', KEYS[1], 'last', ARGV[1])
+if failures == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+if failures >= tonumber(ARGV[3]) then
+	redis.call('SET', KEYS[2], ARGV[1], 'PX', ARGV[4])
+	redis.call('DEL', KEYS[1])
+	return {failures, 1}
+end
+return {failures, 0}
+`)
+
+func (g *guard) Fail(ctx context.Context, account, ip string) error {
+	var locked *Error
+	for scope, key := range g.targets(account, ip) {
+		result, err := failScript.Run(ctx, g.cache.Client(), []string{g.failKey(scope, key), g.lockKey(scope, key)},
+			g.now().UnixMilli(), g.opt.window.Milliseconds(), g.maxFailures(scope), g.opt.lockDuration.Milliseconds()).Int64Slice()
+		if err != nil {
+			return err
+		}
+		if result[1] == 1 {
+			g.emit(ctx, Event{Type: EventLocked, Scope: scope, Key: key, Failures: result[0], Time: g.now()})
+			if locked == nil || scope == ScopeAccount {
+				locked = &Error{Err: ErrLocked, Scope: scope, RetryAfter: g.opt.lockDuration}
+			}
+		}
+	}
+	if locked != nil {
+		return locked
+	}
+	return nil
+}
+
+func (g *guard) Success(ctx context.Context, account string) error {
+	return g.cache.Client().Del(ctx, g.failKey(ScopeAccount, account)).Err()
+}
+
+func (g *guard) Unlock(ctx context.Context, account string) error {
+	n, err := g.cache.Client().Del(ctx, g.lockKey(ScopeAccount, account), g.failKey(ScopeAccount, account)).Result()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		g.emit(ctx, Event{Type: EventUnlocked, Scope: ScopeAccount, Key: account, Time: g.now()})
+	}
+	return nil
+}
+
+func (g *guard) Locked(ctx context.Context, account string) (time.Duration, error) {
+	ttl, err := g.cache.Client().PTTL(ctx, g.lockKey(ScopeAccount, account)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (g *guard) NeedCaptcha(ctx context.Context, account string) (bool, error) {
+	if g.opt.delayAfter <= 0 {
+		return false, nil
+	}
+	failures, err := g.cache.Client().HGet(ctx, g.failKey(ScopeAccount, account), "failures").Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return failures >= int64(g.opt.delayAfter), nil
+}
+
+//maskKey 日志中的账号需要脱敏，IP原样记录
+func maskKey(event Event) string {
+	if event.Scope != ScopeAccount {
+		return event.Key
+	}
+	if strings.Contains(event.Key, "@") {
+		return logger.MaskEmail(event.Key)
+	}
+	return logger.MaskMiddle(3, 4)(event.Key)
+}
+
+func (g *guard) emit(ctx context.Context, event Event) {
+	logger.FromContext(ctx).Warn("lockout event",
+		zap.String("event", event.Type),
+		zap.String(string(event.Scope), maskKey(event)),
+		zap.Int64("failures", event.Failures),
+	)
+	if g.opt.onEvent != nil {
+		g.opt.onEvent(ctx, event)
+	}
+}
@@ -0,0 +1,118 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"github/xujialingit/shopping-app/pkg/cache/cachetest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuard(t *testing.T) {
+	cacheRepo := cachetest.New(t)
+
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+	ctx := context.Background()
+	g := New(cacheRepo,
+		WithKeyPrefix("test:lockout:"),
+		WithMaxFailures(4),
+		WithMaxIPFailures(6),
+		WithDelay(2, time.Second, 4*time.Second),
+		WithLockDuration(time.Minute),
+		WithEventHandler(func(ctx context.Context, event Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+		}),
+	).(*guard)
+	now := time.Now().Truncate(time.Millisecond) //redis中保存的是毫秒
+	g.now = func() time.Time { return now }
+
+	t.Run("逐步增加等待时间", func(t *testing.T) {
+		account, ip := "tom@test.com", "10.0.0.1"
+		assert.NoError(t, g.Check(ctx, account, ip))
+		assert.NoError(t, g.Fail(ctx, account, ip))
+		assert.NoError(t, g.Check(ctx, account, ip))
+		assert.NoError(t, g.Fail(ctx, account, ip))
+
+		//达到等待次数后需要图形验证码
+		need, err := g.NeedCaptcha(ctx, account)
+		assert.NoError(t, err)
+		assert.True(t, need)
+		need, err = g.NeedCaptcha(ctx, "other@test.com")
+		assert.NoError(t, err)
+		assert.False(t, need)
+
+		err = g.Check(ctx, account, ip)
+		assert.True(t, errors.Is(err, ErrTooFrequent))
+		assert.Equal(t, time.Second, RetryAfter(err))
+		//同一个IP下的其他账号不需要等待
+		assert.NoError(t, g.Check(ctx, "other@test.com", ip))
+
+		now = now.Add(time.Second)
+		assert.NoError(t, g.Check(ctx, account, ip))
+		assert.NoError(t, g.Fail(ctx, account, ip))
+		assert.Equal(t, 2*time.Second, RetryAfter(g.Check(ctx, account, ip)))
+
+		//成功后清空账号的失败次数
+		now = now.Add(2 * time.Second)
+		assert.NoError(t, g.Success(ctx, account))
+		assert.NoError(t, g.Check(ctx, account, ""))
+		need, err = g.NeedCaptcha(ctx, account)
+		assert.NoError(t, err)
+		assert.False(t, need)
+	})
+
+	t.Run("锁定和解锁", func(t *testing.T) {
+		account := "jerry@test.com"
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, g.Fail(ctx, account, ""))
+		}
+		err := g.Fail(ctx, account, "")
+		assert.True(t, errors.Is(err, ErrLocked))
+
+		err = g.Check(ctx, account, "")
+		assert.True(t, errors.Is(err, ErrLocked))
+		assert.Greater(t, RetryAfter(err), time.Duration(0))
+		locked, err := g.Locked(ctx, account)
+		assert.NoError(t, err)
+		assert.Greater(t, locked, time.Duration(0))
+
+		assert.NoError(t, g.Unlock(ctx, account))
+		assert.NoError(t, g.Check(ctx, account, ""))
+		locked, err = g.Locked(ctx, account)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), locked)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, EventLocked, events[0].Type)
+			assert.Equal(t, int64(4), events[0].Failures)
+			assert.Equal(t, EventUnlocked, events[1].Type)
+			assert.Equal(t, account, events[1].Key)
+		}
+	})
+
+	t.Run("按IP锁定", func(t *testing.T) {
+		ip := "10.0.0.2"
+		//同一个IP猜测不同的账号
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, g.Fail(ctx, time.Now().Format("150405.000000000")+"@test.com", ip))
+		}
+		err := g.Fail(ctx, "last@test.com", ip)
+		var e *Error
+		if assert.True(t, errors.As(err, &e)) {
+			assert.Equal(t, ScopeIP, e.Scope)
+		}
+		err = g.Check(ctx, "other@test.com", ip)
+		assert.True(t, errors.Is(err, ErrLocked))
+		assert.NoError(t, g.Check(ctx, "other@test.com", "10.0.0.3"))
+	})
+}
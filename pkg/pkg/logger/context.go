@@ -0,0 +1,33 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+//请求日志中的字段名
+const (
+	FieldRequestID = "request_id"
+	FieldTraceID   = "trace_id"
+	FieldUserID    = "user_id"
+	FieldRoute     = "route"
+	FieldClientIP  = "client_ip"
+)
+
+type loggerKey struct{}
+
+//NewContext 把logger放入ctx，db、cache等下层通过 FromContext 取出，日志带上同样的请求字段
+func NewContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+//FromContext 获取ctx中的logger，没有时返回 zap.L()
+func FromContext(ctx context.Context) *zap.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok && logger != nil {
+			return logger
+		}
+	}
+	return zap.L()
+}
@@ -0,0 +1,24 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, zap.L(), FromContext(context.Background()))
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core).With(zap.String(FieldRequestID, "req-1"))
+	ctx := NewContext(context.Background(), logger)
+
+	FromContext(ctx).Named("db").Info("query")
+	entry := logs.All()[0]
+	assert.Equal(t, "db", entry.LoggerName)
+	assert.Equal(t, "req-1", entry.ContextMap()[FieldRequestID])
+}
@@ -0,0 +1,169 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+//Levels 运行时可以修改的日志级别
+//根级别对所有logger生效，模块级别对 logger.Named(module) 得到的logger生效，
+//模块名按前缀匹配：设置了 db 时 db.gorm 也使用db的级别
+//  levels.SetModuleLevel("db", zapcore.DebugLevel) //db模块输出DEBUG，其他模块不变
+type Levels struct {
+	root zap.AtomicLevel
+
+	mu      sync.RWMutex
+	modules map[string]zapcore.Level
+}
+
+func NewLevels(level zapcore.Level) *Levels {
+	return &Levels{
+		root:    zap.NewAtomicLevelAt(level),
+		modules: make(map[string]zapcore.Level),
+	}
+}
+
+//ParseLevel 解析配置中的日志级别 DEBUG INFO WARN ERROR，不区分大小写
+func ParseLevel(text string) (zapcore.Level, error) {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(strings.ToLower(text))); err != nil {
+		return level, fmt.Errorf("无效的日志级别:%s", text)
+	}
+	return level, nil
+}
+
+func (l *Levels) Level() zapcore.Level {
+	return l.root.Level()
+}
+
+func (l *Levels) SetLevel(level zapcore.Level) {
+	l.root.SetLevel(level)
+}
+
+//SetModuleLevel 单独设置模块的级别
+func (l *Levels) SetModuleLevel(module string, level zapcore.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.modules[module] = level
+}
+
+//ResetModuleLevel 取消模块级别，恢复使用根级别
+func (l *Levels) ResetModuleLevel(module string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.modules, module)
+}
+
+//SetModuleLevels 整体替换模块级别，用于配置热更新
+func (l *Levels) SetModuleLevels(modules map[string]zapcore.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.modules = make(map[string]zapcore.Level, len(modules))
+	for module, level := range modules {
+		l.modules[module] = level
+	}
+}
+
+//ModuleLevels 当前设置的模块级别
+func (l *Levels) ModuleLevels() map[string]zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	modules := make(map[string]zapcore.Level, len(l.modules))
+	for module, level := range l.modules {
+		modules[module] = level
+	}
+	return modules
+}
+
+//Enabled 判断模块是否输出该级别的日志
+func (l *Levels) Enabled(module string, level zapcore.Level) bool {
+	return level >= l.moduleLevel(module)
+}
+
+func (l *Levels) moduleLevel(module string) zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.modules) > 0 {
+		//由长到短匹配 a.b.c -> a.b -> a
+		for name := module; name != ""; {
+			if level, ok := l.modules[name]; ok {
+				return level
+			}
+			i := strings.LastIndexByte(name, '.')
+			if i < 0 {
+				break
+			}
+			name = name[:i]
+		}
+	}
+	return l.root.Level()
+}
+
+//minLevel 根级别和全部模块级别中最低的级别
+func (l *Levels) minLevel() zapcore.Level {
+	min := l.root.Level()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, level := range l.modules {
+		if level < min {
+			min = level
+		}
+	}
+	return min
+}
+
+//String 用于日志输出 root=error db=debug
+func (l *Levels) String() string {
+	modules := l.ModuleLevels()
+	names := make([]string, 0, len(modules))
+	for module := range modules {
+		names = append(names, module)
+	}
+	sort.Strings(names)
+
+	b := strings.Builder{}
+	b.WriteString("root=" + l.Level().String())
+	for _, module := range names {
+		b.WriteString(" " + module + "=" + modules[module].String())
+	}
+	return b.String()
+}
+
+//Unfiltered 不按 Levels 过滤级别的logger，用于修改日志级别这类必须保留的记录，各个输出自身的级别仍然生效
+func Unfiltered(l *zap.Logger) *zap.Logger {
+	return l.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		if c, ok := core.(*levelCore); ok {
+			return c.Core
+		}
+		return core
+	}))
+}
+
+//levelCore 按logger名称(模块)过滤级别，内部的core不再做级别判断
+type levelCore struct {
+	zapcore.Core
+	levels *Levels
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return level >= c.levels.minLevel()
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{
+		Core:   c.Core.With(fields),
+		levels: c.levels,
+	}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.levels.Enabled(ent.LoggerName, ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
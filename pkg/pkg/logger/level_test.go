@@ -0,0 +1,66 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLevels_Module(t *testing.T) {
+	levels := NewLevels(zapcore.ErrorLevel)
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(&levelCore{Core: core, levels: levels})
+	dbLogger := logger.Named("db")
+	gormLogger := dbLogger.Named("gorm")
+
+	logger.Info("root info")
+	dbLogger.Debug("db debug")
+	assert.Zero(t, logs.Len())
+
+	//只调整db模块，子模块db.gorm也生效
+	levels.SetModuleLevel("db", zapcore.DebugLevel)
+	logger.Info("root info")
+	dbLogger.Debug("db debug")
+	gormLogger.Debug("gorm debug")
+	assert.Equal(t, []string{"db debug", "gorm debug"}, messages(logs))
+	assert.Equal(t, "root=error db=debug", levels.String())
+
+	//运行时调整根级别
+	levels.ResetModuleLevel("db")
+	levels.SetLevel(zapcore.InfoLevel)
+	logger.Info("root info")
+	dbLogger.Debug("db debug")
+	assert.Equal(t, []string{"db debug", "gorm debug", "root info"}, messages(logs))
+}
+
+func TestUnfiltered(t *testing.T) {
+	levels := NewLevels(zapcore.ErrorLevel)
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(&levelCore{Core: core, levels: levels}).With(zap.String("request_id", "req-1"))
+
+	logger.Warn("filtered")
+	Unfiltered(logger).Warn("kept")
+	if assert.Equal(t, []string{"kept"}, messages(logs)) {
+		assert.Equal(t, "req-1", logs.All()[0].ContextMap()["request_id"])
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, zapcore.WarnLevel, level)
+
+	_, err = ParseLevel("VERBOSE")
+	assert.Error(t, err)
+}
+
+func messages(logs *observer.ObservedLogs) []string {
+	result := make([]string, 0, logs.Len())
+	for _, entry := range logs.All() {
+		result = append(result, entry.Message)
+	}
+	return result
+}
@@ -3,10 +3,7 @@ package logger
 import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
-	"io"
 	"os"
-	"path/filepath"
 	"time"
 )
 
@@ -21,10 +18,15 @@ type Option func(*option)
 type option struct {
 	level          zapcore.Level
 	fields         map[string]string
-	file           io.Writer
+	sinks          []*sink
+	console        *sink
+	sampling       *sampling
 	timeLayout     string
 	disableConsole bool
 	printJson      bool
+	levels         *Levels
+	masking        *Masking
+	disableMasking bool
 }
 
 //设置option的日志level为zaocore.DebugLevel
@@ -54,6 +56,27 @@ func WithErrorLevel() Option {
 	}
 }
 
+//WithLevels 使用可在运行时修改的日志级别，设置后With*Level不再生效
+func WithLevels(levels *Levels) Option {
+	return func(opt *option) {
+		opt.levels = levels
+	}
+}
+
+//WithMasking 使用指定的脱敏器，默认使用 DefaultMasking()
+func WithMasking(masking *Masking) Option {
+	return func(opt *option) {
+		opt.masking = masking
+	}
+}
+
+//WithDisableMasking 关闭日志脱敏
+func WithDisableMasking() Option {
+	return func(opt *option) {
+		opt.disableMasking = true
+	}
+}
+
 //添加字段到log
 func WithField(key, value string) Option {
 	return func(opt *option) {
@@ -61,36 +84,45 @@ func WithField(key, value string) Option {
 	}
 }
 
-//指定log的写入文件： os.Writer
-func WithFileP(file string) Option {
-	dir := filepath.Dir(file)
-	if err := os.Mkdir(dir, 0766); err != nil {
-		panic(err)
+//指定log的写入文件，可以和控制台同时输出
+func WithFileP(file string, opts ...SinkOption) Option {
+	s := newSink(zapcore.DebugLevel, opts)
+	s.writer = mustOpenFile(file)
+	return func(opt *option) {
+		opt.sinks = append(opt.sinks, s)
 	}
-	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0766)
-	if err != nil {
-		panic(err)
+}
+
+//通过lumberjack指定文件自动切割和备份日志，切割参数默认 DefaultRotation
+func WithFileRotationP(file string, opts ...SinkOption) Option {
+	s := newSink(zapcore.DebugLevel, opts)
+	s.writer = rotationWriter(file, s.rotation)
+	return func(opt *option) {
+		opt.sinks = append(opt.sinks, s)
 	}
+}
+
+//WithErrorFileRotationP 单独把error及以上级别的日志写入file
+func WithErrorFileRotationP(file string, opts ...SinkOption) Option {
+	s := newSink(zapcore.ErrorLevel, opts)
+	s.writer = rotationWriter(file, s.rotation)
 	return func(opt *option) {
-		opt.file = f
+		opt.sinks = append(opt.sinks, s)
 	}
 }
 
-//通过lumberjack指定文件自动切割和备份日志
-func WithFileRotationP(file string) Option {
-	dir := filepath.Dir(file)
-	if err := os.Mkdir(dir, 0766); err != nil {
-		panic(err)
+//WithConsole 设置控制台输出的级别和格式，error及以上输出到stderr，其余输出到stdout
+func WithConsole(opts ...SinkOption) Option {
+	return func(opt *option) {
+		opt.console = newSink(zapcore.DebugLevel, opts)
 	}
+}
+
+//WithSampling 开启采样，防止大量重复日志刷屏
+//每个tick内相同级别和内容的日志先输出initial条，之后每thereafter条输出一条
+func WithSampling(tick time.Duration, initial, thereafter int) Option {
 	return func(opt *option) {
-		opt.file = &lumberjack.Logger{
-			Filename:   file,
-			MaxSize:    128, //单个文件最大尺寸,默认单位M
-			MaxAge:     30,  //最长保留时间，单位day
-			MaxBackups: 300, //最多多少个日志
-			LocalTime:  true,
-			Compress:   true, //是否压缩
-		}
+		opt.sampling = &sampling{tick: tick, initial: initial, thereafter: thereafter}
 	}
 }
 
@@ -147,37 +179,53 @@ func New(opts ...Option) (*zap.Logger, error) {
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 	}
 
-	//json解码器
-	jsonEncoder := zapcore.NewJSONEncoder(encodeConfig)
-	if !opt.printJson {
-		jsonEncoder = zapcore.NewConsoleEncoder(encodeConfig)
+	//级别由levelCore统一判断，各个输出只按自己的最低级别过滤
+	levels := opt.levels
+	if levels == nil {
+		levels = NewLevels(opt.level)
 	}
 
-	lowPrioity := zap.LevelEnablerFunc(func(level zapcore.Level) bool {
-		return level >= opt.level && level < zapcore.ErrorLevel
-	})
-
-	highProioity := zap.LevelEnablerFunc(func(level zapcore.Level) bool {
-		return level >= opt.level && level >= zap.ErrorLevel
-	})
+	masking := opt.masking
+	if masking == nil {
+		masking = DefaultMasking()
+	}
+	if opt.disableMasking {
+		masking = nil
+	}
 
 	stdout := zapcore.Lock(os.Stdout)
 	stderr := zapcore.Lock(os.Stderr)
 
-	core := zapcore.NewTee()
-
+	cores := make([]zapcore.Core, 0, len(opt.sinks)+2)
 	if !opt.disableConsole {
-		core = zapcore.NewTee(
-			zapcore.NewCore(jsonEncoder, zapcore.NewMultiWriteSyncer(stdout), lowPrioity),
-			zapcore.NewCore(jsonEncoder, zapcore.NewMultiWriteSyncer(stderr), highProioity),
-		)
-	} else {
-		core = zapcore.NewTee(
-			zapcore.NewCore(jsonEncoder, zapcore.AddSync(opt.file), zap.LevelEnablerFunc(func(level zapcore.Level) bool {
-				return level >= opt.level
-			})),
+		console := opt.console
+		if console == nil {
+			console = newSink(zapcore.DebugLevel, nil)
+		}
+		encoder := console.encoder(encodeConfig, opt.printJson)
+
+		lowPrioity := zap.LevelEnablerFunc(func(level zapcore.Level) bool {
+			return level >= console.level && level < zapcore.ErrorLevel
+		})
+
+		highProioity := zap.LevelEnablerFunc(func(level zapcore.Level) bool {
+			return level >= console.level && level >= zap.ErrorLevel
+		})
+
+		cores = append(cores,
+			newMaskCore(zapcore.NewCore(encoder, stdout, lowPrioity), masking),
+			newMaskCore(zapcore.NewCore(encoder, stderr, highProioity), masking),
 		)
 	}
+	for _, s := range opt.sinks {
+		cores = append(cores, newMaskCore(zapcore.NewCore(s.encoder(encodeConfig, opt.printJson), s.writer, s.level), masking))
+	}
+
+	core := zapcore.NewTee(cores...)
+	if opt.sampling != nil {
+		core = zapcore.NewSamplerWithOptions(core, opt.sampling.tick, opt.sampling.initial, opt.sampling.thereafter)
+	}
+	core = &levelCore{Core: core, levels: levels}
 	logger := zap.New(core, zap.AddCaller(), zap.ErrorOutput(stderr))
 
 	for key, value := range opt.fields {
@@ -218,6 +266,7 @@ func NewMeta(key string, value interface{}) Meta {
 	return &meta{key: key, value: value}
 }
 
+//WarpMeta 把err和metas转换为zap的field，meta的值按 DefaultMasking() 脱敏
 func WarpMeta(err error, metas ...Meta) (fields []zap.Field) {
 	capacity := len(metas) + 1
 	if err != nil {
@@ -228,9 +277,10 @@ func WarpMeta(err error, metas ...Meta) (fields []zap.Field) {
 		fields = append(fields, zap.Error(err))
 	}
 
+	masking := DefaultMasking()
 	fields = append(fields, zap.Namespace("meta"))
 	for _, meta := range metas {
-		fields = append(fields, zap.Any(meta.Key(), meta.Value()))
+		fields = append(fields, zap.Any(meta.Key(), masking.MaskValue(meta.Key(), meta.Value())))
 	}
 	return
 }
@@ -0,0 +1,344 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+//Masking 日志脱敏
+//按字段名脱敏：password token secret phone id_card bank_card 等，字段名不区分大小写且忽略 _ -
+//按正则脱敏：没有命中字段名的字符串值中出现的手机号、身份证号
+//对WarpMeta的meta、zap的field(包括map/struct中的字段)以及access log中的请求体生效
+
+//Masker 脱敏函数，返回脱敏后的值
+type Masker func(value string) string
+
+const _Redacted = "******"
+
+//Redact 全部隐藏
+func Redact(string) string {
+	return _Redacted
+}
+
+//MaskMiddle 保留前front位和后back位，长度不够时全部隐藏
+func MaskMiddle(front, back int) Masker {
+	return func(value string) string {
+		runes := []rune(value)
+		if len(runes) <= front+back {
+			return _Redacted
+		}
+		return string(runes[:front]) + strings.Repeat("*", len(runes)-front-back) + string(runes[len(runes)-back:])
+	}
+}
+
+//MaskPhone 13812345678 -> 138****5678
+var MaskPhone = MaskMiddle(3, 4)
+
+//MaskIDCard 110101199001011234 -> 110***********1234
+var MaskIDCard = MaskMiddle(3, 4)
+
+//MaskBankCard 只保留后4位
+var MaskBankCard = MaskMiddle(0, 4)
+
+//MaskEmail foo@example.com -> f**@example.com
+func MaskEmail(value string) string {
+	i := strings.LastIndexByte(value, '@')
+	if i <= 0 {
+		return Redact(value)
+	}
+	name := value[:i]
+	first, size := utf8.DecodeRuneInString(name)
+	return string(first) + strings.Repeat("*", utf8.RuneCountInString(name[size:])) + value[i:]
+}
+
+type pattern struct {
+	re     *regexp.Regexp
+	masker Masker
+}
+
+type Masking struct {
+	mu       sync.RWMutex
+	keys     map[string]Masker
+	patterns []pattern
+}
+
+//NewMasking 创建带默认规则的脱敏器
+func NewMasking() *Masking {
+	m := &Masking{
+		keys: make(map[string]Masker),
+	}
+	for _, key := range []string{"password", "pwd", "passwd", "new_password", "old_password", "密码",
+		"token", "access_token", "refresh_token", "mfa_token", "authorization", "secret", "client_secret", "verify_code", "sms_code", "otp_code"} {
+		m.RegisterKey(key, Redact)
+	}
+	for _, key := range []string{"phone", "mobile", "手机号"} {
+		m.RegisterKey(key, MaskPhone)
+	}
+	for _, key := range []string{"id_card", "id_number", "身份证"} {
+		m.RegisterKey(key, MaskIDCard)
+	}
+	for _, key := range []string{"bank_card", "card_no", "银行卡"} {
+		m.RegisterKey(key, MaskBankCard)
+	}
+	m.RegisterKey("email", MaskEmail)
+
+	_ = m.RegisterPattern(`\b1[3-9]\d{9}\b`, MaskPhone)
+	_ = m.RegisterPattern(`\b[1-9]\d{5}(?:19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`, MaskIDCard)
+	return m
+}
+
+func normalizeKey(key string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+}
+
+//RegisterKey 注册字段名的脱敏函数，已存在时覆盖
+func (m *Masking) RegisterKey(key string, masker Masker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[normalizeKey(key)] = masker
+}
+
+//WithKeys 复制一份脱敏器并追加字段名规则，原脱敏器不变，用于只在部分场景需要脱敏的通用字段名
+func (m *Masking) WithKeys(masker Masker, keys ...string) *Masking {
+	m.mu.RLock()
+	c := &Masking{
+		keys:     make(map[string]Masker, len(m.keys)+len(keys)),
+		patterns: append([]pattern(nil), m.patterns...),
+	}
+	for key, masker := range m.keys {
+		c.keys[key] = masker
+	}
+	m.mu.RUnlock()
+	for _, key := range keys {
+		c.keys[normalizeKey(key)] = masker
+	}
+	return c
+}
+
+//RegisterPattern 注册正则脱敏规则，匹配到的内容交给masker处理
+func (m *Masking) RegisterPattern(expr string, masker Masker) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.patterns = append(m.patterns, pattern{re: re, masker: masker})
+	return nil
+}
+
+func (m *Masking) keyMasker(key string) (Masker, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	masker, ok := m.keys[normalizeKey(key)]
+	return masker, ok
+}
+
+//MaskString 按正则脱敏
+func (m *Masking) MaskString(s string) string {
+	m.mu.RLock()
+	patterns := m.patterns
+	m.mu.RUnlock()
+	for _, p := range patterns {
+		s = p.re.ReplaceAllStringFunc(s, p.masker)
+	}
+	return s
+}
+
+//MaskValue 按字段名脱敏，字段名没有注册时按正则脱敏字符串
+func (m *Masking) MaskValue(key string, value interface{}) interface{} {
+	if masker, ok := m.keyMasker(key); ok {
+		return masker(fmt.Sprint(value))
+	}
+	switch v := value.(type) {
+	case string:
+		return m.MaskString(v)
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return value
+	case error:
+		return m.MaskString(v.Error())
+	case fmt.Stringer:
+		return m.MaskString(v.String())
+	default:
+		//map、struct等先转为json再逐个字段脱敏
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return value
+		}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var decoded interface{}
+		if err := decoder.Decode(&decoded); err != nil {
+			return value
+		}
+		return m.maskJSONValue("", decoded)
+	}
+}
+
+//MaskField 脱敏zap的field
+func (m *Masking) MaskField(f zapcore.Field) zapcore.Field {
+	if f.Type == zapcore.NamespaceType || f.Type == zapcore.SkipType {
+		return f
+	}
+	if masker, ok := m.keyMasker(f.Key); ok {
+		return zap.String(f.Key, masker(fieldString(f)))
+	}
+
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = m.MaskString(f.String)
+	case zapcore.ByteStringType:
+		if raw, ok := f.Interface.([]byte); ok {
+			f.Interface = []byte(m.MaskString(string(raw)))
+		}
+	case zapcore.StringerType, zapcore.ErrorType:
+		s := fieldString(f)
+		if masked := m.MaskString(s); masked != s {
+			return zap.String(f.Key, masked)
+		}
+	case zapcore.ReflectType:
+		return zap.Any(f.Key, m.MaskValue(f.Key, f.Interface))
+	}
+	return f
+}
+
+func (m *Masking) MaskFields(fields []zapcore.Field) []zapcore.Field {
+	if len(fields) == 0 {
+		return fields
+	}
+	masked := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		masked[i] = m.MaskField(f)
+	}
+	return masked
+}
+
+//MaskJSON 脱敏json，解析失败时按正则脱敏整个字符串
+func (m *Masking) MaskJSON(raw []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return []byte(m.MaskString(string(raw)))
+	}
+	masked, err := json.Marshal(m.maskJSONValue("", value))
+	if err != nil {
+		return []byte(m.MaskString(string(raw)))
+	}
+	return masked
+}
+
+func (m *Masking) maskJSONValue(key string, value interface{}) interface{} {
+	if key != "" {
+		if masker, ok := m.keyMasker(key); ok {
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				return _Redacted
+			}
+			return masker(fmt.Sprint(value))
+		}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = m.maskJSONValue(k, item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = m.maskJSONValue(key, item)
+		}
+		return v
+	case string:
+		return m.MaskString(v)
+	default:
+		return value
+	}
+}
+
+//MaskBody 脱敏请求体，支持json和表单
+func (m *Masking) MaskBody(contentType string, body []byte) string {
+	switch {
+	case strings.Contains(contentType, "json"):
+		return string(m.MaskJSON(body))
+	case strings.Contains(contentType, "x-www-form-urlencoded"):
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			break
+		}
+		for key, items := range values {
+			for i, item := range items {
+				items[i] = fmt.Sprint(m.MaskValue(key, item))
+			}
+			values[key] = items
+		}
+		return values.Encode()
+	}
+	return m.MaskString(string(body))
+}
+
+func fieldString(f zapcore.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+	return fmt.Sprint(enc.Fields[f.Key])
+}
+
+var (
+	maskingMu      sync.RWMutex
+	defaultMasking = NewMasking()
+)
+
+//SetMasking 替换全局脱敏器，New 和 WarpMeta 默认使用全局脱敏器
+func SetMasking(m *Masking) {
+	maskingMu.Lock()
+	defer maskingMu.Unlock()
+	defaultMasking = m
+}
+
+//DefaultMasking 获取全局脱敏器
+func DefaultMasking() *Masking {
+	maskingMu.RLock()
+	defer maskingMu.RUnlock()
+	return defaultMasking
+}
+
+//maskCore 在写入前脱敏，包装在最终输出的core外层
+type maskCore struct {
+	zapcore.Core
+	masking *Masking
+}
+
+func newMaskCore(core zapcore.Core, masking *Masking) zapcore.Core {
+	if masking == nil {
+		return core
+	}
+	return &maskCore{Core: core, masking: masking}
+}
+
+func (c *maskCore) With(fields []zapcore.Field) zapcore.Core {
+	return &maskCore{
+		Core:    c.Core.With(c.masking.MaskFields(fields)),
+		masking: c.masking,
+	}
+}
+
+func (c *maskCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *maskCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = c.masking.MaskString(ent.Message)
+	return c.Core.Write(ent, c.masking.MaskFields(fields))
+}
@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMasking_Field(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(newMaskCore(core, NewMasking())).With(zap.String("token", "abc"))
+
+	logger.Info("用户13812345678登录",
+		zap.String("Password", "123456"),
+		zap.Int64("phone", 13812345678),
+		zap.String("remark", "身份证110101199001011234"),
+		zap.Error(errors.New("手机号13812345678已注册")),
+		zap.Any("user", map[string]interface{}{"name": "tom", "bank_card": "6222020200001234"}),
+	)
+
+	entry := logs.All()[0]
+	assert.Equal(t, "用户138****5678登录", entry.Message)
+	fields := entry.ContextMap()
+	assert.Equal(t, "******", fields["token"])
+	assert.Equal(t, "******", fields["Password"])
+	assert.Equal(t, "138****5678", fields["phone"])
+	assert.Equal(t, "身份证110***********1234", fields["remark"])
+	assert.Equal(t, "手机号138****5678已注册", fields["error"])
+	assert.Equal(t, map[string]interface{}{"name": "tom", "bank_card": "************1234"}, fields["user"])
+}
+
+func TestMasking_WarpMeta(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+
+	logger.Info("login", WarpMeta(nil, NewMeta("密码", "123456"), NewMeta("email", "foo@example.com"))...)
+	fields := logs.All()[0].ContextMap()["meta"].(map[string]interface{})
+	assert.Equal(t, "******", fields["密码"])
+	assert.Equal(t, "f**@example.com", fields["email"])
+}
+
+func TestMasking_Custom(t *testing.T) {
+	masking := NewMasking()
+	masking.RegisterKey("nick_name", MaskMiddle(1, 0))
+	assert.NoError(t, masking.RegisterPattern(`sk-[a-z0-9]+`, Redact))
+
+	assert.Equal(t, "张**", masking.MaskValue("nickName", "张小明"))
+	assert.Equal(t, "key=******", masking.MaskString("key=sk-abc123"))
+	assert.Error(t, masking.RegisterPattern(`(`, Redact))
+
+	//WithKeys 不影响原脱敏器
+	withCode := masking.WithKeys(Redact, "code")
+	assert.Equal(t, "******", withCode.MaskValue("code", "123456"))
+	assert.Equal(t, "张**", withCode.MaskValue("nickName", "张小明"))
+	assert.Equal(t, "123456", masking.MaskValue("code", "123456"))
+}
+
+func TestMasking_Body(t *testing.T) {
+	masking := NewMasking()
+	assert.JSONEq(t,
+		`{"username":"tom","password":"******","profile":{"mobile":"138****5678"},"items":[{"access_token":"******"}]}`,
+		masking.MaskBody("application/json", []byte(`{"username":"tom","password":"123456","profile":{"mobile":"13812345678"},"items":[{"access_token":"x"}]}`)),
+	)
+	assert.Equal(t, "password=%2A%2A%2A%2A%2A%2A&username=tom",
+		masking.MaskBody("application/x-www-form-urlencoded", []byte("username=tom&password=123456")))
+	assert.Equal(t, "call 138****5678", masking.MaskBody("text/plain", []byte("call 13812345678")))
+}
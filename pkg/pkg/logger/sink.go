@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+//Encoding 日志输出格式
+type Encoding string
+
+const (
+	//EncodingDefault 跟随 WithJsonFormat
+	EncodingDefault Encoding = ""
+	EncodingJson    Encoding = "json"
+	EncodingConsole Encoding = "console"
+)
+
+//Rotation 日志文件切割参数
+type Rotation struct {
+	MaxSize    int  //单个文件最大尺寸,单位M
+	MaxAge     int  //最长保留时间，单位day
+	MaxBackups int  //最多保留多少个日志
+	Compress   bool //是否压缩
+}
+
+//DefaultRotation 默认切割参数
+var DefaultRotation = Rotation{
+	MaxSize:    128,
+	MaxAge:     30,
+	MaxBackups: 300,
+	Compress:   true,
+}
+
+//sink 一个日志输出，有独立的最低级别和格式
+type sink struct {
+	writer   zapcore.WriteSyncer
+	level    zapcore.Level
+	encoding Encoding
+	rotation Rotation
+}
+
+//SinkOption 设置单个输出
+type SinkOption func(*sink)
+
+//SinkLevel 该输出的最低级别，在全局级别之上再过滤
+func SinkLevel(level zapcore.Level) SinkOption {
+	return func(s *sink) {
+		s.level = level
+	}
+}
+
+//SinkEncoding 该输出的格式
+func SinkEncoding(encoding Encoding) SinkOption {
+	return func(s *sink) {
+		s.encoding = encoding
+	}
+}
+
+//SinkRotation 文件切割参数，只对 WithFileRotationP 和 WithErrorFileRotationP 生效
+func SinkRotation(rotation Rotation) SinkOption {
+	return func(s *sink) {
+		s.rotation = rotation
+	}
+}
+
+func newSink(level zapcore.Level, opts []SinkOption) *sink {
+	s := &sink{
+		level:    level,
+		rotation: DefaultRotation,
+	}
+	for _, f := range opts {
+		f(s)
+	}
+	return s
+}
+
+func (s *sink) encoder(config zapcore.EncoderConfig, printJson bool) zapcore.Encoder {
+	switch s.encoding {
+	case EncodingJson:
+		return zapcore.NewJSONEncoder(config)
+	case EncodingConsole:
+		return zapcore.NewConsoleEncoder(config)
+	}
+	if printJson {
+		return zapcore.NewJSONEncoder(config)
+	}
+	return zapcore.NewConsoleEncoder(config)
+}
+
+//mustMkdir 创建日志文件所在目录
+func mustMkdir(file string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0766); err != nil {
+		panic(err)
+	}
+}
+
+func mustOpenFile(file string) zapcore.WriteSyncer {
+	mustMkdir(file)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0766)
+	if err != nil {
+		panic(err)
+	}
+	return zapcore.AddSync(f)
+}
+
+func rotationWriter(file string, rotation Rotation) zapcore.WriteSyncer {
+	mustMkdir(file)
+	return zapcore.AddSync(&lumberjack.Logger{
+		Filename:   file,
+		MaxSize:    rotation.MaxSize,
+		MaxAge:     rotation.MaxAge,
+		MaxBackups: rotation.MaxBackups,
+		LocalTime:  true,
+		Compress:   rotation.Compress,
+	})
+}
+
+//sampling 相同级别和内容的日志每个tick内先输出initial条，之后每thereafter条输出一条
+type sampling struct {
+	tick       time.Duration
+	initial    int
+	thereafter int
+}
@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNew_Sinks(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "nested", "app.log")
+	errorFile := filepath.Join(dir, "nested", "app.error.log")
+	textFile := filepath.Join(dir, "app.txt")
+
+	logger, err := New(
+		WithDisableConsole(),
+		WithDebugLevel(),
+		WithFileRotationP(file, SinkEncoding(EncodingJson), SinkLevel(zapcore.InfoLevel), SinkRotation(Rotation{MaxSize: 1})),
+		WithErrorFileRotationP(errorFile, SinkEncoding(EncodingJson)),
+		WithFileP(textFile, SinkEncoding(EncodingConsole)),
+	)
+	assert.NoError(t, err)
+
+	logger.Debug("debug message")
+	logger.Info("info message")
+	logger.Error("error message")
+	assert.NoError(t, logger.Sync())
+
+	content := readFile(t, file)
+	assert.NotContains(t, content, "debug message")
+	assert.Contains(t, content, `"msg":"info message"`)
+	assert.Contains(t, content, `"msg":"error message"`)
+
+	content = readFile(t, errorFile)
+	assert.NotContains(t, content, "info message")
+	assert.Contains(t, content, `"msg":"error message"`)
+
+	content = readFile(t, textFile)
+	assert.Contains(t, content, "debug message")
+	assert.NotContains(t, content, `"msg"`)
+}
+
+func TestNew_Sampling(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	logger, err := New(
+		WithDisableConsole(),
+		WithFileP(file),
+		WithSampling(time.Minute, 2, 0),
+	)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		logger.Info("flood")
+	}
+	logger.Info("other")
+	assert.NoError(t, logger.Sync())
+
+	content := readFile(t, file)
+	assert.Equal(t, 2, strings.Count(content, "flood"))
+	assert.Contains(t, content, "other")
+}
+
+func readFile(t *testing.T, file string) string {
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	return string(content)
+}
@@ -0,0 +1,161 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/cast"
+	"github/xujialingit/shopping-app/pkg/cache"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"time"
+)
+
+/*
+黑名单方式的登录
+只签发access token，注销时把token的jti写入黑名单，过期时间为token剩余的有效期
+注销全部设备时记录用户的注销时间（毫秒），在这之前签发的token全部失效
+iat只精确到秒，签发时在ext中另外写入毫秒时间，避免注销后同一秒内重新登录的token也失效
+*/
+
+var _ LoginTokenSystem = (*BlackListSystem)(nil)
+
+//_ClaimIssuedAtMs 毫秒精度的签发时间
+const _ClaimIssuedAtMs = "iat_ms"
+
+type BlackListConfig struct {
+	Secret         string        `json:"secret"`
+	ExpireDuration time.Duration `json:"expire_duration"`
+}
+
+type BlackListSystem struct {
+	cfg *BlackListConfig
+
+	cache cache.Repo
+	opt   *option
+	now   func() time.Time
+}
+
+//NewByBlackList 校验token时需要再调用 Verify 或 CheckBlackList
+func NewByBlackList(cfg *BlackListConfig, repo cache.Repo, options ...Option) *BlackListSystem {
+	return &BlackListSystem{
+		cfg:   cfg,
+		cache: repo,
+		opt:   newOption(cfg.Secret, options),
+		now:   time.Now,
+	}
+}
+
+func (r *BlackListSystem) tokenKey(jti string) string {
+	return fmt.Sprintf("%s:jti:%s", model.RedisBlackListKeyPrefix, jti)
+}
+
+//userKey 保存用户注销全部设备的时间
+func (r *BlackListSystem) userKey(userId int64) string {
+	return fmt.Sprintf("%s:user:%d", model.RedisBlackListKeyPrefix, userId)
+}
+
+//生成token
+func (r *BlackListSystem) GenerateToken(ctx context.Context, userId int, userName string) (*model.LoginResponse, error) {
+	claims := &token.Claims{
+		UserID:   int64(userId),
+		UserName: userName,
+	}
+	claims.Set(_ClaimIssuedAtMs, r.now().UnixMilli())
+	accessToken, err := r.opt.token().Sign(claims, r.cfg.ExpireDuration)
+	if err != nil {
+		return nil, err
+	}
+	return &model.LoginResponse{Token: &model.LoginResponseByBlackList{
+		AccessToken: accessToken,
+	}}, nil
+}
+
+//TokenCancel 注销单个token，已经过期的token不需要写入黑名单
+func (r *BlackListSystem) TokenCancel(ctx context.Context, accessToken string) error {
+	claims, err := r.opt.token().JwtParse(accessToken)
+	if err != nil {
+		if errors.Is(err, token.ErrorTokenExpiredOrNotActive) {
+			return nil
+		}
+		return err
+	}
+	return r.revoke(ctx, claims)
+}
+
+//revoke 黑名单保留到token过期之后再加上leeway，期间验证仍会接受这个token
+func (r *BlackListSystem) revoke(ctx context.Context, claims *token.Claims) error {
+	ttl := time.Unix(claims.ExpiresAt, 0).Sub(r.now()) + r.opt.token().Leeway()
+	if ttl <= 0 {
+		return nil
+	}
+	return r.cache.Set(ctx, r.tokenKey(claims.Id), "1", ttl)
+}
+
+//ToeknCancelById 注销用户在所有设备上的token，userName 不再使用，保留是为了兼容接口
+//记录的时间保留 ExpireDuration + leeway，之后之前签发的token都已经过期
+func (r *BlackListSystem) ToeknCancelById(ctx context.Context, userId int, userName string) error {
+	ttl := r.cfg.ExpireDuration + r.opt.token().Leeway()
+	return r.cache.Set(ctx, r.userKey(int64(userId)), cast.ToString(r.now().UnixMilli()), ttl)
+}
+
+//RefreshToken 黑名单方式没有刷新token，使用未过期的access token换取新的token，旧token写入黑名单
+func (r *BlackListSystem) RefreshToken(ctx context.Context, accessToken string) (*model.LoginResponse, error) {
+	claims, err := r.Verify(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.revoke(ctx, claims); err != nil {
+		return nil, err
+	}
+	return r.GenerateToken(ctx, int(claims.UserID), claims.UserName)
+}
+
+//Verify 校验签名、有效期，并检查是否在黑名单
+func (r *BlackListSystem) Verify(ctx context.Context, accessToken string) (*token.Claims, error) {
+	claims, err := r.opt.token().JwtParseFromAuthorizationHeader(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := r.revoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, model.TokenRevoked
+	}
+	return claims, nil
+}
+
+// CheckBlackList 验证时，需要验证是否在黑名单，不校验签名和有效期
+func (r *BlackListSystem) CheckBlackList(ctx context.Context, accessToken string) (bool, error) {
+	claims, err := r.opt.token().JwtParseUnsafe(accessToken)
+	if err != nil {
+		return false, fmt.Errorf("this token is unvalid ")
+	}
+	return r.revoked(ctx, claims)
+}
+
+//revoked 单个token被注销，或者在用户注销全部设备之前签发
+func (r *BlackListSystem) revoked(ctx context.Context, claims *token.Claims) (bool, error) {
+	if claims.Id != "" && r.cache.Exists(ctx, r.tokenKey(claims.Id)) {
+		return true, nil
+	}
+	before, err := r.cache.Get(ctx, r.userKey(claims.UserID))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, err
+	}
+	return issuedAtMs(claims) < cast.ToInt64(before), nil
+}
+
+//issuedAtMs 没有毫秒签发时间的旧token按iat所在秒的开始计算
+func issuedAtMs(claims *token.Claims) int64 {
+	if ms, ok := claims.Get(_ClaimIssuedAtMs); ok {
+		return cast.ToInt64(ms)
+	}
+	return claims.IssuedAt * 1000
+}
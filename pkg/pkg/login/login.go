@@ -24,8 +24,8 @@ type LoginTokenSystem interface {
 	RefreshToken(ctx context.Context, refreshToken string) (*model.LoginResponse, error)
 }
 
-func NewByRefreshToken(cfg *RefreshTokenConfig, repo cache.Repo) LoginTokenSystem {
-	return &RefreshTokenSystem{cfg: cfg, cache: repo}
+func NewByRefreshToken(cfg *RefreshTokenConfig, repo cache.Repo, options ...Option) *RefreshTokenSystem {
+	return &RefreshTokenSystem{cfg: cfg, cache: repo, opt: newOption(cfg.Secret, options)}
 }
 
 //刷新token配置
@@ -33,31 +33,82 @@ type RefreshTokenConfig struct {
 	Secret          string        `json:"secret"`
 	ExpireDuration  time.Duration `json:"expire_duration"`
 	RefreshDuration time.Duration `json:"refresh_duration"`
+	MaxSessions     int           `json:"max_sessions"` //每个用户同时登录的设备数，0为不限制，超出时注销最久未使用的
 }
 
+/*
+刷新token轮换
+每次登录生成一个family，之后刷新得到的token都属于这个family，family记录当前有效的刷新token
+刷新时通过lua原子的GET+DEL取出刷新token，并记录为已使用，并发刷新只有一个能成功
+已使用的刷新token再次出现说明被盗用，注销整个family并发出安全事件
+一个family就是一个登录会话，用户的全部会话记录在 sessions hash 中，用于列出和注销设备
+*/
 type RefreshTokenSystem struct {
 	cfg   *RefreshTokenConfig
 	cache cache.Repo
+	opt   *option
 }
 
-//生成token
+//refreshRecord 刷新token对应的用户，family为空的是轮换之前签发的token
+type refreshRecord struct {
+	UserId   int      `json:"user_id"`
+	UserName string   `json:"user_name"`
+	Family   string   `json:"family"`
+	AMR      []string `json:"amr,omitempty"` //登录使用的认证方式，刷新后保留
+}
+
+func refreshKey(refreshToken string) string {
+	return model.RedisRefreshTokenKeypRefix + refreshToken
+}
+
+func refreshUsedKey(refreshToken string) string {
+	return model.RedisRefreshTokenUsedKeyPrefix + refreshToken
+}
+
+func refreshFamilyKey(family string) string {
+	return model.RedisRefreshTokenFamilyKeyPrefix + family
+}
+
+//生成token，设备信息通过 WithDevice 放入ctx，认证方式通过 WithAuthMethods 放入ctx
 func (r *RefreshTokenSystem) GenerateToken(ctx context.Context, userId int, userName string) (*model.LoginResponse, error) {
-	assessToken, err := token.New(r.cfg.Secret).JwtSign(int64(userId), userName, r.cfg.ExpireDuration)
+	record := refreshRecord{
+		UserId:   userId,
+		UserName: userName,
+		Family:   token.NewJTI(),
+		AMR:      authMethodsFromContext(ctx),
+	}
+	resp, err := r.issue(ctx, record, r.newSession(ctx, record.Family))
 	if err != nil {
 		return nil, err
 	}
-	refreshToken := r.generateRefreshToken(r.cfg.Secret, userId, userName)
-	userClaims := struct {
-		UserId   int    `json:"user_id"`
-		UserName string `json:"user_name"`
-	}{
-		UserId:   userId,
-		UserName: userName,
+	if err := r.limitSessions(ctx, int64(userId), record.Family); err != nil {
+		return nil, err
 	}
+	return resp, nil
+}
 
-	userJson, _ := json.Marshal(userClaims)
-	err = r.cache.Set(ctx, model.RedisRefreshTokenKeypRefix+refreshToken, string(userJson), r.cfg.RefreshDuration)
-
+//issue 签发access token和刷新token，并把family指向新的刷新token，同时更新会话
+func (r *RefreshTokenSystem) issue(ctx context.Context, record refreshRecord, session *model.Session) (*model.LoginResponse, error) {
+	assessToken, err := r.opt.token().Sign(&token.Claims{
+		UserID:    int64(record.UserId),
+		UserName:  record.UserName,
+		SessionID: record.Family,
+		AMR:       record.AMR,
+	}, r.cfg.ExpireDuration)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken := r.generateRefreshToken(r.cfg.Secret, record.UserId, record.UserName)
+
+	userJson, _ := json.Marshal(record)
+	sessionJson, _ := json.Marshal(session)
+	_, err = r.cache.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, refreshKey(refreshToken), string(userJson), r.cfg.RefreshDuration)
+		pipe.Set(ctx, refreshFamilyKey(record.Family), refreshToken, r.cfg.RefreshDuration)
+		pipe.HSet(ctx, sessionsKey(int64(record.UserId)), record.Family, string(sessionJson))
+		pipe.Expire(ctx, sessionsKey(int64(record.UserId)), r.cfg.RefreshDuration)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -68,75 +119,105 @@ func (r *RefreshTokenSystem) GenerateToken(ctx context.Context, userId int, user
 	}}, nil
 }
 
+//TokenCancel 注销刷新token所在的会话
 func (r RefreshTokenSystem) TokenCancel(ctx context.Context, token string) error {
-	_ = r.cache.Del(ctx, model.RedisRefreshTokenKeypRefix+token)
+	var record refreshRecord
+	if userJson, _ := r.cache.Get(ctx, refreshKey(token)); userJson != "" {
+		_ = json.Unmarshal([]byte(userJson), &record)
+	}
+	_ = r.cache.Del(ctx, refreshKey(token))
+	if record.Family != "" {
+		return r.revokeSession(ctx, int64(record.UserId), record.Family)
+	}
 	return nil
 }
 
+//ToeknCancelById 注销用户的全部会话，userName 不再使用，保留是为了兼容接口
 func (r RefreshTokenSystem) ToeknCancelById(ctx context.Context, userId int, userName string) error {
-	refreshToken := r.generateRefreshToken(r.cfg.Secret, userId, userName)
-	return r.TokenCancel(ctx, refreshToken)
+	return r.RevokeOtherSessions(ctx, int64(userId), "")
 }
 
+const (
+	refreshNotFound = 0
+	refreshOK       = 1
+	refreshReused   = 2
+)
+
+//KEYS[1] 刷新token KEYS[2] 已使用标记 ARGV[1] 刷新token没有过期时间时已使用标记保留的毫秒数
+//返回 {状态, 刷新token对应的用户}
+var refreshScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if v then
+	local ttl = redis.call('PTTL', KEYS[1])
+	if ttl <= 0 then
+		ttl = ARGV[1]
+	end
+	redis.call('DEL', KEYS[1])
+	redis.call('SET', KEYS[2], v, 'PX', ttl)
+	return {1, v}
+end
+local used = redis.call('GET', KEYS[2])
+if used then
+	return {2, used}
+end
+return {0}
+`)
+
 //刷新token
 func (r RefreshTokenSystem) RefreshToken(ctx context.Context, refreshToken string) (*model.LoginResponse, error) {
-	userJson, err := r.cache.Get(ctx, model.RedisRefreshTokenKeypRefix+refreshToken)
+	result, err := refreshScript.Run(ctx, r.cache.Client(),
+		[]string{refreshKey(refreshToken), refreshUsedKey(refreshToken)},
+		r.cfg.RefreshDuration.Milliseconds()).Slice()
+	if err != nil {
+		return nil, err
+	}
+	status, _ := result[0].(int64)
+	if status == refreshNotFound {
+		return nil, model.RefreshTokenInvalid
+	}
 
-	if userJson == "" {
-		return nil, errors.New("刷新Token无效")
+	var record refreshRecord
+	userJson, _ := result[1].(string)
+	if err := json.Unmarshal([]byte(userJson), &record); err != nil {
+		return nil, model.RefreshTokenInvalid
 	}
-	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return nil, err
+
+	if status == refreshReused {
+		if record.Family != "" {
+			_ = r.revokeSession(ctx, int64(record.UserId), record.Family)
 		}
+		r.opt.emit(ctx, SecurityEvent{
+			Type:   SecurityEventRefreshTokenReused,
+			UserID: int64(record.UserId),
+			Family: record.Family,
+			Time:   time.Now(),
+		})
+		return nil, model.RefreshTokenReused
 	}
 
-	userClaims := struct {
-		UserId   int    `json:"user_id"`
-		UserName string `json:"user_name"`
-	}{}
-
-	_ = json.Unmarshal([]byte(userJson), &userClaims)
-	_ = r.TokenCancel(ctx, refreshToken)
-	return r.GenerateToken(ctx, userClaims.UserId, userClaims.UserName)
+	if record.Family == "" {
+		record.Family = token.NewJTI()
+		return r.issue(ctx, record, r.newSession(ctx, record.Family))
+	}
+	//family已被注销，或者已经轮换到其他刷新token
+	current, err := r.cache.Get(ctx, refreshFamilyKey(record.Family))
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	if current != refreshToken {
+		return nil, model.RefreshTokenInvalid
+	}
+	session, err := r.session(ctx, int64(record.UserId), record.Family)
+	if err != nil {
+		return nil, err
+	}
+	r.touchSession(ctx, session)
+	return r.issue(ctx, record, session)
 }
 
 //生成刷新token
 func (r *RefreshTokenSystem) generateRefreshToken(secret string, userId int, userName string) string {
 	hencrypt := hmac.New(md5.New, []byte(secret))
-	hencrypt.Write([]byte(fmt.Sprintf("%v%d_%s", time.Now().Unix(), userId, userName)))
+	hencrypt.Write([]byte(fmt.Sprintf("%v%d_%s_%s", time.Now().Unix(), userId, userName, token.NewJTI())))
 	return fmt.Sprintf("%x", hencrypt.Sum(nil))
 }
-
-type BlackListConfig struct {
-	Secret         string        `json:"secret"`
-	ExpireDuration time.Duration `json:"expire_duration"`
-}
-
-type BlackListSystem struct {
-	cfg *BlackListConfig
-
-	cache cache.Repo
-}
-
-func (r *BlackListSystem) blackListKey(userId int64, userName string) string {
-	return fmt.Sprintf("%s_%d_%s", model.RedisBlackListKeyPrefix, userId, userName)
-}
-
-// CheckBlackList 验证时，需要验证是否在黑名单
-func (r *BlackListSystem) CheckBlackList(ctx context.Context, accessToken string) (bool, error) {
-	claim, err := token.New(r.cfg.Secret).JwtParseUnsafe(accessToken)
-	if err != nil {
-		return false, fmt.Errorf("this token is unvalid ")
-	}
-	key := r.blackListKey(claim.UserID, claim.UserName)
-
-	a, err := r.cache.Get(ctx, key)
-	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return false, nil
-		}
-		return false, err
-	}
-	return a == "1", nil
-}
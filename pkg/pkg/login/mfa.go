@@ -0,0 +1,130 @@
+package login
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github/xujialingit/shopping-app/pkg/cache"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type authMethodsKey struct{}
+
+//WithAuthMethods 把登录使用的认证方式放入ctx，GenerateToken 时写入token的amr，刷新token后保留
+func WithAuthMethods(ctx context.Context, methods ...string) context.Context {
+	return context.WithValue(ctx, authMethodsKey{}, methods)
+}
+
+func authMethodsFromContext(ctx context.Context) []string {
+	methods, _ := ctx.Value(authMethodsKey{}).([]string)
+	return methods
+}
+
+//MFA配置
+type MFAConfig struct {
+	PendingDuration time.Duration `json:"pending_duration"` //mfa token有效期
+	MaxAttempts     int           `json:"max_attempts"`     //验证码允许输错的次数，超过后需要重新登录
+}
+
+/*
+两步登录
+第一步校验密码后调用 Pending 签发一个短期的 mfa token，只保存在redis中，不能用于访问接口
+第二步调用 Complete 校验验证码，通过后由 LoginTokenSystem 签发正式token，amr中增加 otp
+mfa token 只能成功使用一次，输错次数过多后失效
+*/
+type MFATokenSystem struct {
+	cfg    *MFAConfig
+	tokens LoginTokenSystem
+	cache  cache.Repo
+}
+
+func NewMFA(cfg *MFAConfig, tokens LoginTokenSystem, repo cache.Repo) *MFATokenSystem {
+	return &MFATokenSystem{cfg: cfg, tokens: tokens, cache: repo}
+}
+
+func mfaPendingKey(mfaToken string) string {
+	return model.RedisMFAPendingKeyPrefix + mfaToken
+}
+
+//Pending 第一步验证通过，签发mfa token，第一步的认证方式通过 WithAuthMethods 放入ctx
+func (m *MFATokenSystem) Pending(ctx context.Context, userId int, userName string) (*model.LoginResponse, error) {
+	mfaToken := token.NewJTI()
+	key := mfaPendingKey(mfaToken)
+	_, err := m.cache.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"user_id", userId,
+			"user_name", userName,
+			"amr", strings.Join(authMethodsFromContext(ctx), ","),
+			"attempts", 0,
+		)
+		pipe.PExpire(ctx, key, m.cfg.PendingDuration)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &model.LoginResponse{Token: &model.LoginResponseByMFA{
+		MFAToken:  mfaToken,
+		ExpiresIn: int64(m.cfg.PendingDuration / time.Second),
+	}}, nil
+}
+
+//KEYS[1] mfa token ARGV[1] 允许输错的次数
+//校验验证码前先占用一次次数，超过后删除mfa token并返回nil，否则返回 user_id user_name amr
+//占用和判断在一个脚本中完成，并发请求不能绕过次数限制
+var mfaAttemptScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return nil
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+if attempts > tonumber(ARGV[1]) then
+	redis.call('DEL', KEYS[1])
+	return nil
+end
+return redis.call('HMGET', KEYS[1], 'user_id', 'user_name', 'amr')
+`)
+
+//Complete 第二步，verify 校验用户的验证码，失败时返回verify的错误；mfa token不存在或失效时返回 model.MFATokenInvalid
+//成功时返回用户id和正式token
+func (m *MFATokenSystem) Complete(ctx context.Context, mfaToken string, verify func(ctx context.Context, userId int64) error) (int64, *model.LoginResponse, error) {
+	key := mfaPendingKey(mfaToken)
+	result, err := mfaAttemptScript.Run(ctx, m.cache.Client(), []string{key}, m.cfg.MaxAttempts).Result()
+	if err == redis.Nil {
+		return 0, nil, model.MFATokenInvalid
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	values, _ := result.([]interface{})
+	if len(values) != 3 || values[0] == nil {
+		return 0, nil, model.MFATokenInvalid
+	}
+	userId, _ := strconv.Atoi(values[0].(string))
+	userName, _ := values[1].(string)
+
+	if err := verify(ctx, int64(userId)); err != nil {
+		return 0, nil, err
+	}
+	//并发请求只有一个能删除成功
+	n, err := m.cache.Client().Del(ctx, key).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+	if n == 0 {
+		return 0, nil, model.MFATokenInvalid
+	}
+
+	var methods []string
+	if amr, _ := values[2].(string); amr != "" {
+		methods = strings.Split(amr, ",")
+	}
+	ctx = WithAuthMethods(ctx, append(methods, token.AMROTP)...)
+	resp, err := m.tokens.GenerateToken(ctx, userId, userName)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int64(userId), resp, nil
+}
@@ -1,14 +1,21 @@
 package model
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type LoginResponse struct {
-	Token interface{} `json:"token""`
+	Token interface{} `json:"token"`
 }
 
 const (
-	RedisRefreshTokenKeypRefix = "sx:refresh"
-	RedisBlackListKeyPrefix    = "sk:black_list"
+	RedisRefreshTokenKeypRefix       = "sx:refresh"
+	RedisRefreshTokenUsedKeyPrefix   = "sx:refresh_used:"
+	RedisRefreshTokenFamilyKeyPrefix = "sx:refresh_family:"
+	RedisSessionKeyPrefix            = "sx:sessions:"
+	RedisMFAPendingKeyPrefix         = "sx:mfa_pending:"
+	RedisBlackListKeyPrefix          = "sk:black_list"
 )
 
 type LoginResponseByRefreshToekn struct {
@@ -16,10 +23,31 @@ type LoginResponseByRefreshToekn struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+//Session 一次登录，对应一个刷新token family
+type Session struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+//LoginResponseByMFA 密码校验通过但需要二次验证，使用 mfa_token 和验证码换取正式token
+type LoginResponseByMFA struct {
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"` //秒
+}
+
 type LoginResponseByBlackList struct {
 	AccessToken string `json:"access_token"`
 }
 
 var (
 	RefreshTokenExpired = errors.New("刷新token超时")
+	TokenRevoked        = errors.New("token已注销")
+	RefreshTokenInvalid = errors.New("刷新Token无效")
+	RefreshTokenReused  = errors.New("刷新token被重复使用，该登录已注销")
+	SessionNotFound     = errors.New("会话不存在")
+	MFATokenInvalid     = errors.New("二次验证已过期或错误次数过多，请重新登录")
 )
@@ -0,0 +1,68 @@
+package login
+
+import (
+	"context"
+	"github/xujialingit/shopping-app/pkg/pkg/logger"
+	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	//SecurityEventRefreshTokenReused 已轮换的刷新token被再次使用，可能被盗用
+	SecurityEventRefreshTokenReused = "refresh_token_reused"
+)
+
+//SecurityEvent 登录相关的安全事件
+type SecurityEvent struct {
+	Type   string
+	UserID int64
+	Family string
+	Time   time.Time
+}
+
+type Option func(*option)
+
+type option struct {
+	onSecurityEvent func(ctx context.Context, event SecurityEvent)
+	token           func() token.Token
+}
+
+//WithToken 签发和验证access token使用的生成器，如配置了非对称签名的 api.Token，默认使用cfg中的secret
+//传入函数是为了key轮换后不需要重新创建
+func WithToken(fn func() token.Token) Option {
+	return func(opt *option) {
+		opt.token = fn
+	}
+}
+
+//WithSecurityEventHandler 安全事件的处理函数，如告警、写入审计日志；事件总会以warn级别写入日志
+func WithSecurityEventHandler(fn func(ctx context.Context, event SecurityEvent)) Option {
+	return func(opt *option) {
+		opt.onSecurityEvent = fn
+	}
+}
+
+func newOption(secret string, options []Option) *option {
+	opt := &option{}
+	for _, f := range options {
+		f(opt)
+	}
+	if opt.token == nil {
+		tok := token.New(secret)
+		opt.token = func() token.Token { return tok }
+	}
+	return opt
+}
+
+func (o *option) emit(ctx context.Context, event SecurityEvent) {
+	logger.FromContext(ctx).Warn("security event",
+		zap.String("event", event.Type),
+		zap.Int64(logger.FieldUserID, event.UserID),
+		zap.String("family", event.Family),
+	)
+	if o.onSecurityEvent != nil {
+		o.onSecurityEvent(ctx, event)
+	}
+}
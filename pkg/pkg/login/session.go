@@ -0,0 +1,197 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github/xujialingit/shopping-app/pkg/pkg/login/model"
+	"sort"
+	"time"
+)
+
+//Device 登录的设备信息，由接口层从请求中获取
+type Device struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+type deviceKey struct{}
+
+//WithDevice 把设备信息放入ctx，GenerateToken RefreshToken 时记录到会话
+func WithDevice(ctx context.Context, device Device) context.Context {
+	return context.WithValue(ctx, deviceKey{}, device)
+}
+
+//DeviceFromContext 取出 WithDevice 放入的设备信息
+func DeviceFromContext(ctx context.Context) (Device, bool) {
+	device, ok := ctx.Value(deviceKey{}).(Device)
+	return device, ok
+}
+
+//sessionsKey 用户的全部会话，field为会话id(family)
+func sessionsKey(userId int64) string {
+	return fmt.Sprintf("%s%d", model.RedisSessionKeyPrefix, userId)
+}
+
+func (r RefreshTokenSystem) newSession(ctx context.Context, family string) *model.Session {
+	now := time.Now()
+	session := &model.Session{
+		ID:         family,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	if device, ok := DeviceFromContext(ctx); ok {
+		session.Device = device.Name
+		session.UserAgent = device.UserAgent
+		session.IP = device.IP
+	}
+	return session
+}
+
+//touchSession 刷新时更新最后使用时间，设备换了网络时更新ip
+func (r RefreshTokenSystem) touchSession(ctx context.Context, session *model.Session) {
+	session.LastUsedAt = time.Now()
+	if device, ok := DeviceFromContext(ctx); ok {
+		if device.UserAgent != "" {
+			session.UserAgent = device.UserAgent
+		}
+		if device.IP != "" {
+			session.IP = device.IP
+		}
+	}
+}
+
+//session 获取单个会话，没有记录时(轮换之前签发的token)重新创建
+func (r RefreshTokenSystem) session(ctx context.Context, userId int64, id string) (*model.Session, error) {
+	sessionJson, err := r.cache.Client().HGet(ctx, sessionsKey(userId), id).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return r.newSession(ctx, id), nil
+		}
+		return nil, err
+	}
+	session := new(model.Session)
+	if err := json.Unmarshal([]byte(sessionJson), session); err != nil {
+		return r.newSession(ctx, id), nil
+	}
+	return session, nil
+}
+
+//Sessions 用户的全部会话，按最后使用时间倒序，已过期的会话会被清理
+func (r RefreshTokenSystem) Sessions(ctx context.Context, userId int64) ([]*model.Session, error) {
+	client := r.cache.Client()
+	all, err := client.HGetAll(ctx, sessionsKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	exists := make([]*redis.IntCmd, len(ids))
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			exists[i] = pipe.Exists(ctx, refreshFamilyKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*model.Session, 0, len(ids))
+	expired := make([]string, 0)
+	for i, id := range ids {
+		session := new(model.Session)
+		if exists[i].Val() == 0 || json.Unmarshal([]byte(all[id]), session) != nil {
+			expired = append(expired, id)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if len(expired) > 0 {
+		client.HDel(ctx, sessionsKey(userId), expired...)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+//RevokeSession 注销用户的一个会话，只能注销自己的会话
+func (r RefreshTokenSystem) RevokeSession(ctx context.Context, userId int64, sessionId string) error {
+	ok, err := r.cache.Client().HExists(ctx, sessionsKey(userId), sessionId).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return model.SessionNotFound
+	}
+	return r.revokeSession(ctx, userId, sessionId)
+}
+
+//RevokeOtherSessions 注销除current之外的全部会话，current为空时注销全部会话(强制下线)
+func (r RefreshTokenSystem) RevokeOtherSessions(ctx context.Context, userId int64, current string) error {
+	ids, err := r.cache.Client().HKeys(ctx, sessionsKey(userId)).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == current {
+			continue
+		}
+		if err := r.revokeSession(ctx, userId, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//SessionActive 会话是否还有效，注销后已经签发的access token在过期前仍然可用，需要立即失效时在鉴权时检查
+func (r RefreshTokenSystem) SessionActive(ctx context.Context, userId int64, sessionId string) (bool, error) {
+	return r.cache.Client().HExists(ctx, sessionsKey(userId), sessionId).Result()
+}
+
+//limitSessions 超出 MaxSessions 时注销最久未使用的会话，keep为刚创建的会话
+func (r RefreshTokenSystem) limitSessions(ctx context.Context, userId int64, keep string) error {
+	if r.cfg.MaxSessions <= 0 {
+		return nil
+	}
+	sessions, err := r.Sessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+	for i := len(sessions) - 1; i >= 0 && len(sessions) > r.cfg.MaxSessions; i-- {
+		if sessions[i].ID == keep {
+			continue
+		}
+		if err := r.revokeSession(ctx, userId, sessions[i].ID); err != nil {
+			return err
+		}
+		sessions = append(sessions[:i], sessions[i+1:]...)
+	}
+	return nil
+}
+
+//revokeSession 删除会话和family当前有效的刷新token
+func (r RefreshTokenSystem) revokeSession(ctx context.Context, userId int64, family string) error {
+	current, err := r.cache.Get(ctx, refreshFamilyKey(family))
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	keys := []string{refreshFamilyKey(family)}
+	if current != "" {
+		keys = append(keys, refreshKey(current))
+	}
+	_, err = r.cache.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys...)
+		pipe.HDel(ctx, sessionsKey(userId), family)
+		return nil
+	})
+	return err
+}
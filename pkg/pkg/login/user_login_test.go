@@ -2,10 +2,14 @@ package login
 
 import (
 	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"github/xujialingit/shopping-app/pkg/cache"
+	"github/xujialingit/shopping-app/pkg/cache/cachetest"
 	"github/xujialingit/shopping-app/pkg/pkg/login/model"
 	"github/xujialingit/shopping-app/pkg/pkg/token"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -62,3 +66,298 @@ func TestRefreshTokenSystem_GenerateToken(t *testing.T) {
 		assert.Equal(t, claims.UserName, userName)
 	})
 }
+
+func TestRefreshTokenSystem_Rotation(t *testing.T) {
+	cacheRepo := cachetest.New(t)
+
+	cfg := &RefreshTokenConfig{
+		Secret:          "test_secret",
+		ExpireDuration:  time.Minute,
+		RefreshDuration: time.Hour,
+	}
+	ctx := context.Background()
+	var mu sync.Mutex
+	var events []SecurityEvent
+	system := NewByRefreshToken(cfg, cacheRepo, WithSecurityEventHandler(func(ctx context.Context, event SecurityEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}))
+
+	t.Run("重复使用已轮换的刷新token注销整个family", func(t *testing.T) {
+		resp, err := system.GenerateToken(ctx, 1, "name1")
+		assert.NoError(t, err)
+		first := resp.Token.(*model.LoginResponseByRefreshToekn)
+
+		resp, err = system.RefreshToken(ctx, first.RefreshToken)
+		assert.NoError(t, err)
+		second := resp.Token.(*model.LoginResponseByRefreshToekn)
+		assert.Equal(t, mustClaims(t, first.AccessToken).SessionID, mustClaims(t, second.AccessToken).SessionID)
+
+		_, err = system.RefreshToken(ctx, first.RefreshToken)
+		assert.Equal(t, model.RefreshTokenReused, err)
+		if assert.Len(t, events, 1) {
+			assert.Equal(t, SecurityEventRefreshTokenReused, events[0].Type)
+			assert.Equal(t, int64(1), events[0].UserID)
+		}
+
+		_, err = system.RefreshToken(ctx, second.RefreshToken)
+		assert.Error(t, err)
+	})
+
+	t.Run("并发刷新只有一个成功", func(t *testing.T) {
+		resp, err := system.GenerateToken(ctx, 2, "name2")
+		assert.NoError(t, err)
+		refreshToken := resp.Token.(*model.LoginResponseByRefreshToekn).RefreshToken
+
+		var wg sync.WaitGroup
+		var success int32
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := system.RefreshToken(ctx, refreshToken); err == nil {
+					atomic.AddInt32(&success, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), success)
+	})
+
+	t.Run("无效的刷新token", func(t *testing.T) {
+		_, err := system.RefreshToken(ctx, "not-exists")
+		assert.Equal(t, model.RefreshTokenInvalid, err)
+	})
+}
+
+func TestRefreshTokenSystem_Sessions(t *testing.T) {
+	cacheRepo := cachetest.New(t)
+
+	cfg := &RefreshTokenConfig{
+		Secret:          "test_secret",
+		ExpireDuration:  time.Minute,
+		RefreshDuration: time.Hour,
+		MaxSessions:     2,
+	}
+	system := NewByRefreshToken(cfg, cacheRepo)
+	userId := 100 + int(time.Now().UnixNano()%1000)
+	login := func(device string) *model.LoginResponseByRefreshToekn {
+		ctx := WithDevice(context.Background(), Device{Name: device, UserAgent: "test", IP: "127.0.0.1"})
+		resp, err := system.GenerateToken(ctx, userId, "name")
+		assert.NoError(t, err)
+		return resp.Token.(*model.LoginResponseByRefreshToekn)
+	}
+	ctx := context.Background()
+
+	phone := login("phone")
+	time.Sleep(time.Millisecond * 10)
+	pad := login("pad")
+	time.Sleep(time.Millisecond * 10)
+	//超出MaxSessions，注销最久未使用的phone
+	pc := login("pc")
+
+	sessions, err := system.Sessions(ctx, int64(userId))
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 2) {
+		assert.Equal(t, "pc", sessions[0].Device)
+		assert.Equal(t, "pad", sessions[1].Device)
+		assert.Equal(t, "127.0.0.1", sessions[0].IP)
+	}
+	_, err = system.RefreshToken(ctx, phone.RefreshToken)
+	assert.Error(t, err)
+
+	t.Run("注销一个设备", func(t *testing.T) {
+		padSession := mustClaims(t, pad.AccessToken).SessionID
+		assert.NoError(t, system.RevokeSession(ctx, int64(userId), padSession))
+		assert.Equal(t, model.SessionNotFound, system.RevokeSession(ctx, int64(userId), padSession))
+		_, err := system.RefreshToken(ctx, pad.RefreshToken)
+		assert.Error(t, err)
+	})
+
+	t.Run("注销其他设备", func(t *testing.T) {
+		other := login("other")
+		current := mustClaims(t, pc.AccessToken).SessionID
+		assert.NoError(t, system.RevokeOtherSessions(ctx, int64(userId), current))
+
+		_, err := system.RefreshToken(ctx, other.RefreshToken)
+		assert.Error(t, err)
+		resp, err := system.RefreshToken(ctx, pc.RefreshToken)
+		assert.NoError(t, err)
+		pc = resp.Token.(*model.LoginResponseByRefreshToekn)
+
+		active, err := system.SessionActive(ctx, int64(userId), current)
+		assert.NoError(t, err)
+		assert.True(t, active)
+	})
+
+	t.Run("强制下线", func(t *testing.T) {
+		assert.NoError(t, system.ToeknCancelById(ctx, userId, ""))
+		sessions, err := system.Sessions(ctx, int64(userId))
+		assert.NoError(t, err)
+		assert.Empty(t, sessions)
+		_, err = system.RefreshToken(ctx, pc.RefreshToken)
+		assert.Error(t, err)
+	})
+}
+
+func TestBlackListSystem(t *testing.T) {
+	cacheRepo := cachetest.New(t)
+
+	cfg := &BlackListConfig{
+		Secret:         "test_secret",
+		ExpireDuration: time.Minute,
+	}
+	leeway := 30 * time.Second
+	ctx := context.Background()
+	tok := token.New(cfg.Secret, token.WithLeeway(leeway))
+	system := NewByBlackList(cfg, cacheRepo, WithToken(func() token.Token { return tok }))
+	generate := func(userId int) string {
+		resp, err := system.GenerateToken(ctx, userId, "name1")
+		assert.NoError(t, err)
+		return resp.Token.(*model.LoginResponseByBlackList).AccessToken
+	}
+
+	t.Run("注销单个token", func(t *testing.T) {
+		first, second := generate(1), generate(1)
+		assert.NoError(t, system.TokenCancel(ctx, first))
+
+		_, err := system.Verify(ctx, first)
+		assert.Equal(t, model.TokenRevoked, err)
+		revoked, err := system.CheckBlackList(ctx, first)
+		assert.NoError(t, err)
+		assert.True(t, revoked)
+
+		claims, err := system.Verify(ctx, second)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), claims.UserID)
+
+		//过期后leeway内验证仍会通过，黑名单要保留到那之后
+		ttl, err := cacheRepo.TTL(ctx, system.tokenKey(mustClaims(t, first).Id))
+		assert.NoError(t, err)
+		assert.True(t, ttl > cfg.ExpireDuration && ttl <= cfg.ExpireDuration+leeway)
+	})
+
+	t.Run("刷新后旧token失效", func(t *testing.T) {
+		old := generate(2)
+		resp, err := system.RefreshToken(ctx, old)
+		assert.NoError(t, err)
+		_, err = system.Verify(ctx, old)
+		assert.Equal(t, model.TokenRevoked, err)
+		_, err = system.Verify(ctx, resp.Token.(*model.LoginResponseByBlackList).AccessToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("注销全部设备", func(t *testing.T) {
+		now := time.Now().Truncate(time.Second)
+		system.now = func() time.Time { return now }
+		defer func() { system.now = time.Now }()
+
+		token3, other := generate(3), generate(4)
+		system.now = func() time.Time { return now.Add(time.Millisecond) }
+		assert.NoError(t, system.ToeknCancelById(ctx, 3, ""))
+		_, err := system.Verify(ctx, token3)
+		assert.Equal(t, model.TokenRevoked, err)
+		_, err = system.Verify(ctx, other)
+		assert.NoError(t, err)
+
+		ttl, err := cacheRepo.TTL(ctx, system.userKey(3))
+		assert.NoError(t, err)
+		assert.Equal(t, cfg.ExpireDuration+leeway, ttl)
+
+		//注销后同一秒内重新登录的token不受影响
+		system.now = func() time.Time { return now.Add(2 * time.Millisecond) }
+		_, err = system.Verify(ctx, generate(3))
+		assert.NoError(t, err)
+	})
+}
+
+func mustClaims(t *testing.T, accessToken string) *token.Claims {
+	claims, err := token.New("test_secret").JwtParseUnsafe(accessToken)
+	assert.NoError(t, err)
+	return claims
+}
+
+func TestMFATokenSystem(t *testing.T) {
+	cacheRepo := cachetest.New(t)
+
+	cfg := &RefreshTokenConfig{
+		Secret:          "test_secret",
+		ExpireDuration:  time.Minute,
+		RefreshDuration: time.Hour,
+	}
+	system := NewByRefreshToken(cfg, cacheRepo)
+	mfa := NewMFA(&MFAConfig{PendingDuration: time.Minute, MaxAttempts: 2}, system, cacheRepo)
+	ctx := WithAuthMethods(context.Background(), token.AMRPassword)
+	errCode := errors.New("验证码错误")
+	verify := func(code string) func(ctx context.Context, userId int64) error {
+		return func(ctx context.Context, userId int64) error {
+			assert.Equal(t, int64(7), userId)
+			if code != "123456" {
+				return errCode
+			}
+			return nil
+		}
+	}
+	pending := func() string {
+		resp, err := mfa.Pending(ctx, 7, "tom")
+		assert.NoError(t, err)
+		result := resp.Token.(*model.LoginResponseByMFA)
+		assert.Equal(t, int64(60), result.ExpiresIn)
+		return result.MFAToken
+	}
+
+	t.Run("验证通过后签发token", func(t *testing.T) {
+		mfaToken := pending()
+		_, _, err := mfa.Complete(ctx, mfaToken, verify("000000"))
+		assert.Equal(t, errCode, err)
+
+		userId, resp, err := mfa.Complete(ctx, mfaToken, verify("123456"))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(7), userId)
+		claims, err := token.New(cfg.Secret).JwtParse(resp.Token.(*model.LoginResponseByRefreshToekn).AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{token.AMRPassword, token.AMROTP}, claims.AMR)
+
+		//刷新后保留amr
+		refreshed, err := system.RefreshToken(ctx, resp.Token.(*model.LoginResponseByRefreshToekn).RefreshToken)
+		assert.NoError(t, err)
+		claims, err = token.New(cfg.Secret).JwtParse(refreshed.Token.(*model.LoginResponseByRefreshToekn).AccessToken)
+		assert.NoError(t, err)
+		assert.True(t, claims.HasAMR(token.AMROTP))
+
+		//只能使用一次
+		_, _, err = mfa.Complete(ctx, mfaToken, verify("123456"))
+		assert.Equal(t, model.MFATokenInvalid, err)
+	})
+
+	t.Run("错误次数过多", func(t *testing.T) {
+		mfaToken := pending()
+		_, _, err := mfa.Complete(ctx, mfaToken, verify("000000"))
+		assert.Equal(t, errCode, err)
+		_, _, err = mfa.Complete(ctx, mfaToken, verify("000001"))
+		assert.Equal(t, errCode, err)
+		_, _, err = mfa.Complete(ctx, mfaToken, verify("123456"))
+		assert.Equal(t, model.MFATokenInvalid, err)
+	})
+
+	t.Run("并发请求不能超过次数", func(t *testing.T) {
+		mfaToken := pending()
+		var verified int32
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, _ = mfa.Complete(ctx, mfaToken, func(ctx context.Context, userId int64) error {
+					atomic.AddInt32(&verified, 1)
+					return errCode
+				})
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(2), verified)
+		_, _, err := mfa.Complete(ctx, mfaToken, verify("123456"))
+		assert.Equal(t, model.MFATokenInvalid, err)
+	})
+}
@@ -0,0 +1,206 @@
+//第三方登录，OAuth2授权码模式
+//state和PKCE的code_verifier保存在redis中，只能使用一次；授权码换取第三方账号信息后由业务决定登录、注册还是关联账号
+//发起授权时同时生成nonce，由业务放入发起授权的浏览器的cookie，回调时必须带上，避免攻击者把自己的授权回调发给受害者完成登录
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github/xujialingit/shopping-app/pkg/cache"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultStateTTL  = 10 * time.Minute
+	DefaultKeyPrefix = "oauth:"
+)
+
+var (
+	ErrUnknownProvider = errors.New("oauth: 不支持的第三方平台")
+	ErrStateInvalid    = errors.New("oauth: 授权已过期，请重新授权")
+	ErrProvider        = errors.New("oauth: 第三方平台返回错误")
+)
+
+//Authorization 授权码换取的结果，LinkUserID 为发起授权时传入的用户id，登录时为0
+type Authorization struct {
+	Identity   *Identity
+	LinkUserID int64
+}
+
+type Service interface {
+	i()
+	//Providers 已配置的平台名称
+	Providers() []string
+	//AuthCodeURL 生成state和PKCE参数，返回第三方授权页面地址和nonce，nonce需要放入HttpOnly的cookie
+	//linkUserId 不为0时表示已登录用户关联第三方账号，Exchange 时原样返回
+	AuthCodeURL(ctx context.Context, provider string, linkUserId int64) (authURL, nonce string, err error)
+	//Exchange 校验state和发起授权时的nonce，用授权码换取第三方账号信息，state只能使用一次
+	Exchange(ctx context.Context, provider, code, state, nonce string) (*Authorization, error)
+}
+
+type Option func(*option)
+
+type option struct {
+	stateTTL  time.Duration
+	keyPrefix string
+}
+
+//WithStateTTL 发起授权到回调的最长时间
+func WithStateTTL(ttl time.Duration) Option {
+	return func(opt *option) {
+		opt.stateTTL = ttl
+	}
+}
+
+//WithKeyPrefix redis key前缀
+func WithKeyPrefix(prefix string) Option {
+	return func(opt *option) {
+		opt.keyPrefix = prefix
+	}
+}
+
+type service struct {
+	cache     cache.Repo
+	providers map[string]Provider
+	opt       *option
+}
+
+func New(repo cache.Repo, providers []Provider, options ...Option) Service {
+	opt := &option{
+		stateTTL:  DefaultStateTTL,
+		keyPrefix: DefaultKeyPrefix,
+	}
+	for _, f := range options {
+		f(opt)
+	}
+	s := &service{
+		cache:     repo,
+		providers: make(map[string]Provider, len(providers)),
+		opt:       opt,
+	}
+	for _, p := range providers {
+		s.providers[p.Name()] = p
+	}
+	return s
+}
+
+func (s *service) i() {}
+
+func (s *service) stateKey(state string) string {
+	return s.opt.keyPrefix + "state:" + state
+}
+
+func (s *service) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *service) AuthCodeURL(ctx context.Context, provider string, linkUserId int64) (string, string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	key := s.stateKey(state)
+	_, err = s.cache.Client().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"provider", provider,
+			"verifier", verifier,
+			"link_user_id", linkUserId,
+			"nonce", hashNonce(nonce),
+		)
+		pipe.PExpire(ctx, key, s.opt.stateTTL)
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return p.AuthCodeURL(state, codeChallenge(verifier)), nonce, nil
+}
+
+//KEYS[1] state 读取后删除，并发回调只有一个能拿到
+var takeStateScript = redis.NewScript(`
+local values = redis.call('HMGET', KEYS[1], 'provider', 'verifier', 'link_user_id', 'nonce')
+redis.call('DEL', KEYS[1])
+return values
+`)
+
+func (s *service) Exchange(ctx context.Context, provider, code, state, nonce string) (*Authorization, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	if state == "" || code == "" || nonce == "" {
+		return nil, ErrStateInvalid
+	}
+	values, err := takeStateScript.Run(ctx, s.cache.Client(), []string{s.stateKey(state)}).Slice()
+	if err != nil {
+		return nil, err
+	}
+	//state不存在，或者不是这个平台发起的授权
+	if len(values) != 4 || values[0] != provider {
+		return nil, ErrStateInvalid
+	}
+	verifier, _ := values[1].(string)
+	linkUserId, _ := values[2].(string)
+	//不是发起授权的浏览器
+	nonceHash, _ := values[3].(string)
+	if subtle.ConstantTimeCompare([]byte(nonceHash), []byte(hashNonce(nonce))) != 1 {
+		return nil, ErrStateInvalid
+	}
+
+	token, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := p.Identity(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	auth := &Authorization{Identity: identity}
+	auth.LinkUserID, _ = strconv.ParseInt(linkUserId, 10, 64)
+	return auth, nil
+}
+
+//codeChallenge PKCE S256
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+//hashNonce redis中只保存nonce的hash
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
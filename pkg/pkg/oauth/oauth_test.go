@@ -0,0 +1,227 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"github/xujialingit/shopping-app/pkg/cache/cachetest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//mockServer 本地的OAuth2平台，授权页面直接同意并跳转回redirect_uri，授权码只能使用一次
+type mockServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	codes map[string]string //授权码 -> code_challenge
+	user  map[string]interface{}
+}
+
+func newMockServer(t *testing.T) *mockServer {
+	m := &mockServer{
+		codes: make(map[string]string),
+		user: map[string]interface{}{
+			"id":         json.Number("12345678901"),
+			"login":      "tom",
+			"email":      "tom@test.com",
+			"name":       "Tom",
+			"avatar_url": "https://avatars.test/tom.png",
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code, _ := randomString()
+		m.mu.Lock()
+		m.codes[code] = q.Get("code_challenge")
+		m.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		m.mu.Lock()
+		challenge, ok := m.codes[r.PostFormValue("code")]
+		delete(m.codes, r.PostFormValue("code"))
+		m.mu.Unlock()
+		switch {
+		case r.PostFormValue("client_id") != "client" || r.PostFormValue("client_secret") != "secret":
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+		case !ok || r.PostFormValue("grant_type") != "authorization_code":
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		case challenge != "" && codeChallenge(r.PostFormValue("code_verifier")) != challenge:
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"PKCE verification failed"}`))
+		default:
+			_, _ = w.Write([]byte(`{"access_token":"access-tom","token_type":"bearer","scope":"read:user"}`))
+		}
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-tom" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(m.user)
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockServer) config() *ProviderConfig {
+	return &ProviderConfig{
+		Name:         "mock",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		AuthURL:      m.URL + "/authorize",
+		TokenURL:     m.URL + "/token",
+		UserInfoURL:  m.URL + "/user",
+		RedirectURL:  "https://shop.test/oauth/callback",
+		Scopes:       []string{"read:user", "user:email"},
+		PKCE:         true,
+		SubjectField: "id",
+		AvatarField:  "avatar_url",
+		TrustEmail:   true,
+	}
+}
+
+//authorize 模拟用户在授权页面同意，返回跳转回来的code和state
+func authorize(t *testing.T, authURL string) (code, state string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if !assert.NoError(t, err) {
+		return "", ""
+	}
+	defer resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	server := newMockServer(t)
+	p := NewProvider(server.config(), nil)
+
+	authURL, err := url.Parse(p.AuthCodeURL("state1", codeChallenge("verifier1")))
+	assert.NoError(t, err)
+	q := authURL.Query()
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, "client", q.Get("client_id"))
+	assert.Equal(t, "read:user user:email", q.Get("scope"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	code, state := authorize(t, authURL.String())
+	assert.Equal(t, "state1", state)
+	_, err = p.Exchange(ctx, code, "wrong")
+	assert.ErrorIs(t, err, ErrProvider)
+	assert.Contains(t, err.Error(), "PKCE")
+
+	code, _ = authorize(t, authURL.String())
+	token, err := p.Exchange(ctx, code, "verifier1")
+	assert.NoError(t, err)
+	assert.Equal(t, "access-tom", token.AccessToken)
+	//授权码只能使用一次
+	_, err = p.Exchange(ctx, code, "verifier1")
+	assert.ErrorIs(t, err, ErrProvider)
+
+	identity, err := p.Identity(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, &Identity{
+		Provider:      "mock",
+		Subject:       "12345678901",
+		Email:         "tom@test.com",
+		EmailVerified: true,
+		Name:          "Tom",
+		Avatar:        "https://avatars.test/tom.png",
+	}, identity)
+
+	_, err = p.Identity(ctx, &Token{AccessToken: "other"})
+	assert.ErrorIs(t, err, ErrProvider)
+
+	//没有配置 TrustEmail 时按 email_verified 判断
+	cfg := server.config()
+	cfg.TrustEmail = false
+	identity, err = NewProvider(cfg, nil).Identity(ctx, token)
+	assert.NoError(t, err)
+	assert.False(t, identity.EmailVerified)
+	server.user["email_verified"] = true
+	identity, err = NewProvider(cfg, nil).Identity(ctx, token)
+	assert.NoError(t, err)
+	assert.True(t, identity.EmailVerified)
+}
+
+func TestService(t *testing.T) {
+	cacheRepo := cachetest.New(t)
+
+	ctx := context.Background()
+	server := newMockServer(t)
+	svc := New(cacheRepo, []Provider{NewProvider(server.config(), nil)})
+	assert.Equal(t, []string{"mock"}, svc.Providers())
+
+	_, _, err := svc.AuthCodeURL(ctx, "github", 0)
+	assert.Equal(t, ErrUnknownProvider, err)
+
+	t.Run("登录", func(t *testing.T) {
+		authURL, nonce, err := svc.AuthCodeURL(ctx, "mock", 0)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, nonce)
+		assert.True(t, strings.HasPrefix(authURL, server.URL+"/authorize?"))
+		code, state := authorize(t, authURL)
+
+		auth, err := svc.Exchange(ctx, "mock", code, state, nonce)
+		assert.NoError(t, err)
+		assert.Equal(t, "12345678901", auth.Identity.Subject)
+		assert.Equal(t, int64(0), auth.LinkUserID)
+
+		//state只能使用一次
+		_, err = svc.Exchange(ctx, "mock", code, state, nonce)
+		assert.Equal(t, ErrStateInvalid, err)
+	})
+
+	t.Run("关联账号", func(t *testing.T) {
+		authURL, nonce, err := svc.AuthCodeURL(ctx, "mock", 7)
+		assert.NoError(t, err)
+		code, state := authorize(t, authURL)
+		auth, err := svc.Exchange(ctx, "mock", code, state, nonce)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(7), auth.LinkUserID)
+	})
+
+	t.Run("无效的state", func(t *testing.T) {
+		authURL, nonce, err := svc.AuthCodeURL(ctx, "mock", 0)
+		assert.NoError(t, err)
+		code, _ := authorize(t, authURL)
+		_, err = svc.Exchange(ctx, "mock", code, "forged", nonce)
+		assert.Equal(t, ErrStateInvalid, err)
+		_, err = svc.Exchange(ctx, "mock", code, "", nonce)
+		assert.Equal(t, ErrStateInvalid, err)
+	})
+
+	t.Run("不是发起授权的浏览器", func(t *testing.T) {
+		//攻击者发起授权，把回调的code和state发给受害者，受害者的浏览器没有攻击者的nonce
+		authURL, _, err := svc.AuthCodeURL(ctx, "mock", 0)
+		assert.NoError(t, err)
+		_, victimNonce, err := svc.AuthCodeURL(ctx, "mock", 0)
+		assert.NoError(t, err)
+		code, state := authorize(t, authURL)
+		_, err = svc.Exchange(ctx, "mock", code, state, victimNonce)
+		assert.Equal(t, ErrStateInvalid, err)
+		_, err = svc.Exchange(ctx, "mock", code, state, "")
+		assert.Equal(t, ErrStateInvalid, err)
+
+		//redis中只保存nonce的hash
+		authURL, nonce, err := svc.AuthCodeURL(ctx, "mock", 0)
+		assert.NoError(t, err)
+		_, state = authorize(t, authURL)
+		stored, err := cacheRepo.Client().HGet(ctx, DefaultKeyPrefix+"state:"+state, "nonce").Result()
+		assert.NoError(t, err)
+		assert.NotEqual(t, nonce, stored)
+		assert.Equal(t, hashNonce(nonce), stored)
+	})
+}
@@ -0,0 +1,208 @@
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Identity 第三方账号，Subject 为第三方平台的用户唯一id
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool //第三方平台是否验证过邮箱，只有验证过的邮箱才能关联已有账号
+	Name          string
+	Avatar        string
+}
+
+//Token 授权码换取的access token
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+}
+
+//Provider 第三方平台，授权参数和用户信息格式不标准的平台(如微信)可以单独实现
+type Provider interface {
+	Name() string
+	//AuthCodeURL 跳转到第三方授权页面的地址，平台不支持PKCE时忽略codeChallenge
+	AuthCodeURL(state, codeChallenge string) string
+	//Exchange 授权码换取access token，平台不支持PKCE时忽略codeVerifier
+	Exchange(ctx context.Context, code, codeVerifier string) (*Token, error)
+	//Identity 获取第三方账号信息
+	Identity(ctx context.Context, token *Token) (*Identity, error)
+}
+
+//ProviderConfig 标准OAuth2授权码模式的平台配置
+//用户信息接口返回json，*Field 为各字段在json中的名称，为空时使用OIDC的字段名 sub email email_verified name picture
+type ProviderConfig struct {
+	Name               string
+	ClientID           string
+	ClientSecret       string
+	AuthURL            string
+	TokenURL           string
+	UserInfoURL        string
+	RedirectURL        string //第三方授权后跳转的前端页面，前端再把code和state提交给接口
+	Scopes             []string
+	PKCE               bool
+	SubjectField       string
+	EmailField         string
+	EmailVerifiedField string
+	NameField          string
+	AvatarField        string
+	//TrustEmail 平台返回的邮箱都已验证，没有 EmailVerifiedField 时使用，如GitHub的公开邮箱
+	TrustEmail bool
+}
+
+type provider struct {
+	cfg    *ProviderConfig
+	client *http.Client
+}
+
+//NewProvider 标准OAuth2平台，client为nil时使用10秒超时的http.Client
+func NewProvider(cfg *ProviderConfig, client *http.Client) Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &provider{cfg: cfg, client: client}
+}
+
+func (p *provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *provider) AuthCodeURL(state, codeChallenge string) string {
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"state":         {state},
+	}
+	if len(p.cfg.Scopes) > 0 {
+		params.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	}
+	if p.cfg.PKCE && codeChallenge != "" {
+		params.Set("code_challenge", codeChallenge)
+		params.Set("code_challenge_method", "S256")
+	}
+	sep := "?"
+	if strings.Contains(p.cfg.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.cfg.AuthURL + sep + params.Encode()
+}
+
+func (p *provider) Exchange(ctx context.Context, code, codeVerifier string) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+	}
+	if p.cfg.PKCE && codeVerifier != "" {
+		form.Set("code_verifier", codeVerifier)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	//GitHub默认返回表单格式
+	req.Header.Set("Accept", "application/json")
+
+	var resp struct {
+		Token
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.do(req, &resp); err != nil {
+		return nil, err
+	}
+	//部分平台出错时也返回200
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%w:%s %s", ErrProvider, resp.Error, resp.ErrorDescription)
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("%w:没有返回access_token", ErrProvider)
+	}
+	return &resp.Token, nil
+}
+
+func (p *provider) Identity(ctx context.Context, token *Token) (*Identity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	info := make(map[string]interface{})
+	if err := p.do(req, &info); err != nil {
+		return nil, err
+	}
+	identity := &Identity{
+		Provider: p.cfg.Name,
+		Subject:  field(info, p.cfg.SubjectField, "sub"),
+		Email:    field(info, p.cfg.EmailField, "email"),
+		Name:     field(info, p.cfg.NameField, "name"),
+		Avatar:   field(info, p.cfg.AvatarField, "picture"),
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w:用户信息中没有用户id", ErrProvider)
+	}
+	if identity.Email != "" {
+		if p.cfg.EmailVerifiedField == "" && p.cfg.TrustEmail {
+			identity.EmailVerified = true
+		} else {
+			identity.EmailVerified = field(info, p.cfg.EmailVerifiedField, "email_verified") == "true"
+		}
+	}
+	return identity, nil
+}
+
+//maxResponseSize 第三方接口返回的内容不会超过1M
+const maxResponseSize = 1 << 20
+
+func (p *provider) do(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w:%s %s返回%d", ErrProvider, req.Method, req.URL.Path, resp.StatusCode)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	//GitHub的用户id为数字，避免转为float64后丢失精度
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w:解析响应失败 %v", ErrProvider, err)
+	}
+	return nil
+}
+
+//field 读取用户信息中的字段，name为空时使用fallback，不存在或为null时返回空字符串
+func field(info map[string]interface{}, name, fallback string) string {
+	if name == "" {
+		name = fallback
+	}
+	value, ok := info[name]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
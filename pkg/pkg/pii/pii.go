@@ -0,0 +1,188 @@
+//敏感字段(手机号、地址、身份证号、邮箱)加密存储
+//AES-GCM加密，密钥带版本号，轮换时新增密钥并切换activeKey，旧密钥继续用来解密
+//密文格式: enc:<版本号>:<base64(nonce+密文)>
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const _Prefix = "enc:"
+
+var (
+	ErrKeyNotFound      = errors.New("pii: 找不到解密密钥")
+	ErrInvalidCipher    = errors.New("pii: 密文格式错误")
+	ErrKeyringNotConfig = errors.New("pii: 没有配置密钥")
+	ErrNoIndexKey       = errors.New("pii: 没有配置盲索引密钥")
+)
+
+//Config 密钥配置，密钥为base64编码的32字节随机数
+type Config struct {
+	ActiveKey string            //加密使用的密钥版本
+	Keys      map[string]string //版本号 -> 密钥
+	IndexKey  string            //盲索引的HMAC密钥，至少32字节，不能轮换，轮换需要重建全部索引
+}
+
+type Keyring struct {
+	active   string
+	aeads    map[string]cipher.AEAD
+	indexKey []byte
+}
+
+func NewKeyring(cfg *Config) (*Keyring, error) {
+	if cfg == nil || len(cfg.Keys) == 0 {
+		return nil, ErrKeyringNotConfig
+	}
+
+	k := &Keyring{
+		active: cfg.ActiveKey,
+		aeads:  make(map[string]cipher.AEAD, len(cfg.Keys)),
+	}
+	for version, encoded := range cfg.Keys {
+		if strings.Contains(version, ":") {
+			return nil, fmt.Errorf("pii: 密钥版本号%s不能包含冒号", version)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("pii: 密钥%s不是有效的base64:%w", version, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("pii: 密钥%s长度必须为32字节", version)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[version] = aead
+	}
+	if _, ok := k.aeads[k.active]; !ok {
+		return nil, fmt.Errorf("pii: activeKey %s 不存在", k.active)
+	}
+
+	//没有密钥的HMAC等同于sha256，手机号、邮箱的盲索引可以被字典还原
+	if cfg.IndexKey == "" {
+		return nil, ErrNoIndexKey
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(cfg.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("pii: indexKey不是有效的base64:%w", err)
+	}
+	if len(indexKey) < 32 {
+		return nil, errors.New("pii: indexKey长度不能少于32字节")
+	}
+	k.indexKey = indexKey
+	return k, nil
+}
+
+//Encrypt 使用当前密钥加密，aad用于绑定密文的用途(如列名)，解密时必须一致
+func (k *Keyring) Encrypt(plaintext string, aad []byte) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	aead := k.aeads[k.active]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), aad)
+	return _Prefix + k.active + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+//Decrypt 按密文中的版本号选择密钥解密
+//没有enc:前缀的值视为加密上线前的明文，原样返回，便于存量数据逐步迁移
+func (k *Keyring) Decrypt(ciphertext string, aad []byte) (string, error) {
+	if !IsEncrypted(ciphertext) {
+		return ciphertext, nil
+	}
+	version, data, ok := strings.Cut(strings.TrimPrefix(ciphertext, _Prefix), ":")
+	if !ok {
+		return "", ErrInvalidCipher
+	}
+	aead, ok := k.aeads[version]
+	if !ok {
+		return "", fmt.Errorf("%w:%s", ErrKeyNotFound, version)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrInvalidCipher
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return "", fmt.Errorf("%w:%v", ErrInvalidCipher, err)
+	}
+	return string(plain), nil
+}
+
+//NeedsRotation 密文不是用当前密钥加密的(或者还是明文)，需要重新保存
+func (k *Keyring) NeedsRotation(ciphertext string) bool {
+	if ciphertext == "" {
+		return false
+	}
+	return !strings.HasPrefix(ciphertext, _Prefix+k.active+":")
+}
+
+//BlindIndex 盲索引，用于在不解密的情况下按值等值查询
+//值先做标准化(去空格、转小写)，相同的值得到相同的索引
+func (k *Keyring) BlindIndex(value string) string {
+	value = Normalize(value)
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, _Prefix)
+}
+
+//Normalize 盲索引使用的标准化规则
+func Normalize(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+var (
+	mu             sync.RWMutex
+	defaultKeyring *Keyring
+)
+
+//SetDefault 设置gorm serializer使用的全局密钥，服务启动时调用，配置热更新后可以再次调用
+func SetDefault(k *Keyring) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultKeyring = k
+}
+
+func Default() (*Keyring, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if defaultKeyring == nil {
+		return nil, ErrKeyringNotConfig
+	}
+	return defaultKeyring, nil
+}
+
+//BlindIndex 使用全局密钥计算盲索引
+func BlindIndex(value string) (string, error) {
+	k, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return k.BlindIndex(value), nil
+}
@@ -0,0 +1,114 @@
+package pii
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github/xujialingit/shopping-app/pkg/db"
+)
+
+func randomKey() string {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	cfg := &Config{
+		ActiveKey: "1",
+		Keys:      map[string]string{"1": randomKey()},
+		IndexKey:  randomKey(),
+	}
+	k1, err := NewKeyring(cfg)
+	assert.NoError(t, err)
+
+	old, err := k1.Encrypt("13800138000", []byte("phone"))
+	assert.NoError(t, err)
+	assert.True(t, IsEncrypted(old))
+
+	//aad不一致时不能解密
+	_, err = k1.Decrypt(old, []byte("address"))
+	assert.ErrorIs(t, err, ErrInvalidCipher)
+
+	//新增密钥2并切换，旧密文仍可解密
+	cfg.Keys["2"] = randomKey()
+	cfg.ActiveKey = "2"
+	k2, err := NewKeyring(cfg)
+	assert.NoError(t, err)
+
+	plain, err := k2.Decrypt(old, []byte("phone"))
+	assert.NoError(t, err)
+	assert.Equal(t, "13800138000", plain)
+	assert.True(t, k2.NeedsRotation(old))
+
+	fresh, err := k2.Encrypt("13800138000", []byte("phone"))
+	assert.NoError(t, err)
+	assert.False(t, k2.NeedsRotation(fresh))
+	assert.NotEqual(t, old, fresh)
+
+	//盲索引与密钥版本无关
+	assert.Equal(t, k1.BlindIndex(" Foo@Example.com"), k2.BlindIndex("foo@example.com"))
+
+	//删除旧密钥后无法解密
+	delete(cfg.Keys, "1")
+	k3, err := NewKeyring(cfg)
+	assert.NoError(t, err)
+	_, err = k3.Decrypt(old, []byte("phone"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestNewKeyring_IndexKey(t *testing.T) {
+	keys := map[string]string{"1": randomKey()}
+	_, err := NewKeyring(&Config{ActiveKey: "1", Keys: keys})
+	assert.ErrorIs(t, err, ErrNoIndexKey)
+
+	_, err = NewKeyring(&Config{ActiveKey: "1", Keys: keys, IndexKey: base64.StdEncoding.EncodeToString([]byte("short-key"))})
+	assert.Error(t, err)
+
+	_, err = NewKeyring(&Config{ActiveKey: "1", Keys: keys, IndexKey: "not base64!"})
+	assert.Error(t, err)
+}
+
+type testCustomer struct {
+	ID         int64
+	Phone      string `gorm:"size:255;serializer:pii"`
+	PhoneIndex string `gorm:"size:64;index"`
+	Address    string `gorm:"size:512;serializer:pii"`
+}
+
+func TestSerializer(t *testing.T) {
+	k, err := NewKeyring(&Config{ActiveKey: "1", Keys: map[string]string{"1": randomKey()}, IndexKey: randomKey()})
+	assert.NoError(t, err)
+	SetDefault(k)
+	defer SetDefault(nil)
+
+	ctx := context.Background()
+	repo, err := db.New(&db.DBConfig{Driver: db.DriverSqlite})
+	assert.NoError(t, err)
+	defer repo.DbClose()
+	assert.NoError(t, repo.GetDb(ctx).AutoMigrate(&testCustomer{}))
+
+	customer := &testCustomer{Phone: "13800138000", Address: "上海市浦东新区"}
+	customer.PhoneIndex = k.BlindIndex(customer.Phone)
+	assert.NoError(t, repo.GetDb(ctx).Create(customer).Error)
+
+	//数据库中是密文
+	var raw struct {
+		Phone   string
+		Address string
+	}
+	assert.NoError(t, repo.GetDb(ctx).Table("test_customer").Take(&raw).Error)
+	assert.True(t, IsEncrypted(raw.Phone))
+	assert.NotContains(t, raw.Address, "上海")
+
+	//通过盲索引查询，读取时自动解密
+	index, err := BlindIndex("13800138000")
+	assert.NoError(t, err)
+	var got testCustomer
+	assert.NoError(t, repo.GetDb(ctx).Where("phone_index = ?", index).Take(&got).Error)
+	assert.Equal(t, "13800138000", got.Phone)
+	assert.Equal(t, "上海市浦东新区", got.Address)
+}
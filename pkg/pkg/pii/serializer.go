@@ -0,0 +1,66 @@
+package pii
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+//gorm serializer，字段上使用 `gorm:"serializer:pii"` 即可透明加解密，字段类型必须为string
+//密文使用列名作为aad，同一份密文不能被复制到其他列使用
+//  type User struct {
+//      Phone      string `gorm:"size:255;serializer:pii"`
+//      PhoneIndex string `gorm:"size:64;index"` //pii.BlindIndex(Phone)
+//  }
+//注意：加密字段不能直接作为查询条件，等值查询需要使用盲索引列
+
+const SerializerName = "pii"
+
+func init() {
+	schema.RegisterSerializer(SerializerName, Serializer{})
+}
+
+type Serializer struct{}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var ciphertext string
+	switch v := dbValue.(type) {
+	case nil:
+		return nil
+	case string:
+		ciphertext = v
+	case []byte:
+		ciphertext = string(v)
+	default:
+		return fmt.Errorf("pii: 不支持的数据库类型:%T", dbValue)
+	}
+
+	plaintext := ciphertext
+	if IsEncrypted(ciphertext) {
+		k, err := Default()
+		if err != nil {
+			return err
+		}
+		if plaintext, err = k.Decrypt(ciphertext, []byte(field.DBName)); err != nil {
+			return err
+		}
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("pii: 字段%s必须为string类型", field.Name)
+	}
+	if plaintext == "" {
+		return "", nil
+	}
+	k, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(plaintext, []byte(field.DBName))
+}
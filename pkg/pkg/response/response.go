@@ -42,8 +42,56 @@ const (
 	EmailCodeTypeError = 10013
 	UserNotExits       = 10014
 	ChangePWDFail      = 10015
+	VersionConflict    = 10016
+	PermissionDenied   = 10017
+	SessionNotFound    = 10018
+	UserDisabled       = 10019
+	AccountLocked      = 10020
+	MFARequired        = 10021
+	MFACodeInvalid     = 10022
+	MFATokenInvalid    = 10023
+	MFAAlreadyEnabled  = 10024
+	MFANotEnabled      = 10025
+	CaptchaRequired    = 10026
+	CaptchaInvalid     = 10027
+	PhoneExists        = 10028
+	PhoneInvalid       = 10029
+	OAuthUnknown       = 10030
+	OAuthStateInvalid  = 10031
+	OAuthFailed        = 10032
+	OAuthNoEmail       = 10033
+	OAuthLinked        = 10034
+	OAuthNotLinked     = 10035
 )
 
 func Text(code int) string {
 	return codeText[code]
 }
+
+//分页返回的数据，作为JsonResponse.Data返回
+//offset分页返回 total/page/size，游标分页返回 next_cursor/size
+//Total 为指针，空的offset分页也返回 total:0
+type PageData struct {
+	List       interface{} `json:"list"`                  //当前页数据
+	Total      *int64      `json:"total,omitempty"`       //总条数，游标分页时为nil
+	Page       int         `json:"page,omitempty"`        //当前页码
+	Size       int         `json:"size"`                  //每页条数
+	NextCursor string      `json:"next_cursor,omitempty"` //下一页游标，为空表示没有更多数据
+}
+
+func NewPageData(list interface{}, total int64, page, size int) *PageData {
+	return &PageData{
+		List:  list,
+		Total: &total,
+		Page:  page,
+		Size:  size,
+	}
+}
+
+func NewCursorPageData(list interface{}, nextCursor string, size int) *PageData {
+	return &PageData{
+		List:       list,
+		Size:       size,
+		NextCursor: nextCursor,
+	}
+}
@@ -16,4 +16,24 @@ var codeText = map[int]string{
 	EmailCodeTypeError: "验证码类型错误",
 	UserNotExits:       "用户不存在",
 	ChangePWDFail:      "修改密码失败",
+	VersionConflict:    "数据已被修改，请刷新后重试",
+	PermissionDenied:   "没有权限",
+	SessionNotFound:    "登录设备不存在",
+	UserDisabled:       "账号已被禁用",
+	AccountLocked:      "密码错误次数过多，账号已被临时锁定，请稍后再试或通过邮箱验证码解锁",
+	MFARequired:        "需要开启二次验证并使用二次验证登录",
+	MFACodeInvalid:     "二次验证码错误",
+	MFATokenInvalid:    "二次验证已过期，请重新登录",
+	MFAAlreadyEnabled:  "已开启二次验证",
+	MFANotEnabled:      "未开启二次验证",
+	CaptchaRequired:    "请输入图形验证码",
+	CaptchaInvalid:     "图形验证码错误或已过期",
+	PhoneExists:        "手机号已被其他账号绑定",
+	PhoneInvalid:       "手机号格式错误",
+	OAuthUnknown:       "不支持的第三方登录",
+	OAuthStateInvalid:  "第三方授权已过期，请重新授权",
+	OAuthFailed:        "第三方授权失败",
+	OAuthNoEmail:       "第三方账号没有验证过的邮箱，请登录后关联",
+	OAuthLinked:        "第三方账号已关联其他账号",
+	OAuthNotLinked:     "未关联该第三方账号",
 }
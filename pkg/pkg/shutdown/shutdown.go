@@ -1,9 +1,36 @@
 package shutdown
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//Phase 关闭阶段，按顺序执行，同一阶段内的函数并发执行
+type Phase string
+
+const (
+	//PhaseStopAccepting 停止接收新请求，如注销服务、关闭健康检查
+	PhaseStopAccepting Phase = "stop-accepting"
+	//PhaseDrainHTTP 等待处理中的http请求完成
+	PhaseDrainHTTP Phase = "drain-http"
+	//PhaseStopWorkers 停止后台任务，如outbox relay
+	PhaseStopWorkers Phase = "stop-workers"
+	//PhaseCloseStores 关闭db、redis等连接
+	PhaseCloseStores Phase = "close-stores"
+)
+
+const (
+	DefaultTimeout      = 30 * time.Second
+	DefaultPhaseTimeout = 10 * time.Second
 )
 
 var _ Hook = (*hook)(nil)
@@ -11,33 +38,289 @@ var _ Hook = (*hook)(nil)
 type Hook interface {
 	WithSignals(signals ...syscall.Signal) Hook
 
+	//WithTimeout 全部阶段的总超时时间，默认 DefaultTimeout
+	WithTimeout(timeout time.Duration) Hook
+
+	//WithPhaseTimeout 单个阶段的超时时间，默认 DefaultPhaseTimeout
+	WithPhaseTimeout(phase Phase, timeout time.Duration) Hook
+
+	//WithLogger 记录各阶段的耗时和错误，默认 zap.L()
+	WithLogger(logger *zap.Logger) Hook
+
+	//WithReload 收到SIGHUP时调用reload，不退出
+	WithReload(reload func()) Hook
+
+	//Register 注册关闭函数，ctx在阶段超时或总超时时取消
+	//PhaseStopAccepting PhaseDrainHTTP PhaseStopWorkers PhaseCloseStores 按固定顺序执行，其它阶段按注册顺序排在之后
+	Register(phase Phase, fn func(ctx context.Context) error) Hook
+
+	//Wait 阻塞直到收到退出信号，然后按阶段关闭，返回全部阶段的错误
+	//关闭过程中再次收到退出信号时强制退出
+	Wait() error
+
+	//Shutdown 不等待信号，直接按阶段关闭
+	Shutdown() error
+
+	//Close 等待退出信号后依次执行funcs，兼容旧的用法
 	Close(funcs ...func())
 }
 
 type hook struct {
 	ctx chan os.Signal
+
+	mu            sync.Mutex
+	timeout       time.Duration
+	phaseTimeouts map[Phase]time.Duration
+	phases        []Phase
+	funcs         map[Phase][]func(ctx context.Context) error
+	logger        *zap.Logger
+	reload        func()
+
+	//测试时替换
+	exit func(code int)
 }
 
 func NewHook() Hook {
 	hook := &hook{
-		ctx: make(chan os.Signal, 1),
+		ctx:           make(chan os.Signal, 2),
+		timeout:       DefaultTimeout,
+		phaseTimeouts: make(map[Phase]time.Duration),
+		phases:        []Phase{PhaseStopAccepting, PhaseDrainHTTP, PhaseStopWorkers, PhaseCloseStores},
+		funcs:         make(map[Phase][]func(ctx context.Context) error),
+		exit:          os.Exit,
 	}
 	return hook.WithSignals(syscall.SIGINT, syscall.SIGTERM)
 }
 
-func (h hook) WithSignals(signals ...syscall.Signal) Hook {
+func (h *hook) WithSignals(signals ...syscall.Signal) Hook {
 	for _, s := range signals {
 		signal.Notify(h.ctx, s)
 	}
 	return h
 }
 
-func (h hook) Close(funcs ...func()) {
-	select {
-	case <-h.ctx:
+func (h *hook) WithTimeout(timeout time.Duration) Hook {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.timeout = timeout
+	return h
+}
+
+func (h *hook) WithPhaseTimeout(phase Phase, timeout time.Duration) Hook {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.phaseTimeouts[phase] = timeout
+	return h
+}
+
+func (h *hook) WithLogger(logger *zap.Logger) Hook {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.logger = logger
+	return h
+}
+
+func (h *hook) WithReload(reload func()) Hook {
+	h.mu.Lock()
+	h.reload = reload
+	h.mu.Unlock()
+	signal.Notify(h.ctx, syscall.SIGHUP)
+	return h
+}
+
+func (h *hook) Register(phase Phase, fn func(ctx context.Context) error) Hook {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.funcs[phase]; !ok && !h.hasPhase(phase) {
+		h.phases = append(h.phases, phase)
+	}
+	h.funcs[phase] = append(h.funcs[phase], fn)
+	return h
+}
+
+func (h *hook) hasPhase(phase Phase) bool {
+	for _, p := range h.phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hook) log() *zap.Logger {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.logger != nil {
+		return h.logger
+	}
+	return zap.L()
+}
+
+func (h *hook) Wait() error {
+	for sig := range h.ctx {
+		if sig == syscall.SIGHUP {
+			h.mu.Lock()
+			reload := h.reload
+			h.mu.Unlock()
+			if reload != nil {
+				h.log().Info("收到SIGHUP，重新加载配置")
+				reload()
+			}
+			continue
+		}
+		h.log().Warn("收到退出信号，开始关闭", zap.String("signal", sig.String()))
+		break
+	}
+
+	//关闭过程中再次收到退出信号，强制退出
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-h.ctx:
+				if sig == syscall.SIGHUP {
+					continue
+				}
+				h.log().Error("再次收到退出信号，强制退出", zap.String("signal", sig.String()))
+				h.exit(1)
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	return h.Shutdown()
+}
+
+func (h *hook) Shutdown() error {
+	h.mu.Lock()
+	timeout := h.timeout
+	phases := make([]Phase, len(h.phases))
+	copy(phases, h.phases)
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errs := make([]error, 0)
+	for _, phase := range phases {
+		if err := h.runPhase(ctx, phase); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	signal.Stop(h.ctx)
-	for _, f := range funcs {
-		f()
+	if len(errs) > 0 {
+		return &Error{Errors: errs}
+	}
+	return nil
+}
+
+//runPhase 并发执行同一阶段的函数，超时后不再等待未返回的函数
+func (h *hook) runPhase(parent context.Context, phase Phase) error {
+	h.mu.Lock()
+	funcs := h.funcs[phase]
+	timeout, ok := h.phaseTimeouts[phase]
+	h.mu.Unlock()
+	if len(funcs) == 0 {
+		return nil
+	}
+	if !ok {
+		timeout = DefaultPhaseTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	start := time.Now()
+	results := make(chan error, len(funcs))
+	for _, fn := range funcs {
+		go func(fn func(ctx context.Context) error) {
+			defer func() {
+				if err := recover(); err != nil {
+					results <- fmt.Errorf("panic: %v", err)
+				}
+			}()
+			results <- fn(ctx)
+		}(fn)
+	}
+
+	errs := make([]error, 0)
+wait:
+	for i := 0; i < len(funcs); i++ {
+		select {
+		case err := <-results:
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("%d个函数未完成: %w", len(funcs)-i, ctx.Err()))
+			break wait
+		}
+	}
+
+	logger := h.log().With(zap.String("phase", string(phase)), zap.Duration("cost", time.Since(start)))
+	if len(errs) == 0 {
+		logger.Info("关闭阶段完成")
+		return nil
+	}
+	err := &PhaseError{Phase: phase, Errors: errs}
+	logger.Error("关闭阶段失败", zap.Error(err))
+	return err
+}
+
+func (h *hook) Close(funcs ...func()) {
+	h.Register(PhaseCloseStores, func(context.Context) error {
+		for _, f := range funcs {
+			f()
+		}
+		return nil
+	})
+	_ = h.Wait()
+}
+
+//PhaseError 一个阶段中的全部错误
+type PhaseError struct {
+	Phase  Phase
+	Errors []error
+}
+
+func (e *PhaseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Phase, joinErrors(e.Errors))
+}
+
+//Error Shutdown返回的错误，包含每个失败阶段的*PhaseError
+type Error struct {
+	Errors []error
+}
+
+func (e *Error) Error() string {
+	return "shutdown失败: " + joinErrors(e.Errors)
+}
+
+//Is 任一阶段的错误匹配即可，如 errors.Is(err, context.DeadlineExceeded)
+func (e *Error) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *PhaseError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinErrors(errs []error) string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
 	}
+	return strings.Join(msgs, "; ")
 }
@@ -0,0 +1,108 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHook_Phases(t *testing.T) {
+	h := NewHook().(*hook)
+	var mu sync.Mutex
+	order := make([]string, 0)
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	h.Register(PhaseCloseStores, record("redis")).
+		Register("flush-metrics", record("metrics")).
+		Register(PhaseDrainHTTP, record("http")).
+		Register(PhaseStopWorkers, func(context.Context) error {
+			return errors.New("relay stop failed")
+		}).
+		Register(PhaseStopWorkers, record("relay"))
+
+	err := h.Shutdown()
+	assert.Equal(t, []string{"http", "relay", "redis", "metrics"}, order)
+
+	var shutdownErr *Error
+	if assert.ErrorAs(t, err, &shutdownErr) && assert.Len(t, shutdownErr.Errors, 1) {
+		var phaseErr *PhaseError
+		assert.ErrorAs(t, shutdownErr.Errors[0], &phaseErr)
+		assert.Equal(t, PhaseStopWorkers, phaseErr.Phase)
+	}
+}
+
+func TestHook_Timeout(t *testing.T) {
+	h := NewHook().(*hook)
+	closed := make(chan struct{})
+
+	//阻塞的函数不影响后续阶段
+	h.WithPhaseTimeout(PhaseStopWorkers, 20*time.Millisecond).
+		Register(PhaseStopWorkers, func(context.Context) error {
+			select {}
+		}).
+		Register(PhaseCloseStores, func(ctx context.Context) error {
+			close(closed)
+			return nil
+		})
+
+	start := time.Now()
+	err := h.Shutdown()
+	assert.Less(t, time.Since(start), time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	<-closed
+
+	//总超时
+	h = NewHook().WithTimeout(20 * time.Millisecond).(*hook)
+	h.Register(PhaseDrainHTTP, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, h.Shutdown(), context.DeadlineExceeded)
+}
+
+func TestHook_Signals(t *testing.T) {
+	h := NewHook().(*hook)
+	reloaded := make(chan struct{}, 1)
+	exited := make(chan int, 1)
+	h.exit = func(code int) { exited <- code }
+
+	release := make(chan struct{})
+	h.WithReload(func() { reloaded <- struct{}{} }).
+		Register(PhaseDrainHTTP, func(ctx context.Context) error {
+			<-release
+			return nil
+		})
+
+	result := make(chan error, 1)
+	go func() { result <- h.Wait() }()
+
+	//SIGHUP只重新加载
+	h.ctx <- syscall.SIGHUP
+	<-reloaded
+	select {
+	case <-result:
+		t.Fatal("SIGHUP不应该退出")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	//第一次信号开始关闭，第二次强制退出
+	h.ctx <- syscall.SIGTERM
+	time.Sleep(20 * time.Millisecond)
+	h.ctx <- syscall.SIGINT
+	assert.Equal(t, 1, <-exited)
+
+	close(release)
+	assert.NoError(t, <-result)
+}
@@ -0,0 +1,83 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/golang-jwt/jwt"
+)
+
+//Claims token中的用户信息，jti iss aud exp 等在StandardClaims中
+//UserID UserName 的json名称保持不变，兼容已经签发的token
+type Claims struct {
+	UserID    int64                  `json:"UserID"`
+	UserName  string                 `json:"UserName"`
+	Roles     []string               `json:"roles,omitempty"`
+	Scopes    []string               `json:"scopes,omitempty"`
+	TenantID  int64                  `json:"tenant_id,omitempty"` //租户/商户
+	DeviceID  string                 `json:"device_id,omitempty"`
+	SessionID string                 `json:"sid,omitempty"`
+	AMR       []string               `json:"amr,omitempty"` //登录使用的认证方式，RFC 8176，如 pwd otp
+	Extra     map[string]interface{} `json:"ext,omitempty"` //自定义字段
+	jwt.StandardClaims
+}
+
+//HasRole 是否拥有任意一个角色
+func (c *Claims) HasRole(roles ...string) bool {
+	return containsAny(c.Roles, roles)
+}
+
+//HasScope 是否拥有全部scope
+func (c *Claims) HasScope(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !containsAny(c.Scopes, []string{scope}) {
+			return false
+		}
+	}
+	return true
+}
+
+//认证方式
+const (
+	AMRPassword  = "pwd"
+	AMROTP       = "otp" //TOTP或恢复码
+	AMRSMS       = "sms" //短信验证码
+	AMRFederated = "fed" //第三方登录，RFC 8176中没有定义
+)
+
+//HasAMR 是否使用过某种认证方式登录，如二次验证后签发的token包含 AMROTP
+func (c *Claims) HasAMR(method string) bool {
+	return containsAny(c.AMR, []string{method})
+}
+
+//Get 获取自定义字段
+func (c *Claims) Get(key string) (interface{}, bool) {
+	value, ok := c.Extra[key]
+	return value, ok
+}
+
+//Set 设置自定义字段
+func (c *Claims) Set(key string, value interface{}) {
+	if c.Extra == nil {
+		c.Extra = make(map[string]interface{})
+	}
+	c.Extra[key] = value
+}
+
+func containsAny(values []string, targets []string) bool {
+	for _, value := range values {
+		for _, target := range targets {
+			if value == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//NewJTI 随机的token id
+func NewJTI() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,73 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToken_Claims(t *testing.T) {
+	tok := New(secret, WithIssuer("shopping"), WithAudience("app", "admin"))
+	tokenString, err := tok.Sign(&Claims{
+		UserID:    1,
+		UserName:  "tom",
+		Roles:     []string{"admin"},
+		Scopes:    []string{"order:read", "order:write"},
+		TenantID:  100,
+		DeviceID:  "iphone",
+		SessionID: "s1",
+		AMR:       []string{AMRPassword, AMROTP},
+		Extra:     map[string]interface{}{"vip": true},
+	}, time.Hour)
+	assert.NoError(t, err)
+
+	claims, err := tok.JwtParseFromAuthorizationHeader("Bearer " + tokenString)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), claims.TenantID)
+	assert.Equal(t, "s1", claims.SessionID)
+	assert.Equal(t, "shopping", claims.Issuer)
+	assert.Equal(t, "app", claims.Audience)
+	assert.Len(t, claims.Id, 32)
+	assert.True(t, claims.HasRole("user", "admin"))
+	assert.True(t, claims.HasScope("order:read", "order:write"))
+	assert.False(t, claims.HasScope("order:read", "user:write"))
+	assert.True(t, claims.HasAMR(AMROTP))
+	assert.False(t, claims.HasAMR("hwk"))
+	vip, ok := claims.Get("vip")
+	assert.True(t, ok)
+	assert.Equal(t, true, vip)
+
+	//iss aud 不一致
+	_, err = New(secret, WithIssuer("other")).JwtParse(tokenString)
+	assert.Equal(t, ErrorTokenInvalidClaims, err)
+	_, err = New(secret, WithAudience("partner")).JwtParse(tokenString)
+	assert.Equal(t, ErrorTokenInvalidClaims, err)
+	//签名错误
+	_, err = New("other secret").JwtParse(tokenString)
+	assert.Equal(t, ErrorTokenCannotParse, err)
+}
+
+func TestToken_Leeway(t *testing.T) {
+	now := time.Now()
+	tok := New(secret).(*token)
+	tokenString, err := tok.JwtSign(1, "tom", time.Minute)
+	assert.NoError(t, err)
+
+	//过期30秒
+	expired := New(secret).(*token)
+	expired.opt.now = func() time.Time { return now.Add(90 * time.Second) }
+	_, err = expired.JwtParse(tokenString)
+	assert.Equal(t, ErrorTokenExpiredOrNotActive, err)
+
+	lenient := New(secret, WithLeeway(time.Minute)).(*token)
+	lenient.opt.now = expired.opt.now
+	_, err = lenient.JwtParse(tokenString)
+	assert.NoError(t, err)
+
+	//签发方时钟快了10秒
+	early := New(secret, WithLeeway(15*time.Second)).(*token)
+	early.opt.now = func() time.Time { return now.Add(-10 * time.Second) }
+	_, err = early.JwtParse(tokenString)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,285 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt"
+)
+
+/*
+非对称签名的密钥集合，每个key通过kid区分
+轮换时新增key并设为active，旧key继续用于验证，等旧token全部过期后再删除
+*/
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmRS384 = "RS384"
+	AlgorithmRS512 = "RS512"
+	AlgorithmES256 = "ES256"
+	AlgorithmES384 = "ES384"
+	AlgorithmES512 = "ES512"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("不支持的签名算法")
+	ErrKeyNotFound          = errors.New("kid对应的key不存在")
+)
+
+//ecCurves ES算法要求的曲线
+var ecCurves = map[string]elliptic.Curve{
+	AlgorithmES256: elliptic.P256(),
+	AlgorithmES384: elliptic.P384(),
+	AlgorithmES512: elliptic.P521(),
+}
+
+//Key 一个签名key，只用于验证的key没有私钥
+type Key struct {
+	ID        string
+	Algorithm string
+	private   crypto.PrivateKey
+	public    crypto.PublicKey
+}
+
+//CanSign 是否有私钥
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+//ParseKey 解析PEM格式的key，privatePEM为空时只能用于验证，publicPEM为空时从私钥导出，都不为空时必须是一对
+//私钥支持PKCS1 PKCS8 SEC1，公钥支持PKIX
+func ParseKey(id, algorithm string, privatePEM, publicPEM []byte) (*Key, error) {
+	if id == "" {
+		return nil, errors.New("key的id不能为空")
+	}
+	if len(privatePEM) == 0 && len(publicPEM) == 0 {
+		return nil, fmt.Errorf("key %s 缺少私钥和公钥", id)
+	}
+	key := &Key{ID: id, Algorithm: algorithm}
+
+	var err error
+	if len(privatePEM) > 0 {
+		if key.private, err = parsePrivateKey(privatePEM); err != nil {
+			return nil, fmt.Errorf("key %s 私钥解析失败:%w", id, err)
+		}
+		key.public = key.private.(crypto.Signer).Public()
+	}
+	if len(publicPEM) > 0 {
+		public, err := parsePublicKey(publicPEM)
+		if err != nil {
+			return nil, fmt.Errorf("key %s 公钥解析失败:%w", id, err)
+		}
+		//同时配置时公钥必须和私钥对应，否则签发的token用这个公钥验证不通过
+		if key.public != nil && !key.public.(interface{ Equal(crypto.PublicKey) bool }).Equal(public) {
+			return nil, fmt.Errorf("key %s 的公钥和私钥不匹配", id)
+		}
+		key.public = public
+	}
+	if err := checkAlgorithm(algorithm, key.public); err != nil {
+		return nil, fmt.Errorf("key %s:%w", id, err)
+	}
+	return key, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("无法识别的私钥格式")
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	return nil, errors.New("无法识别的公钥格式")
+}
+
+//checkAlgorithm 校验key的类型和算法一致
+func checkAlgorithm(algorithm string, public crypto.PublicKey) error {
+	switch algorithm {
+	case AlgorithmRS256, AlgorithmRS384, AlgorithmRS512:
+		if _, ok := public.(*rsa.PublicKey); !ok {
+			return fmt.Errorf("%s 需要RSA key", algorithm)
+		}
+	case AlgorithmES256, AlgorithmES384, AlgorithmES512:
+		key, ok := public.(*ecdsa.PublicKey)
+		if !ok || key.Curve != ecCurves[algorithm] {
+			return fmt.Errorf("%s 需要%s曲线的EC key", algorithm, ecCurves[algorithm].Params().Name)
+		}
+	case AlgorithmEdDSA:
+		if _, ok := public.(ed25519.PublicKey); !ok {
+			return fmt.Errorf("%s 需要Ed25519 key", algorithm)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+	return nil
+}
+
+//KeyConfig 配置中的一个key
+type KeyConfig struct {
+	ID             string
+	Algorithm      string
+	PrivateKeyFile string //为空时只用于验证
+	PublicKeyFile  string //为空时从私钥导出
+}
+
+//KeySetConfig ActiveKey 为签名使用的kid
+type KeySetConfig struct {
+	ActiveKey string
+	Keys      []KeyConfig
+}
+
+//KeySet 签名使用active key，验证时按token header中的kid查找
+type KeySet struct {
+	active string
+	keys   map[string]*Key
+}
+
+//NewKeySet 从PEM文件加载全部key
+func NewKeySet(cfg *KeySetConfig) (*KeySet, error) {
+	keys := make([]*Key, 0, len(cfg.Keys))
+	for _, kc := range cfg.Keys {
+		privatePEM, err := readFile(kc.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		publicPEM, err := readFile(kc.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseKey(kc.ID, kc.Algorithm, privatePEM, publicPEM)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeySetFromKeys(cfg.ActiveKey, keys...)
+}
+
+func readFile(file string) ([]byte, error) {
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
+
+//NewKeySetFromKeys active 必须存在且有私钥
+func NewKeySetFromKeys(active string, keys ...*Key) (*KeySet, error) {
+	set := &KeySet{
+		active: active,
+		keys:   make(map[string]*Key, len(keys)),
+	}
+	for _, key := range keys {
+		if _, ok := set.keys[key.ID]; ok {
+			return nil, fmt.Errorf("key %s 重复", key.ID)
+		}
+		set.keys[key.ID] = key
+	}
+	key, ok := set.keys[active]
+	if !ok {
+		return nil, fmt.Errorf("active key %q 不存在", active)
+	}
+	if !key.CanSign() {
+		return nil, fmt.Errorf("active key %q 没有私钥", active)
+	}
+	return set, nil
+}
+
+//Active 签名使用的key
+func (s *KeySet) Active() *Key {
+	return s.keys[s.active]
+}
+
+//Key 按kid查找
+func (s *KeySet) Key(kid string) (*Key, bool) {
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+//JWK 公钥，RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+//JWKS /.well-known/jwks.json 的返回
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+//JWKS 全部公钥，按kid排序
+func (s *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(s.keys))}
+	for _, key := range s.keys {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+	return jwks
+}
+
+//JWK 导出公钥
+func (k *Key) JWK() JWK {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Algorithm}
+	encode := base64.RawURLEncoding.EncodeToString
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encode(public.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = encode(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = encode(public.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encode(public)
+	}
+	return jwk
+}
@@ -0,0 +1,161 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func privatePEM(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func publicPEM(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestKeySet_SignAndParse(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, tc := range []struct {
+		algorithm string
+		key       interface{}
+	}{
+		{AlgorithmRS256, rsaKey},
+		{AlgorithmES256, ecKey},
+		{AlgorithmEdDSA, edKey},
+	} {
+		t.Run(tc.algorithm, func(t *testing.T) {
+			key, err := ParseKey("k1", tc.algorithm, privatePEM(t, tc.key), nil)
+			assert.NoError(t, err)
+			keys, err := NewKeySetFromKeys("k1", key)
+			assert.NoError(t, err)
+
+			tokenString, err := NewWithKeySet(keys).JwtSign(1, "tom", time.Hour)
+			assert.NoError(t, err)
+			parsed, _, _ := new(jwt.Parser).ParseUnverified(tokenString, &Claims{})
+			assert.Equal(t, "k1", parsed.Header["kid"])
+			assert.Equal(t, tc.algorithm, parsed.Header["alg"])
+
+			c, err := NewWithKeySet(keys).JwtParse(tokenString)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), c.UserID)
+		})
+	}
+
+	t.Run("算法和key不匹配", func(t *testing.T) {
+		_, err := ParseKey("k1", AlgorithmES256, privatePEM(t, rsaKey), nil)
+		assert.Error(t, err)
+		_, err = ParseKey("k1", "HS256", privatePEM(t, rsaKey), nil)
+		assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	})
+
+	t.Run("公钥和私钥不匹配", func(t *testing.T) {
+		otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		_, err := ParseKey("k1", AlgorithmES256, privatePEM(t, ecKey), publicPEM(t, &otherKey.PublicKey))
+		assert.EqualError(t, err, "key k1 的公钥和私钥不匹配")
+
+		key, err := ParseKey("k1", AlgorithmES256, privatePEM(t, ecKey), publicPEM(t, &ecKey.PublicKey))
+		assert.NoError(t, err)
+		assert.True(t, key.CanSign())
+	})
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, newKey, _ := ed25519.GenerateKey(rand.Reader)
+	write := func(name string, data []byte) string {
+		file := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(file, data, 0600))
+		return file
+	}
+	oldPrivate := write("old.pem", privatePEM(t, oldKey))
+	oldPublic := write("old.pub", publicPEM(t, &oldKey.PublicKey))
+	newPrivate := write("new.pem", privatePEM(t, newKey))
+
+	before, err := NewKeySet(&KeySetConfig{
+		ActiveKey: "old",
+		Keys:      []KeyConfig{{ID: "old", Algorithm: AlgorithmES256, PrivateKeyFile: oldPrivate}},
+	})
+	assert.NoError(t, err)
+	oldToken, err := NewWithKeySet(before).JwtSign(1, "tom", time.Hour)
+	assert.NoError(t, err)
+
+	//轮换：新key签名，旧key只保留公钥用于验证
+	after, err := NewKeySet(&KeySetConfig{
+		ActiveKey: "new",
+		Keys: []KeyConfig{
+			{ID: "old", Algorithm: AlgorithmES256, PublicKeyFile: oldPublic},
+			{ID: "new", Algorithm: AlgorithmEdDSA, PrivateKeyFile: newPrivate},
+		},
+	})
+	assert.NoError(t, err)
+	newToken, err := NewWithKeySet(after).JwtSign(2, "jerry", time.Hour)
+	assert.NoError(t, err)
+
+	_, err = NewWithKeySet(after).JwtParse(oldToken)
+	assert.NoError(t, err)
+	_, err = NewWithKeySet(after).JwtParse(newToken)
+	assert.NoError(t, err)
+	//轮换前的验证方不认识新的kid
+	_, err = NewWithKeySet(before).JwtParse(newToken)
+	assert.Equal(t, ErrorTokenCannotParse, err)
+
+	jwks := after.JWKS()
+	if assert.Len(t, jwks.Keys, 2) {
+		assert.Equal(t, JWK{Kty: "OKP", Kid: "new", Use: "sig", Alg: AlgorithmEdDSA, Crv: "Ed25519", X: jwks.Keys[0].X}, jwks.Keys[0])
+		assert.Equal(t, "EC", jwks.Keys[1].Kty)
+		assert.Equal(t, "P-256", jwks.Keys[1].Crv)
+		assert.Len(t, jwks.Keys[1].X, 43)
+	}
+
+	//只有公钥的key不能作为active
+	_, err = NewKeySet(&KeySetConfig{
+		ActiveKey: "old",
+		Keys:      []KeyConfig{{ID: "old", Algorithm: AlgorithmES256, PublicKeyFile: oldPublic}},
+	})
+	assert.Error(t, err)
+}
+
+func TestKeySet_RejectHMACWithPublicKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := ParseKey("k1", AlgorithmRS256, privatePEM(t, rsaKey), nil)
+	assert.NoError(t, err)
+	keys, _ := NewKeySetFromKeys("k1", key)
+
+	//用公钥当作HMAC密钥伪造token
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: 1})
+	forged.Header["kid"] = "k1"
+	tokenString, err := forged.SignedString(publicPEM(t, &rsaKey.PublicKey))
+	assert.NoError(t, err)
+	_, err = NewWithKeySet(keys).JwtParse(tokenString)
+	assert.Equal(t, ErrorTokenCannotParse, err)
+
+	//使用secret时也不接受非HMAC算法
+	_, err = New(secret).JwtParse(mustSign(t, keys))
+	assert.Equal(t, ErrorTokenCannotParse, err)
+}
+
+func mustSign(t *testing.T, keys *KeySet) string {
+	tokenString, err := NewWithKeySet(keys).JwtSign(1, "tom", time.Hour)
+	assert.NoError(t, err)
+	return tokenString
+}
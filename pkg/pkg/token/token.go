@@ -1,7 +1,6 @@
 package token
 
 import (
-	"github.com/golang-jwt/jwt"
 	"net/url"
 	"time"
 )
@@ -13,25 +12,73 @@ import (
 type Token interface {
 	i()
 	JwtSign(userId int64, userName string, expireDuration time.Duration) (tokenString string, err error)
-	JwtParseUnsafe(tokenString string) (*claims, error)
-	JwtParse(tokenString string) (*claims, error)
-	JwtParseFromAuthorizationHeader(token string) (*claims, error)
+	//Sign 签发自定义claims，自动填充 iat nbf exp iss aud，jti为空时自动生成
+	Sign(claims *Claims, expireDuration time.Duration) (tokenString string, err error)
+	JwtParseUnsafe(tokenString string) (*Claims, error)
+	JwtParse(tokenString string) (*Claims, error)
+	JwtParseFromAuthorizationHeader(token string) (*Claims, error)
 	UrlSign(timestamp int64, path string, method string, params url.Values) (tokenString string, err error)
+	//Leeway 验证时允许的时钟偏差，黑名单等需要覆盖token有效期的地方要加上
+	Leeway() time.Duration
+}
+
+type Option func(*option)
+
+type option struct {
+	issuer   string
+	audience []string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+//WithIssuer 签发时写入iss，验证时iss必须一致
+func WithIssuer(issuer string) Option {
+	return func(opt *option) {
+		opt.issuer = issuer
+	}
+}
+
+//WithAudience 签发时写入第一个aud，验证时aud必须是其中之一
+func WithAudience(audience ...string) Option {
+	return func(opt *option) {
+		opt.audience = audience
+	}
+}
+
+//WithLeeway 验证exp nbf iat时允许的时钟偏差
+func WithLeeway(leeway time.Duration) Option {
+	return func(opt *option) {
+		opt.leeway = leeway
+	}
+}
+
+func newOption(options []Option) *option {
+	opt := &option{now: time.Now}
+	for _, f := range options {
+		f(opt)
+	}
+	return opt
 }
 
 type token struct {
 	secret string
+	keys   *KeySet //不为空时使用非对称签名，secret不再使用
+	opt    *option
 }
 
-type claims struct {
-	UserID   int64
-	UserName string
-	jwt.StandardClaims
+func New(secret string, options ...Option) Token {
+	return &token{
+		secret: secret,
+		opt:    newOption(options),
+	}
 }
 
-func New(secret string) Token {
+//NewWithKeySet 使用 RS256 ES256 EdDSA 等非对称算法签名，header中带kid
+//验证方只需要公钥，可以通过 /.well-known/jwks.json 获取
+func NewWithKeySet(keys *KeySet, options ...Option) Token {
 	return &token{
-		secret: secret,
+		keys: keys,
+		opt:  newOption(options),
 	}
 }
 
@@ -13,25 +13,65 @@ import (
 
 //生成token
 func (t *token) JwtSign(userId int64, userName string, expireDuration time.Duration) (tokenString string, err error) {
-	claimas := claims{
+	return t.Sign(&Claims{
 		UserID:   userId,
 		UserName: userName,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(expireDuration).Unix(),
-			IssuedAt:  time.Now().Unix(),
-			NotBefore: time.Now().Unix(),
-		},
+	}, expireDuration)
+}
+
+func (t *token) Sign(claims *Claims, expireDuration time.Duration) (tokenString string, err error) {
+	now := t.opt.now()
+	signed := *claims
+	signed.IssuedAt = now.Unix()
+	signed.NotBefore = now.Unix()
+	signed.ExpiresAt = now.Add(expireDuration).Unix()
+	if signed.Id == "" {
+		signed.Id = NewJTI()
+	}
+	if signed.Issuer == "" {
+		signed.Issuer = t.opt.issuer
+	}
+	if signed.Audience == "" && len(t.opt.audience) > 0 {
+		signed.Audience = t.opt.audience[0]
 	}
+	return t.sign(signed)
+}
 
-	tokenString, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claimas).SignedString([]byte(t.secret))
-	return
+//sign 有KeySet时用active key签名并在header中写入kid，否则使用HS256
+func (t *token) sign(claims jwt.Claims) (string, error) {
+	if t.keys == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(t.secret))
+	}
+	key := t.keys.Active()
+	tok := jwt.NewWithClaims(key.method(), claims)
+	tok.Header["kid"] = key.ID
+	return tok.SignedString(key.private)
+}
+
+//keyFunc 验证时使用的key，算法必须和key一致，防止用公钥当作HMAC密钥伪造token
+func (t *token) keyFunc(tok *jwt.Token) (interface{}, error) {
+	if t.keys == nil {
+		if _, ok := tok.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		return []byte(t.secret), nil
+	}
+	kid, _ := tok.Header["kid"].(string)
+	key, ok := t.keys.Key(kid)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if tok.Method.Alg() != key.Algorithm {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return key.public, nil
 }
 
-func (t *token) JwtParseUnsafe(tokenString string) (*claims, error) {
-	tokenClaims, _, err := new(jwt.Parser).ParseUnverified(tokenString, &claims{})
+func (t *token) JwtParseUnsafe(tokenString string) (*Claims, error) {
+	tokenClaims, _, err := new(jwt.Parser).ParseUnverified(tokenString, &Claims{})
 
 	if tokenClaims != nil {
-		if claims, ok := tokenClaims.Claims.(*claims); ok {
+		if claims, ok := tokenClaims.Claims.(*Claims); ok {
 			return claims, nil
 		}
 	}
@@ -41,41 +81,56 @@ func (t *token) JwtParseUnsafe(tokenString string) (*claims, error) {
 var (
 	ErrorTokenCannotParse        = errors.New("token解密失败")
 	ErrorTokenExpiredOrNotActive = errors.New("token过期或无效")
+	ErrorTokenInvalidClaims      = errors.New("token的iss或aud无效")
 )
 
-func (t *token) JwtParse(tokenString string) (*claims, error) {
-	tokenClaims, err := jwt.ParseWithClaims(tokenString, &claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(t.secret), nil
-	})
+func (t *token) JwtParse(tokenString string) (*Claims, error) {
+	//时间相关的校验需要考虑leeway，由validate处理
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	tokenClaims, err := parser.ParseWithClaims(tokenString, &Claims{}, t.keyFunc)
+	if err != nil || tokenClaims == nil || !tokenClaims.Valid {
+		return nil, ErrorTokenCannotParse
+	}
+	claims, ok := tokenClaims.Claims.(*Claims)
+	if !ok {
+		return nil, ErrorTokenCannotParse
+	}
+	if err := t.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
 
-	if tokenClaims != nil {
-		if claims, ok := tokenClaims.Claims.(*claims); ok && tokenClaims.Valid {
-			return claims, nil
-		} else {
-			if ve, ok := err.(*jwt.ValidationError); ok {
-				if ve.Errors&jwt.ValidationErrorMalformed != 0 {
-					return nil, ErrorTokenCannotParse
-				} else if ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
-					return nil, ErrorTokenExpiredOrNotActive
-				} else {
-					return nil, ErrorTokenCannotParse
-				}
-			} else {
-				return nil, ErrorTokenCannotParse
-			}
-		}
+func (t *token) Leeway() time.Duration {
+	return t.opt.leeway
+}
+
+//validate 校验 exp nbf iat iss aud
+func (t *token) validate(claims *Claims) error {
+	now := t.opt.now()
+	leeway := t.opt.leeway
+	if !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), false) ||
+		!claims.VerifyNotBefore(now.Add(leeway).Unix(), false) ||
+		!claims.VerifyIssuedAt(now.Add(leeway).Unix(), false) {
+		return ErrorTokenExpiredOrNotActive
+	}
+	if t.opt.issuer != "" && claims.Issuer != t.opt.issuer {
+		return ErrorTokenInvalidClaims
+	}
+	if len(t.opt.audience) > 0 && !containsAny(t.opt.audience, []string{claims.Audience}) {
+		return ErrorTokenInvalidClaims
 	}
-	return nil, ErrorTokenCannotParse
+	return nil
 }
 
-func (t *token) JwtParseFromAuthorizationHeader(token string) (*claims, error) {
+func (t *token) JwtParseFromAuthorizationHeader(token string) (*Claims, error) {
 	tokenString := stripBearerPrfixFromTokenString(token)
 	return t.JwtParse(tokenString)
 }
 
 //切割生成的toekn前面的‘BEARER’
 func stripBearerPrfixFromTokenString(tok string) string {
-	if len(tok) > 6 && strings.ToUpper(tok[0:7]) == "BEARER" {
+	if len(tok) > 7 && strings.ToUpper(tok[0:7]) == "BEARER " {
 		return tok[7:]
 	}
 	return tok
@@ -0,0 +1,197 @@
+//RFC 6238 基于时间的一次性密码，兼容 Google Authenticator、微软验证器等
+//秘钥使用base32编码，通过 otpauth:// URI 或二维码导入验证器
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	DefaultDigits     = 6
+	DefaultPeriod     = 30 * time.Second
+	DefaultSkew       = 1  //前后各允许偏差的周期数
+	DefaultSecretSize = 20 //RFC 4226 推荐160位
+)
+
+var ErrInvalidSecret = errors.New("totp: 秘钥格式错误")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type Option func(*option)
+
+type option struct {
+	digits int
+	period time.Duration
+	skew   int
+}
+
+//WithDigits 验证码位数，验证器普遍只支持6位和8位
+func WithDigits(digits int) Option {
+	return func(opt *option) {
+		opt.digits = digits
+	}
+}
+
+//WithPeriod 验证码的有效周期
+func WithPeriod(period time.Duration) Option {
+	return func(opt *option) {
+		opt.period = period
+	}
+}
+
+//WithSkew 允许客户端时间偏差的周期数，1表示前后各多接受一个周期的验证码
+func WithSkew(skew int) Option {
+	return func(opt *option) {
+		opt.skew = skew
+	}
+}
+
+//TOTP 算法固定为SHA1，部分验证器会忽略URI中的algorithm参数
+type TOTP struct {
+	opt *option
+}
+
+func New(options ...Option) *TOTP {
+	opt := &option{
+		digits: DefaultDigits,
+		period: DefaultPeriod,
+		skew:   DefaultSkew,
+	}
+	for _, f := range options {
+		f(opt)
+	}
+	return &TOTP{opt: opt}
+}
+
+//GenerateSecret 生成base32编码的随机秘钥
+func GenerateSecret() (string, error) {
+	secret := make([]byte, DefaultSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+//Counter 时间t所在的周期
+func (t *TOTP) Counter(at time.Time) int64 {
+	return at.Unix() / int64(t.opt.period/time.Second)
+}
+
+//Code 生成时间t的验证码
+func (t *TOTP) Code(secret string, at time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return t.hotp(key, t.Counter(at)), nil
+}
+
+//hotp RFC 4226 动态截断
+func (t *TOTP) hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < t.opt.digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", t.opt.digits, value%mod)
+}
+
+//Validate 校验验证码，返回匹配的周期
+//调用方需要保存最后一次使用的周期，只接受更大的周期，避免同一个验证码被重复使用
+func (t *TOTP) Validate(secret, code string, at time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != t.opt.digits {
+		return 0, false
+	}
+	current := t.Counter(at)
+	for i := -t.opt.skew; i <= t.opt.skew; i++ {
+		counter := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(t.hotp(key, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+//URI otpauth://totp/issuer:account?secret=...&issuer=... 用于生成二维码或手动导入
+func (t *TOTP) URI(issuer, account, secret string) string {
+	label := url.PathEscape(account)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	query := url.Values{}
+	query.Set("secret", secret)
+	if issuer != "" {
+		query.Set("issuer", issuer)
+	}
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(t.opt.digits))
+	query.Set("period", fmt.Sprint(int(t.opt.period/time.Second)))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+//QRCode 把URI编码为二维码PNG，size为图片边长(像素)
+func QRCode(uri string, size int) ([]byte, error) {
+	return qrcode.Encode(uri, qrcode.Medium, size)
+}
+
+//recoveryAlphabet 去掉容易混淆的 0 1 I O
+const recoveryAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+//GenerateRecoveryCodes 生成n个恢复码，格式为 XXXXX-XXXXX，每个约50位熵
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	buf := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := make([]byte, 0, 11)
+		for j, b := range buf {
+			if j == 5 {
+				code = append(code, '-')
+			}
+			code = append(code, recoveryAlphabet[int(b)%len(recoveryAlphabet)])
+		}
+		codes[i] = string(code)
+	}
+	return codes, nil
+}
+
+//HashRecoveryCode 恢复码只保存hash，忽略大小写和分隔符
+//恢复码是随机生成的，熵足够高，不需要加盐和慢hash
+func HashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
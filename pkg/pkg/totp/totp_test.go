@@ -0,0 +1,91 @@
+package totp
+
+import (
+	"bytes"
+	"image/png"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTP_Code(t *testing.T) {
+	//RFC 6238 附录B的SHA1测试数据
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+	totp := New(WithDigits(8))
+	for unix, want := range map[int64]string{
+		59:         "94287082",
+		1111111109: "07081804",
+		1111111111: "14050471",
+		1234567890: "89005924",
+		2000000000: "69279037",
+	} {
+		code, err := totp.Code(secret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, want, code, unix)
+	}
+
+	_, err := totp.Code("not base32!", time.Now())
+	assert.Equal(t, ErrInvalidSecret, err)
+}
+
+func TestTOTP_Validate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	totp := New()
+	now := time.Unix(1700000000, 0)
+	code, err := totp.Code(secret, now)
+	assert.NoError(t, err)
+
+	counter, ok := totp.Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, totp.Counter(now), counter)
+
+	//允许前后一个周期的偏差
+	_, ok = totp.Validate(secret, code, now.Add(DefaultPeriod))
+	assert.True(t, ok)
+	_, ok = totp.Validate(secret, code, now.Add(-DefaultPeriod))
+	assert.True(t, ok)
+	_, ok = totp.Validate(secret, code, now.Add(2*DefaultPeriod))
+	assert.False(t, ok)
+
+	_, ok = totp.Validate(secret, "12345", now)
+	assert.False(t, ok)
+	_, ok = New(WithSkew(0)).Validate(secret, code, now.Add(DefaultPeriod))
+	assert.False(t, ok)
+}
+
+func TestTOTP_URI(t *testing.T) {
+	uri := New().URI("shopping", "tom@test.com", "JBSWY3DPEHPK3PXP")
+	u, err := url.Parse(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/shopping:tom@test.com", u.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	assert.Equal(t, "shopping", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+
+	raw, err := QRCode(uri, 256)
+	assert.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, 256, img.Bounds().Dx())
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+		assert.Equal(t, byte('-'), code[5])
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+	assert.Equal(t, HashRecoveryCode(codes[0]), HashRecoveryCode(" "+strings.ToLower(codes[0])))
+	assert.NotEqual(t, HashRecoveryCode(codes[0]), HashRecoveryCode(codes[1]))
+}
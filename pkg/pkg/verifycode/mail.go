@@ -0,0 +1,205 @@
+package verifycode
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Mail 一封html邮件
+type Mail struct {
+	To      string
+	Subject string
+	HTML    string
+}
+
+//Mailer 发送邮件，开发测试时使用 FileMailer MemoryMailer
+type Mailer interface {
+	SendMail(ctx context.Context, mail *Mail) error
+}
+
+//SMTPConfig smtp服务，端口为465时使用SSL，其他端口服务端支持时使用STARTTLS
+type SMTPConfig struct {
+	Host   string
+	Port   string
+	Sender string //发件人邮箱，同时作为登录用户名
+	Secret string //授权码
+}
+
+type smtpMailer struct {
+	cfg *SMTPConfig
+}
+
+func NewSMTPMailer(cfg *SMTPConfig) Mailer {
+	return &smtpMailer{cfg: cfg}
+}
+
+var ErrInvalidAddress = errors.New("verifycode: 收件人地址无效")
+
+func (m *smtpMailer) SendMail(ctx context.Context, mail *Mail) error {
+	//防止在邮件头中注入其他字段
+	if mail.To == "" || strings.ContainsAny(mail.To, "\r\n") {
+		return ErrInvalidAddress
+	}
+	addr := net.JoinHostPort(m.cfg.Host, m.cfg.Port)
+	auth := smtp.PlainAuth("", m.cfg.Sender, m.cfg.Secret, m.cfg.Host)
+	msg := buildMessage(m.cfg.Sender, mail)
+	if m.cfg.Port != "465" {
+		return smtp.SendMail(addr, auth, m.cfg.Sender, []string{mail.To}, msg)
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: m.cfg.Host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+	if err := client.Auth(auth); err != nil {
+		return err
+	}
+	if err := client.Mail(m.cfg.Sender); err != nil {
+		return err
+	}
+	if err := client.Rcpt(mail.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func buildMessage(from string, mail *Mail) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + mail.To + "\r\n")
+	buf.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", mail.Subject) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(mail.HTML)
+	return buf.Bytes()
+}
+
+type fileMailer struct {
+	dir string
+}
+
+//NewFileMailer 邮件写入dir目录下的html文件，文件名为 时间_收件人.html，开发环境使用
+func NewFileMailer(dir string) Mailer {
+	return &fileMailer{dir: dir}
+}
+
+func (m *fileMailer) SendMail(ctx context.Context, mail *Mail) error {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s_%s.html", time.Now().Format("20060102150405.000000"), strings.NewReplacer("/", "_", "\\", "_").Replace(mail.To))
+	content := fmt.Sprintf("<!-- To: %s -->\n<!-- Subject: %s -->\n%s", mail.To, mail.Subject, mail.HTML)
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(content), 0644)
+}
+
+//MemoryMailer 邮件保存在内存中，测试使用
+type MemoryMailer struct {
+	mu    sync.Mutex
+	mails []*Mail
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) SendMail(ctx context.Context, mail *Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = append(m.mails, mail)
+	return nil
+}
+
+//Mails 已发送的全部邮件
+func (m *MemoryMailer) Mails() []*Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Mail(nil), m.mails...)
+}
+
+//Last 发送给to的最后一封邮件，没有时返回nil
+func (m *MemoryMailer) Last(to string) *Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.mails) - 1; i >= 0; i-- {
+		if m.mails[i].To == to {
+			return m.mails[i]
+		}
+	}
+	return nil
+}
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+var subjects = map[Purpose]string{
+	PurposeRegister:      "注册验证码",
+	PurposeResetPassword: "重置密码验证码",
+	PurposeChangeEmail:   "更换邮箱验证码",
+	PurposeUnlock:        "账号解锁验证码",
+}
+
+var ErrTemplateNotFound = errors.New("verifycode: 找不到邮件模板")
+
+type emailSender struct {
+	mailer  Mailer
+	appName string
+}
+
+//NewEmailSender 按用途使用 templates/<purpose>.html 渲染验证码邮件，appName 用于邮件标题和正文
+func NewEmailSender(mailer Mailer, appName string) Sender {
+	return &emailSender{mailer: mailer, appName: appName}
+}
+
+func (s *emailSender) Send(ctx context.Context, to string, purpose Purpose, code string, ttl time.Duration) error {
+	tpl := templates.Lookup(string(purpose) + ".html")
+	if tpl == nil {
+		return ErrTemplateNotFound
+	}
+	var buf bytes.Buffer
+	err := tpl.Execute(&buf, map[string]interface{}{
+		"AppName": s.appName,
+		"Code":    code,
+		"Minutes": int(ttl.Minutes()),
+	})
+	if err != nil {
+		return err
+	}
+	return s.mailer.SendMail(ctx, &Mail{
+		To:      to,
+		Subject: fmt.Sprintf("【%s】%s", s.appName, subjects[purpose]),
+		HTML:    buf.String(),
+	})
+}
@@ -0,0 +1,118 @@
+package verifycode
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//SMS 一条短信，国内短信服务商按模板发送，Template 为模板编号，Params 为模板参数
+//Content 为渲染后的内容，只用于开发环境的日志和文件
+type SMS struct {
+	To       string
+	Template string
+	Params   map[string]string
+	Content  string
+}
+
+//SMSProvider 短信服务商，开发测试时使用 FileSMSProvider LogSMSProvider MemorySMSProvider
+type SMSProvider interface {
+	SendSMS(ctx context.Context, sms *SMS) error
+}
+
+type smsSender struct {
+	provider  SMSProvider
+	appName   string
+	templates map[Purpose]string
+}
+
+//NewSMSSender 短信验证码，templates 为每种用途在短信服务商配置的模板编号，模板参数为 code minutes
+func NewSMSSender(provider SMSProvider, appName string, templates map[Purpose]string) Sender {
+	return &smsSender{provider: provider, appName: appName, templates: templates}
+}
+
+func (s *smsSender) Send(ctx context.Context, to string, purpose Purpose, code string, ttl time.Duration) error {
+	minutes := int(ttl.Minutes())
+	return s.provider.SendSMS(ctx, &SMS{
+		To:       to,
+		Template: s.templates[purpose],
+		Params:   map[string]string{"code": code, "minutes": strconv.Itoa(minutes)},
+		Content:  fmt.Sprintf("【%s】您的验证码为%s，%d分钟内有效，请勿泄露给他人。", s.appName, code, minutes),
+	})
+}
+
+type fileSMSProvider struct {
+	mu  sync.Mutex
+	dir string
+}
+
+//NewFileSMSProvider 短信追加写入dir目录下的 sms.log，开发环境使用
+func NewFileSMSProvider(dir string) SMSProvider {
+	return &fileSMSProvider{dir: dir}
+}
+
+func (p *fileSMSProvider) SendSMS(ctx context.Context, sms *SMS) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(p.dir, "sms.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\t%s\n", time.Now().Format("2006-01-02 15:04:05.000"), sms.To, sms.Template, sms.Content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type logSMSProvider struct {
+	logger *zap.Logger
+}
+
+//NewLogSMSProvider 短信内容写入日志，开发环境使用；手机号会被日志脱敏，内容中的验证码不会
+func NewLogSMSProvider(logger *zap.Logger) SMSProvider {
+	return &logSMSProvider{logger: logger}
+}
+
+func (p *logSMSProvider) SendSMS(ctx context.Context, sms *SMS) error {
+	p.logger.Info("send sms", zap.String("phone", sms.To), zap.String("template", sms.Template), zap.String("content", sms.Content))
+	return nil
+}
+
+//MemorySMSProvider 短信保存在内存中，测试使用
+type MemorySMSProvider struct {
+	mu       sync.Mutex
+	messages []*SMS
+}
+
+func NewMemorySMSProvider() *MemorySMSProvider {
+	return &MemorySMSProvider{}
+}
+
+func (p *MemorySMSProvider) SendSMS(ctx context.Context, sms *SMS) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, sms)
+	return nil
+}
+
+//Last 发送给to的最后一条短信，没有时返回nil
+func (p *MemorySMSProvider) Last(to string) *SMS {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := len(p.messages) - 1; i >= 0; i-- {
+		if p.messages[i].To == to {
+			return p.messages[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+//验证码发送和校验，邮件、短信共用
+//验证码保存在redis中，有效期内只能使用一次，错误次数超过限制后失效；同一个接收方发送有冷却时间
+package verifycode
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github/xujialingit/shopping-app/pkg/cache"
+	"math/big"
+	"time"
+)
+
+//Purpose 验证码用途，不同用途的验证码不能混用
+type Purpose string
+
+const (
+	PurposeRegister      Purpose = "register"
+	PurposeResetPassword Purpose = "reset_password"
+	PurposeChangeEmail   Purpose = "change_email"
+	PurposeUnlock        Purpose = "unlock"
+)
+
+var purposes = map[Purpose]bool{
+	PurposeRegister:      true,
+	PurposeResetPassword: true,
+	PurposeChangeEmail:   true,
+	PurposeUnlock:        true,
+}
+
+//RegisterPurpose 注册新的用途，如短信登录，需要在init中调用
+func RegisterPurpose(purpose Purpose) {
+	purposes[purpose] = true
+}
+
+//ParsePurpose 校验接口传入的用途
+func ParsePurpose(s string) (Purpose, error) {
+	purpose := Purpose(s)
+	if !purposes[purpose] {
+		return "", ErrInvalidPurpose
+	}
+	return purpose, nil
+}
+
+var (
+	ErrInvalidPurpose  = errors.New("verifycode: 验证码类型错误")
+	ErrCooldown        = errors.New("verifycode: 发送过于频繁")
+	ErrCodeInvalid     = errors.New("verifycode: 验证码错误或已过期")
+	ErrTooManyAttempts = errors.New("verifycode: 验证码错误次数过多，请重新获取")
+)
+
+const (
+	DefaultTTL         = 10 * time.Minute
+	DefaultCooldown    = time.Minute
+	DefaultMaxAttempts = 5
+	DefaultLength      = 6
+	DefaultKeyPrefix   = "verifycode:"
+)
+
+//Sender 把验证码发送给接收方，如邮件、短信
+type Sender interface {
+	Send(ctx context.Context, to string, purpose Purpose, code string, ttl time.Duration) error
+}
+
+type Service interface {
+	i()
+	//Send 生成并发送验证码，冷却时间内重复发送返回 ErrCooldown
+	Send(ctx context.Context, purpose Purpose, to string) error
+	//Verify 校验验证码，成功后验证码失效
+	Verify(ctx context.Context, purpose Purpose, to string, code string) error
+}
+
+type Option func(*option)
+
+type option struct {
+	ttl         time.Duration
+	cooldown    time.Duration
+	maxAttempts int
+	length      int
+	keyPrefix   string
+}
+
+//WithTTL 验证码有效期
+func WithTTL(ttl time.Duration) Option {
+	return func(opt *option) {
+		opt.ttl = ttl
+	}
+}
+
+//WithCooldown 同一个接收方两次发送的最小间隔
+func WithCooldown(cooldown time.Duration) Option {
+	return func(opt *option) {
+		opt.cooldown = cooldown
+	}
+}
+
+//WithMaxAttempts 验证码允许输错的次数
+func WithMaxAttempts(maxAttempts int) Option {
+	return func(opt *option) {
+		opt.maxAttempts = maxAttempts
+	}
+}
+
+//WithLength 验证码位数
+func WithLength(length int) Option {
+	return func(opt *option) {
+		opt.length = length
+	}
+}
+
+//WithKeyPrefix redis key前缀，邮件和短信共用一个redis时需要区分
+func WithKeyPrefix(prefix string) Option {
+	return func(opt *option) {
+		opt.keyPrefix = prefix
+	}
+}
+
+type service struct {
+	cache  cache.Repo
+	sender Sender
+	opt    *option
+}
+
+func New(repo cache.Repo, sender Sender, options ...Option) Service {
+	opt := &option{
+		ttl:         DefaultTTL,
+		cooldown:    DefaultCooldown,
+		maxAttempts: DefaultMaxAttempts,
+		length:      DefaultLength,
+		keyPrefix:   DefaultKeyPrefix,
+	}
+	for _, f := range options {
+		f(opt)
+	}
+	return &service{
+		cache:  repo,
+		sender: sender,
+		opt:    opt,
+	}
+}
+
+func (s *service) i() {}
+
+func (s *service) codeKey(purpose Purpose, to string) string {
+	return fmt.Sprintf("%s%s:%s", s.opt.keyPrefix, purpose, to)
+}
+
+func (s *service) cooldownKey(to string) string {
+	return fmt.Sprintf("%scooldown:%s", s.opt.keyPrefix, to)
+}
+
+func (s *service) Send(ctx context.Context, purpose Purpose, to string) error {
+	if !purposes[purpose] {
+		return ErrInvalidPurpose
+	}
+	client := s.cache.Client()
+	if s.opt.cooldown > 0 {
+		ok, err := client.SetNX(ctx, s.cooldownKey(to), "1", s.opt.cooldown).Result()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrCooldown
+		}
+	}
+
+	code, err := generateCode(s.opt.length)
+	if err != nil {
+		return err
+	}
+	key := s.codeKey(purpose, to)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, "code", hashCode(code), "attempts", 0)
+		pipe.PExpire(ctx, key, s.opt.ttl)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.sender.Send(ctx, to, purpose, code, s.opt.ttl); err != nil {
+		//发送失败时允许立即重试
+		client.Del(ctx, key, s.cooldownKey(to))
+		return err
+	}
+	return nil
+}
+
+//KEYS[1] 验证码 ARGV[1] 验证码的hash ARGV[2] 允许输错的次数
+//返回 1 正确 0 不存在或错误 -1 错误次数过多
+var verifyScript = redis.NewScript(`
+local code = redis.call('HGET', KEYS[1], 'code')
+if not code then
+	return 0
+end
+if code == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+	return 1
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+if attempts >= tonumber(ARGV[2]) then
+	redis.call('DEL', KEYS[1])
+	return -1
+end
+return 0
+`)
+
+func (s *service) Verify(ctx context.Context, purpose Purpose, to string, code string) error {
+	if !purposes[purpose] {
+		return ErrInvalidPurpose
+	}
+	if code == "" {
+		return ErrCodeInvalid
+	}
+	result, err := verifyScript.Run(ctx, s.cache.Client(), []string{s.codeKey(purpose, to)},
+		hashCode(code), s.opt.maxAttempts).Int()
+	if err != nil {
+		return err
+	}
+	switch result {
+	case 1:
+		return nil
+	case -1:
+		return ErrTooManyAttempts
+	default:
+		return ErrCodeInvalid
+	}
+}
+
+//hashCode redis中不保存验证码明文
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateCode(length int) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
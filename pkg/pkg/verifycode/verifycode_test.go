@@ -0,0 +1,109 @@
+package verifycode
+
+import (
+	"context"
+	"github/xujialingit/shopping-app/pkg/cache/cachetest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var codeRegexp = regexp.MustCompile(`>(\d{6})<`)
+
+func lastCode(t *testing.T, mailer *MemoryMailer, to string) string {
+	mail := mailer.Last(to)
+	if !assert.NotNil(t, mail) {
+		return ""
+	}
+	match := codeRegexp.FindStringSubmatch(mail.HTML)
+	if !assert.Len(t, match, 2) {
+		return ""
+	}
+	return match[1]
+}
+
+func TestService(t *testing.T) {
+	cacheRepo := cachetest.New(t)
+
+	ctx := context.Background()
+	mailer := NewMemoryMailer()
+	svc := New(cacheRepo, NewEmailSender(mailer, "shopping"), WithCooldown(time.Second), WithMaxAttempts(3))
+	to := time.Now().Format("150405.000000") + "@test.com"
+
+	t.Run("发送并校验", func(t *testing.T) {
+		assert.NoError(t, svc.Send(ctx, PurposeRegister, to))
+		assert.Equal(t, "【shopping】注册验证码", mailer.Last(to).Subject)
+		code := lastCode(t, mailer, to)
+
+		assert.Equal(t, ErrCooldown, svc.Send(ctx, PurposeRegister, to))
+		//用途不同不能混用
+		assert.Equal(t, ErrCodeInvalid, svc.Verify(ctx, PurposeResetPassword, to, code))
+		assert.NoError(t, svc.Verify(ctx, PurposeRegister, to, code))
+		//只能使用一次
+		assert.Equal(t, ErrCodeInvalid, svc.Verify(ctx, PurposeRegister, to, code))
+	})
+
+	t.Run("错误次数过多", func(t *testing.T) {
+		other := "other" + to
+		assert.NoError(t, svc.Send(ctx, PurposeResetPassword, other))
+		code := lastCode(t, mailer, other)
+
+		assert.Equal(t, ErrCodeInvalid, svc.Verify(ctx, PurposeResetPassword, other, "000000x"))
+		assert.Equal(t, ErrCodeInvalid, svc.Verify(ctx, PurposeResetPassword, other, "000000y"))
+		assert.Equal(t, ErrTooManyAttempts, svc.Verify(ctx, PurposeResetPassword, other, "000000z"))
+		assert.Equal(t, ErrCodeInvalid, svc.Verify(ctx, PurposeResetPassword, other, code))
+	})
+
+	t.Run("无效的类型", func(t *testing.T) {
+		_, err := ParsePurpose("login")
+		assert.Equal(t, ErrInvalidPurpose, err)
+		assert.Equal(t, ErrInvalidPurpose, svc.Send(ctx, Purpose("login"), to))
+	})
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	sender := NewEmailSender(NewFileMailer(dir), "shopping")
+	assert.NoError(t, sender.Send(context.Background(), "a@test.com", PurposeChangeEmail, "123456", 10*time.Minute))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*_a@test.com.html"))
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		content, err := os.ReadFile(files[0])
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), "123456")
+		assert.Contains(t, string(content), "10分钟内有效")
+		assert.Contains(t, string(content), "更换邮箱验证码")
+	}
+}
+
+func TestSMSSender(t *testing.T) {
+	provider := NewMemorySMSProvider()
+	sender := NewSMSSender(provider, "shopping", map[Purpose]string{PurposeRegister: "SMS_001"})
+	assert.NoError(t, sender.Send(context.Background(), "13800138000", PurposeRegister, "123456", 5*time.Minute))
+	sms := provider.Last("13800138000")
+	if assert.NotNil(t, sms) {
+		assert.Equal(t, "SMS_001", sms.Template)
+		assert.Equal(t, map[string]string{"code": "123456", "minutes": "5"}, sms.Params)
+		assert.Equal(t, "【shopping】您的验证码为123456，5分钟内有效，请勿泄露给他人。", sms.Content)
+	}
+	assert.Nil(t, provider.Last("13900139000"))
+
+	dir := t.TempDir()
+	sender = NewSMSSender(NewFileSMSProvider(dir), "shopping", nil)
+	assert.NoError(t, sender.Send(context.Background(), "13800138000", PurposeUnlock, "654321", 5*time.Minute))
+	assert.NoError(t, sender.Send(context.Background(), "13900139000", PurposeUnlock, "111111", 5*time.Minute))
+	content, err := os.ReadFile(filepath.Join(dir, "sms.log"))
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Contains(t, lines[0], "13800138000")
+		assert.Contains(t, lines[0], "654321")
+		assert.Contains(t, lines[1], "111111")
+	}
+}